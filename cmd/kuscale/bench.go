@@ -0,0 +1,71 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"os"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog"
+
+	kuprofiler "github.com/sslab-konkuk/KuScale/pkg/kuprofiler"
+	kusimulator "github.com/sslab-konkuk/KuScale/pkg/kusimulator"
+)
+
+// bench times the ticks of the control loop with synthetic pods, once for
+// every number of workers.
+// Usage : kuscale bench [-pods 300] [-workers 1,8,32] [-ioLatency 200us] [-stages]
+func bench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	klog.InitFlags(fs)
+
+	var config kusimulator.BenchConfig
+	var workers string
+	var jsonOutput, stages bool
+	fs.IntVar(&config.Pods, "pods", 300, "Synthetic pods")
+	fs.IntVar(&config.Ticks, "ticks", 20, "Ticks to time")
+	fs.StringVar(&workers, "workers", "1,8", "Comma-separated numbers of workers to compare")
+	fs.DurationVar(&config.IOLatency, "ioLatency", 0, "Latency added to every file read and write, at least the sleep granularity of the host")
+	fs.Float64Var(&config.Period, "MonitoringPeriod", 2, "MonitoringPeriod in seconds")
+	fs.Float64Var(&config.StaticV, "staticV", 10, "Static V Weight")
+	fs.BoolVar(&jsonOutput, "json", false, "Print the report in JSON")
+	fs.BoolVar(&stages, "stages", false, "Print the latency histograms of the read, decide and write stages")
+	fs.Parse(args)
+
+	kuprofiler.NewLatencyInfo(stages)
+
+	var reports []*kusimulator.BenchReport
+	for _, w := range strings.Split(workers, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(w))
+		if err != nil || n < 1 {
+			klog.Error("Invalid number of workers ", w)
+			os.Exit(1)
+		}
+		config.Workers = n
+		reports = append(reports, kusimulator.RunBench(config))
+	}
+
+	if jsonOutput {
+		kusimulator.WriteBenchJSON(os.Stdout, reports)
+	} else {
+		kusimulator.WriteBenchTable(os.Stdout, reports)
+	}
+	if stages {
+		kuprofiler.Summary()
+	}
+	klog.Flush()
+}
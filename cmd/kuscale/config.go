@@ -0,0 +1,60 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"strings"
+
+	"k8s.io/klog"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kuconfig"
+	"github.com/sslab-konkuk/KuScale/pkg/kumonitor"
+)
+
+func setPrices(c *kuconfig.Config) {
+	kumonitor.SetPrice("CPU", c.Prices.CPU)
+	kumonitor.SetPrice("GPU", c.Prices.GPU)
+}
+
+/*
+Func Name : reloadConfig()
+Objective : 1) Load the config file again, keeping the running config if invalid
+			2) Apply the reloadable settings to the monitor
+			3) Warn about the changed settings which need a restart
+*/
+func reloadConfig(path string, running *kuconfig.Config, monitor *kumonitor.Monitor) {
+	next, err := kuconfig.Load(path, flag.CommandLine)
+	if err != nil {
+		klog.Error("Keeping the running config : ", err)
+		return
+	}
+	policy, _ := next.NewPolicy() // validated by Load
+	policyChanged := running.Policy != next.Policy
+
+	reloaded, restart := running.Reload(next)
+	if len(restart) > 0 {
+		klog.Warning("Restart KuScale to apply ", strings.Join(restart, ", "))
+	}
+	if len(reloaded) == 0 {
+		klog.V(4).Info("Nothing to reload from ", path)
+		return
+	}
+	if policyChanged {
+		monitor.SetPolicy(policy)
+	}
+	setPrices(running)
+	klog.V(4).Info("Reloaded ", strings.Join(reloaded, ", "), " from ", path)
+}
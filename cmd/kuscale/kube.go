@@ -0,0 +1,48 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kuconfig"
+	"github.com/sslab-konkuk/KuScale/pkg/kuinformer"
+	"github.com/sslab-konkuk/KuScale/pkg/kumonitor"
+	"github.com/sslab-konkuk/KuScale/pkg/kusync"
+)
+
+// kubeClient connects to the Kubernetes API with kubeconfig, or with the
+// in-cluster config of the service account if it is empty.
+func kubeClient(kubeconfig string) (kubernetes.Interface, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	config.UserAgent = "kuscale"
+	return kubernetes.NewForConfig(config)
+}
+
+func newPodSyncer(client kubernetes.Interface, cfg *kuconfig.Config, monitor *kumonitor.Monitor) (*kusync.Syncer, error) {
+	interval := time.Duration(cfg.PodSync.Interval * float64(time.Second))
+	return kusync.NewSyncer(client, monitor, cfg.PodSync.Mode, interval, cfg.PodSync.MinChange, cfg.PodSync.QPS, cfg.PodSync.Burst)
+}
+
+func newPodInformer(client kubernetes.Interface, cfg *kuconfig.Config, monitor *kumonitor.Monitor) *kuinformer.Informer {
+	resync := time.Duration(cfg.PodInformer.Resync * float64(time.Second))
+	return kuinformer.NewInformer(client, cfg.NodeName, resync, monitor)
+}
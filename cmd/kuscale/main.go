@@ -18,81 +18,256 @@ package main
 
 import (
 	"flag"
+	"net/http"
+	"os"
+	"runtime"
 	"time"
 
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog"
 
 	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
 
 	// kucontroller "github.com/sslab-konkuk/KuScale/pkg/kucontroller"
 
+	"github.com/sslab-konkuk/KuScale/pkg/kuapi"
+	"github.com/sslab-konkuk/KuScale/pkg/kuaudit"
+	"github.com/sslab-konkuk/KuScale/pkg/kuconfig"
 	kuexporter "github.com/sslab-konkuk/KuScale/pkg/kuexporter"
+	"github.com/sslab-konkuk/KuScale/pkg/kufs"
+	"github.com/sslab-konkuk/KuScale/pkg/kugpu"
+	"github.com/sslab-konkuk/KuScale/pkg/kuhealth"
+	"github.com/sslab-konkuk/KuScale/pkg/kuinformer"
+	"github.com/sslab-konkuk/KuScale/pkg/kuledger"
 	"github.com/sslab-konkuk/KuScale/pkg/kumonitor"
 	kuprofiler "github.com/sslab-konkuk/KuScale/pkg/kuprofiler"
+	"github.com/sslab-konkuk/KuScale/pkg/kuruntime"
+	"github.com/sslab-konkuk/KuScale/pkg/kusync"
 	kutokenmanager "github.com/sslab-konkuk/KuScale/pkg/kutokenmanager"
+	kutrace "github.com/sslab-konkuk/KuScale/pkg/kutrace"
 	kuwatcher "github.com/sslab-konkuk/KuScale/pkg/kuwatcher"
 )
 
 var (
-	nodeName         string
-	monitoringPeriod int64
-	windowSize       int64
-
-	monitoringMode bool
-	exporterMode   bool
-	bpfwatcherMode bool
-
-	staticV float64
+	configFile string
+	cfg        = kuconfig.Default()
 )
 
 func init() {
-	flag.StringVar(&nodeName, "NodeName", "node4", "NodeName")
-
-	flag.Int64Var(&monitoringPeriod, "MonitoringPeriod", 2, "MonitoringPeriod")
-	flag.Int64Var(&windowSize, "WindowSize", 15, "WindowSize")
-
-	flag.BoolVar(&monitoringMode, "MonitoringMode", true, "MonitoringMode")
-	flag.BoolVar(&exporterMode, "exporterMode", true, "exporterMode")
-	flag.BoolVar(&bpfwatcherMode, "bpfwatcherMode", false, "bpfwatcherMode")
-
-	flag.Float64Var(&staticV, "staticV", 10, "Static V Weight")
+	flag.StringVar(&configFile, "config", "", "YAML config file, reloaded on change. The flags given take precedence over it")
+	cfg.AddFlags(flag.CommandLine)
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		simulate(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		replay(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		bench(os.Args[2:])
+		return
+	}
+
 	klog.InitFlags(nil)
 	flag.Parse()
+	if configFile != "" {
+		fileCfg, err := kuconfig.Load(configFile, flag.CommandLine)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		cfg = fileCfg
+	} else if err := cfg.Validate(); err != nil {
+		klog.Fatal(err)
+	}
+	setPrices(cfg)
 	kuprofiler.NewLatencyInfo(false)
 	newPodCh := make(chan string, 10)
+	hostFS := kufs.NewOSFS(cfg.HostRoot)
 
 	/* Run Signal Watcher */
 	stopCh := kuwatcher.SignalWatcher()
 
 	// Run Ku BPF Watcher
 	ebpfCh := make(chan string, 1000)
-	if bpfwatcherMode {
-		go kuwatcher.BpfWatcher(ebpfCh, stopCh)
+	if cfg.BPFWatcher {
+		go kuwatcher.BpfWatcher(ebpfCh, stopCh, hostFS, cfg.Roots.Proc, cfg.Gemini.Hook)
+	}
+
+	// Load KU GPU Layer Module
+	gpuLifecycle := kugpu.NewLifecycle(kugpu.New(hostFS, cfg.Roots.GPU), hostFS, kugpu.KernelLoader{}, cfg.GPUModule.Path, cfg.Roots.Proc)
+	gpuLifecycle.Checksum = cfg.GPUModule.SHA256
+	if err := gpuLifecycle.EnsureLoaded(); err != nil {
+		klog.Error("KU GPU Layer Module : ", err)
 	}
 
 	// Run Ku Monitor
-	monitor := kumonitor.NewMonitor(monitoringPeriod, windowSize, nodeName, monitoringMode, staticV)
+	monitor := kumonitor.NewMonitor(cfg.Monitor.PeriodDuration(), cfg.Monitor.WindowSize, cfg.NodeName, cfg.Monitor.MonitoringMode,
+		cfg.Policy.StaticV, hostFS, cfg.Roots)
+	policy, err := cfg.NewPolicy()
+	if err != nil {
+		klog.Fatal(err)
+	}
+	monitor.SetPolicy(policy)
+	monitor.SetWorkers(cfg.Monitor.Workers)
+	if updater, err := kuruntime.New(cfg.Runtime.LimitWriter, cfg.Runtime.CRISocket); err != nil {
+		klog.Fatal(err)
+	} else if updater != nil {
+		if err := monitor.SetRuntime(updater); err != nil {
+			klog.Fatal(err)
+		}
+		klog.Info("Writing the CPU limits through ", updater.Name())
+	}
+	go runOperations(configFile, cfg, monitor, stopCh)
+
+	// Record Usage/Limit Trace
+	if cfg.Trace.Dir != "" {
+		recorder, err := kutrace.NewRecorder(monitor, cfg.Trace.Dir, cfg.Trace.MaxSizeMB<<20, cfg.Trace.MaxFiles)
+		if err != nil {
+			klog.Fatal("Couldn't record trace : ", err)
+		}
+		defer recorder.Close()
+		monitor.AddObserver(recorder)
+	}
+	// Write Audit Log of Limit Decisions
+	if cfg.Audit.Dir != "" {
+		audit, err := kuaudit.Open(cfg.Audit.Dir, cfg.Audit.MaxSizeMB<<20, cfg.Audit.MaxFiles)
+		if err != nil {
+			klog.Fatal("Couldn't open the audit log : ", err)
+		}
+		defer audit.Close()
+		monitor.AddObserver(audit)
+	}
+	// Keep Token Ledger, running without it rather than not at all
+	ledgerDir := cfg.LedgerDir
+	if ledgerDir != "" {
+		if ledger, err := kuledger.Open(ledgerDir); err != nil {
+			klog.Error("Couldn't open the token ledger, running without it : ", err)
+			ledgerDir = ""
+		} else {
+			defer ledger.Close()
+			monitor.AddObserver(ledger)
+		}
+	}
+	capacity := map[kumonitor.ResourceName]float64{
+		"CPU": float64(runtime.NumCPU() * 100),
+		"GPU": float64(cfg.GPUs * 100),
+	}
+	monitor.SetCapacity(capacity)
+
+	// Serve Control API
+	var apiServer *kuapi.Server
+	var exporterHandler http.Handler
+	if cfg.Control.Socket != "" || (cfg.Exporter.Enabled && cfg.Exporter.API) {
+		apiServer = kuapi.NewServer(monitor, cfg.Control.HistorySize)
+		apiServer.TraceDir = cfg.Trace.Dir
+		apiServer.LedgerDir = ledgerDir
+		apiServer.Capacity = capacity
+		if cfg.Exporter.APIWrite {
+			exporterHandler = apiServer
+		} else if cfg.Exporter.API {
+			exporterHandler = apiServer.ReadOnly()
+		}
+	}
+	if cfg.Control.Socket != "" {
+		go func() {
+			if err := apiServer.Run(cfg.Control.Socket, stopCh); err != nil {
+				klog.Error("Control API stopped : ", err)
+			}
+		}()
+	}
+	// Watch the Control Loop
+	var watchdog *kumonitor.Watchdog
+	if cfg.Watchdog.Enabled && !cfg.Monitor.MonitoringMode {
+		safe := map[kumonitor.ResourceName]float64{"CPU": cfg.Watchdog.FailSafeLimits.CPU, "GPU": cfg.Watchdog.FailSafeLimits.GPU}
+		watchdog = kumonitor.NewWatchdog(monitor, time.Duration(cfg.Watchdog.Grace)*time.Second, safe)
+		go watchdog.Run(stopCh)
+	}
+	// Reconcile the Limits in Effect
+	var reconciler *kumonitor.Reconciler
+	if cfg.Reconcile.Enabled && !cfg.Monitor.MonitoringMode {
+		interval := time.Duration(cfg.Reconcile.Interval * float64(time.Second))
+		reconciler, err = kumonitor.NewReconciler(monitor, interval, cfg.Reconcile.Action, cfg.Reconcile.Tolerance, cfg.Reconcile.Breaches)
+		if err != nil {
+			klog.Error("Couldn't reconcile the limits : ", err)
+		}
+	}
+	// Connect to the Kubernetes API
+	var kube kubernetes.Interface
+	if cfg.PodInformer.Enabled || cfg.PodSync.Enabled {
+		if kube, err = kubeClient(cfg.Kubeconfig); err != nil {
+			klog.Error("Couldn't connect to the Kubernetes API : ", err)
+		}
+	}
+	// Watch the Pods of the Node in Kubernetes
+	var podInformer *kuinformer.Informer
+	if cfg.PodInformer.Enabled && kube != nil {
+		podInformer = newPodInformer(kube, cfg, monitor)
+		go podInformer.Run(stopCh)
+	}
+	// Sync the Limits into the Pods of Kubernetes
+	var podSyncer *kusync.Syncer
+	if cfg.PodSync.Enabled && !cfg.Monitor.MonitoringMode && kube != nil {
+		podSyncer, err = newPodSyncer(kube, cfg, monitor)
+		if err != nil {
+			klog.Error("Couldn't sync the limits into the pods : ", err)
+		} else {
+			monitor.AddObserver(podSyncer)
+			go podSyncer.Run(stopCh)
+		}
+	}
 	go monitor.Run(stopCh, ebpfCh, newPodCh)
 
+	// Checks of /healthz and /readyz. With the watchdog, a stalled loop is left
+	// to it, as a restart would race its fail-safe limits and never recover them.
+	kuhealth.AddCheck("monitor-tick", watchdog == nil, monitor.CheckTick)
+	kuhealth.AddCheck("docker", false, monitor.CheckDocker)
+	kuhealth.AddCheck("gpu-module", false, gpuLifecycle.Check)
+	kuhealth.AddCheck("device-plugin", false, kuhealth.Register("tokenmanager").Check)
+	if watchdog != nil {
+		kuhealth.AddCheck("watchdog", false, kuhealth.Register("watchdog").Check)
+	}
+	if podInformer != nil {
+		kuhealth.AddCheck("pod-informer", false, podInformer.Check)
+	}
+	if cfg.BPFWatcher {
+		kuhealth.AddCheck("bpfwatcher", false, kuhealth.Register("bpfwatcher").Check)
+	}
+
 	// Run Promethuse Exporter
-	if exporterMode {
-		go kuexporter.ExporterRun(monitor, nodeName, stopCh)
+	if cfg.Exporter.Enabled {
+		node := kuexporter.Node{
+			Name:       cfg.NodeName,
+			Tokens:     cfg.Token.Size,
+			Capacity:   capacity,
+			GPU:        kugpu.New(hostFS, cfg.Roots.GPU),
+			Watchdog:   watchdog,
+			Reconciler: reconciler,
+			PodSync:    podSyncer,
+		}
+		go kuexporter.ExporterRun(monitor, node, stopCh, exporterHandler)
 	}
 
 	// Run KU Device Plugin
 	tokenManager := kutokenmanager.NewKuTokenManager(
-		"kuscale.com/token", 6000,
-		pluginapi.DevicePluginPath+"dorry-token.sock")
+		cfg.Token.ResourceName, cfg.Token.Size,
+		pluginapi.DevicePluginPath+cfg.Token.Socket, hostFS, cfg.Roots)
+	tokenManager.Gemini = kutokenmanager.GeminiPaths(cfg.Gemini)
 	go tokenManager.Run(stopCh, newPodCh)
 
 	klog.V(4).Info("Started Kuscale")
 	<-stopCh
 	klog.V(4).Info("Shutting All Down")
 	// monitor.WaitAllContainers()
+	shutdown(cfg, monitor, tokenManager)
 	kuprofiler.Summary()
-	time.Sleep(time.Second * 2)
+	if cfg.GPUModule.Unload {
+		if err := gpuLifecycle.Unload(); err != nil {
+			klog.Error("Couldn't unload KU GPU Layer Module : ", err)
+		}
+	}
 	klog.V(4).Info("Shutted All Down")
 }
@@ -0,0 +1,81 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"syscall"
+
+	"k8s.io/klog"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kuapi"
+	"github.com/sslab-konkuk/KuScale/pkg/kuconfig"
+	"github.com/sslab-konkuk/KuScale/pkg/kumonitor"
+	kuwatcher "github.com/sslab-konkuk/KuScale/pkg/kuwatcher"
+)
+
+/*
+Func Name : runOperations()
+Objective : 1) Reload the config on SIGHUP and when the config file changes
+			2) Dump the state on SIGUSR1, toggle the decision tracing on SIGUSR2
+			3) Do one operation at a time, each taking the monitor lock like the monitor loop
+*/
+func runOperations(configFile string, running *kuconfig.Config, monitor *kumonitor.Monitor, stopCh chan string) {
+	sigCh := kuwatcher.OpSignalWatcher(stopCh)
+
+	var changeCh chan struct{}
+	if configFile != "" {
+		var err error
+		if changeCh, err = kuwatcher.FileWatcher(configFile, stopCh); err != nil {
+			klog.Error("Couldn't watch the config file, reload it with SIGHUP : ", err)
+		}
+	}
+
+	reload := func() {
+		if configFile == "" {
+			klog.Warning("Nothing to reload without -config")
+			return
+		}
+		reloadConfig(configFile, running, monitor)
+	}
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case _, ok := <-changeCh:
+			if !ok {
+				changeCh = nil
+				continue
+			}
+			reload()
+		case sig := <-sigCh:
+			klog.V(4).Info("Got ", sig)
+			switch sig {
+			case syscall.SIGHUP:
+				reload()
+			case syscall.SIGUSR1:
+				if file, err := kuapi.WriteDump(monitor, running.DumpDir); err != nil {
+					klog.Error("Couldn't dump the state : ", err)
+				} else {
+					klog.Info("Dumped the state to ", file)
+				}
+			case syscall.SIGUSR2:
+				var on bool
+				monitor.Do(func() { on = !monitor.DecisionTracing() })
+				monitor.SetDecisionTracing(on)
+			}
+		}
+	}
+}
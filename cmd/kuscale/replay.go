@@ -0,0 +1,80 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+
+	"k8s.io/klog"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kumonitor"
+	kuprofiler "github.com/sslab-konkuk/KuScale/pkg/kuprofiler"
+	kutrace "github.com/sslab-konkuk/KuScale/pkg/kutrace"
+)
+
+// replay feeds a recorded trace through a policy.
+// Usage : kuscale replay -trace <file|dir> [-policy static] [-out dir]
+func replay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	klog.InitFlags(fs)
+
+	var tracePath, policyName, outDir string
+	var staticV float64
+	fs.StringVar(&tracePath, "trace", "", "Trace file or directory to replay")
+	fs.StringVar(&policyName, "policy", "", "Policy to replay with, the recorded policy if empty")
+	fs.Float64Var(&staticV, "staticV", 10, "Static V Weight of the kuscale policy")
+	fs.StringVar(&outDir, "out", "", "Directory to record the trace of the replay")
+	fs.Parse(args)
+
+	kuprofiler.NewLatencyInfo(false)
+
+	records, err := kutrace.ReadTrace(tracePath)
+	if err != nil {
+		klog.Error("Couldn't read trace : ", err)
+		os.Exit(1)
+	}
+
+	var policy kumonitor.Policy
+	if policyName != "" {
+		policy, err = kumonitor.NewPolicy(policyName, map[string]float64{"staticV": staticV})
+		if err != nil {
+			klog.Error(err)
+			os.Exit(1)
+		}
+	}
+
+	replayer, err := kutrace.NewReplayer(records, policy)
+	if err != nil {
+		klog.Error("Couldn't replay trace : ", err)
+		os.Exit(1)
+	}
+	if outDir != "" {
+		recorder, err := kutrace.NewRecorder(replayer.Monitor(), outDir, 0, 0)
+		if err != nil {
+			klog.Error("Couldn't record trace : ", err)
+			os.Exit(1)
+		}
+		defer recorder.Close()
+		replayer.Monitor().AddObserver(recorder)
+	}
+
+	result := replayer.Run()
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(result)
+	klog.Flush()
+}
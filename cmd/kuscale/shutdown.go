@@ -0,0 +1,63 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"k8s.io/klog"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kuapi"
+	"github.com/sslab-konkuk/KuScale/pkg/kuconfig"
+	"github.com/sslab-konkuk/KuScale/pkg/kumonitor"
+	kutokenmanager "github.com/sslab-konkuk/KuScale/pkg/kutokenmanager"
+)
+
+/*
+Func Name : shutdown()
+Objective : 1) Wait for the tick in progress, then restore the limits of the running pods
+			2) Wait for the device plugin to deregister, and write the final checkpoint
+			3) Give up on what is left when the shutdown timeout passes
+*/
+func shutdown(c *kuconfig.Config, monitor *kumonitor.Monitor, tokenManager *kutokenmanager.KuTokenManager) {
+	timeout := time.Duration(c.Shutdown.Timeout) * time.Second
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		<-monitor.Done()
+		if c.Shutdown.RestoreLimits {
+			safe := map[kumonitor.ResourceName]float64{"CPU": c.Shutdown.SafeLimits.CPU, "GPU": c.Shutdown.SafeLimits.GPU}
+			restored, err := monitor.RestoreLimits(safe)
+			if err != nil {
+				klog.Error("Couldn't restore every limit : ", err)
+			}
+			klog.V(4).Info("Restored ", restored, " limits")
+		}
+
+		<-tokenManager.Done()
+		if file, err := kuapi.WriteCheckpoint(monitor, c.DumpDir); err != nil {
+			klog.Error("Couldn't write the checkpoint : ", err)
+		} else {
+			klog.V(4).Info("Wrote the checkpoint to ", file)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		klog.Error("Shutdown didn't finish in ", timeout, ", exiting anyway")
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"os"
+
+	"k8s.io/klog"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kumonitor"
+	kuprofiler "github.com/sslab-konkuk/KuScale/pkg/kuprofiler"
+	kusimulator "github.com/sslab-konkuk/KuScale/pkg/kusimulator"
+	kutrace "github.com/sslab-konkuk/KuScale/pkg/kutrace"
+)
+
+// simulate runs the monitor control loop against simulated workloads.
+// Usage : kuscale simulate [-scenario file.json] [-speedup 0] [-json]
+func simulate(args []string) {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	klog.InitFlags(fs)
+
+	var config kusimulator.Config
+	var scenarioFile string
+	var duration float64
+	var jsonOutput bool
+	var policyName, traceDir string
+
+	fs.StringVar(&scenarioFile, "scenario", "", "Scenario file in JSON, the default scenario is used if empty")
+	fs.Float64Var(&duration, "duration", 0, "Overrides the duration of the scenario in seconds")
+	fs.Float64Var(&config.Speedup, "speedup", 0, "Times faster than real time, 0 runs as fast as possible")
+	fs.IntVar(&config.Substeps, "substeps", 10, "Integration steps per MonitoringPeriod")
+	fs.BoolVar(&jsonOutput, "json", false, "Print the report in JSON")
+
+	fs.Float64Var(&config.MonitoringPeriod, "MonitoringPeriod", 2, "MonitoringPeriod in seconds")
+	fs.Int64Var(&config.WindowSize, "WindowSize", 15, "WindowSize")
+	fs.BoolVar(&config.MonitoringMode, "MonitoringMode", false, "MonitoringMode")
+	fs.Float64Var(&config.StaticV, "staticV", 10, "Static V Weight")
+	fs.StringVar(&policyName, "policy", "kuscale", "Scaling policy : kuscale or static")
+	fs.StringVar(&traceDir, "traceDir", "", "Directory to record the trace of the simulation")
+	fs.Parse(args)
+
+	kuprofiler.NewLatencyInfo(false)
+
+	scenario := kusimulator.DefaultScenario()
+	if scenarioFile != "" {
+		var err error
+		scenario, err = kusimulator.LoadScenario(scenarioFile)
+		if err != nil {
+			klog.Error("Couldn't load scenario : ", err)
+			os.Exit(1)
+		}
+	}
+	if duration > 0 {
+		scenario.Duration = duration
+	}
+
+	policy, err := kumonitor.NewPolicy(policyName, map[string]float64{"staticV": config.StaticV})
+	if err != nil {
+		klog.Error(err)
+		os.Exit(1)
+	}
+
+	simulator, err := kusimulator.NewSimulator(scenario, config)
+	if err != nil {
+		klog.Error("Couldn't simulate : ", err)
+		os.Exit(1)
+	}
+	simulator.Monitor().SetPolicy(policy)
+	if traceDir != "" {
+		recorder, err := kutrace.NewRecorder(simulator.Monitor(), traceDir, 0, 0)
+		if err != nil {
+			klog.Error("Couldn't record trace : ", err)
+			os.Exit(1)
+		}
+		defer recorder.Close()
+		simulator.Monitor().AddObserver(recorder)
+	}
+
+	report := simulator.Run()
+	if jsonOutput {
+		report.WriteJSON(os.Stdout)
+	} else {
+		report.WriteTable(os.Stdout)
+	}
+	klog.Flush()
+}
@@ -0,0 +1,144 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+)
+
+// kubectlTransport reaches the daemon of a node through the API server,
+// so it needs nothing but kubectl and the permissions of its kubeconfig.
+// The pod on the node is found in the endpoints of the kuscale service,
+// and the requests go through the pod proxy to its exporter port.
+type kubectlTransport struct {
+	kubectl string
+	prefix  string // /api/v1/namespaces/<ns>/pods/<pod>:<port>/proxy
+}
+
+type endpoints struct {
+	Subsets []struct {
+		Addresses []struct {
+			NodeName  string `json:"nodeName"`
+			TargetRef struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"targetRef"`
+		} `json:"addresses"`
+		NotReadyAddresses []struct {
+			NodeName string `json:"nodeName"`
+		} `json:"notReadyAddresses"`
+		Ports []struct {
+			Port int `json:"port"`
+		} `json:"ports"`
+	} `json:"subsets"`
+}
+
+func newKubectlTransport(kubectl, namespace, service, node string) (*kubectlTransport, error) {
+	out, err := exec.Command(kubectl, "get", "endpoints", service, "-n", namespace, "-o", "json").Output()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get the endpoints of %s/%s : %w", namespace, service, kubectlError(err))
+	}
+	var eps endpoints
+	if err := json.Unmarshal(out, &eps); err != nil {
+		return nil, err
+	}
+
+	for _, subset := range eps.Subsets {
+		if len(subset.Ports) == 0 {
+			continue
+		}
+		for _, address := range subset.Addresses {
+			if address.NodeName != node || address.TargetRef.Name == "" {
+				continue
+			}
+			return &kubectlTransport{
+				kubectl: kubectl,
+				prefix: fmt.Sprintf("/api/v1/namespaces/%s/pods/%s:%d/proxy",
+					address.TargetRef.Namespace, address.TargetRef.Name, subset.Ports[0].Port),
+			}, nil
+		}
+		for _, address := range subset.NotReadyAddresses {
+			if address.NodeName == node {
+				return nil, fmt.Errorf("kuscale on %s is not ready, see its /readyz", node)
+			}
+		}
+	}
+	return nil, fmt.Errorf("%s/%s has no endpoint on node %s", namespace, service, node)
+}
+
+func kubectlError(err error) error {
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("%s", strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return err
+}
+
+type cmdReadCloser struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+}
+
+func (c *cmdReadCloser) Close() error {
+	c.ReadCloser.Close()
+	if err := c.cmd.Wait(); err != nil {
+		return fmt.Errorf("%s", strings.TrimSpace(c.stderr.String()))
+	}
+	return nil
+}
+
+func (t *kubectlTransport) Do(method, path string, body []byte) (io.ReadCloser, error) {
+	var args []string
+	switch method {
+	case http.MethodGet:
+		args = []string{"get", "--raw", t.prefix + path}
+	case http.MethodPost:
+		args = []string{"create", "--raw", t.prefix + path, "-f", "-"}
+	case http.MethodPut:
+		args = []string{"replace", "--raw", t.prefix + path, "-f", "-"}
+	case http.MethodDelete:
+		args = []string{"delete", "--raw", t.prefix + path}
+	default:
+		return nil, fmt.Errorf("%s is not supported through kubectl", method)
+	}
+
+	cmd := exec.Command(t.kubectl, args...)
+	cmd.Stdin = bytes.NewReader(body)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+
+	if method != http.MethodGet {
+		// Small responses, so errors are reported before anything is printed
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("%s", strings.TrimSpace(stderr.String()))
+		}
+		return io.NopCloser(bytes.NewReader(out)), nil
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &cmdReadCloser{ReadCloser: stdout, cmd: cmd, stderr: stderr}, nil
+}
@@ -0,0 +1,392 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// kuscalectl talks to the control API of the KuScale daemon, on the node
+// through its unix socket, or from anywhere as the kubectl plugin
+// kubectl-kuscale through the kuscale service.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kuapi"
+	"github.com/sslab-konkuk/KuScale/pkg/kuledger"
+)
+
+const usage = `Usage : kuscalectl [flags] <command>
+
+Commands :
+  status                                   node-wide state
+  pods                                     running pods
+  describe <ns>/<pod>                      a pod and its recent ticks
+  pin <ns>/<pod> <cpu|gpu>=<limit>... -for 10m
+                                           pin limits for a while
+  unpin <ns>/<pod> <cpu|gpu>               give a limit back to the policy
+  reservation <ns>/<pod> <tokens>          change the TokenReservation
+  pause [ns/pod], resume [ns/pod]          autoscaling of a pod or of every pod
+  policy get
+  policy set <name> [<param>=<value>]...
+  trace dump [-out file]                   the recorded trace, as JSON lines
+  ledger [-from t] [-to t] [-ns ns] [-pod pod] [-format json|csv] [-out file]
+                                           token ledger entries, t is RFC3339 or 2006-01-02
+  ledger summary [-by pod|namespace] [-from t] [-to t] [-ns ns] [-pod pod]
+                                           tokens reserved, consumed and unused
+  explain <namespace>/<pod> [-n 5]         why the limits of a pod are what they are
+
+Flags :
+`
+
+var (
+	socketFile string
+	output     string
+
+	node      string
+	namespace string
+	service   string
+	kubectl   string
+)
+
+func init() {
+	flag.StringVar(&socketFile, "socket", "/var/run/kuscale/kuscale.sock", "Control socket of the daemon")
+	flag.StringVar(&output, "o", "table", "Output format : table or json")
+	flag.StringVar(&node, "node", "", "Reach the daemon of this node through kubectl instead of the socket")
+	flag.StringVar(&namespace, "namespace", "default", "Namespace of the kuscale service")
+	flag.StringVar(&service, "service", "kuscale-svc", "Service in front of the daemons")
+	flag.StringVar(&kubectl, "kubectl", "kubectl", "kubectl binary")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "error:", err)
+	os.Exit(1)
+}
+
+// parseInterleaved parses the flags of fs wherever they are among the
+// positional arguments, like "pin pod cpu=200 -for 10m".
+func parseInterleaved(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func main() {
+	plugin := strings.HasPrefix(filepath.Base(os.Args[0]), "kubectl-")
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if output != "table" && output != "json" {
+		fail(fmt.Errorf("unknown output format %q", output))
+	}
+
+	var transport kuapi.Transport
+	if node != "" {
+		t, err := newKubectlTransport(kubectl, namespace, service, node)
+		if err != nil {
+			fail(err)
+		}
+		transport = t
+	} else if plugin {
+		fail(fmt.Errorf("-node is needed as a kubectl plugin"))
+	} else {
+		transport = kuapi.NewUnixTransport(socketFile)
+	}
+	client := kuapi.NewClient(transport)
+
+	if err := run(client, args[0], args[1:]); err != nil {
+		fail(err)
+	}
+}
+
+// splitPod splits <namespace>/<pod>.
+func splitPod(arg string) (namespace, podName string, err error) {
+	parts := strings.SplitN(arg, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("%q is not <namespace>/<pod>", arg)
+	}
+	return parts[0], parts[1], nil
+}
+
+// parseTime parses RFC3339 or a UTC day, empty being no bound.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// writeOut calls write with outFile, or stdout if empty.
+func writeOut(outFile string, write func(w io.Writer) error) error {
+	if outFile == "" {
+		return write(os.Stdout)
+	}
+	f, err := os.Create(outFile)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func run(client *kuapi.Client, command string, args []string) error {
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	var pinFor time.Duration
+	var outFile string
+	var from, to, format, by string
+	var q kuledger.Query
+	var n int
+	switch command {
+	case "pin":
+		fs.DurationVar(&pinFor, "for", 10*time.Minute, "How long the limits stay pinned")
+	case "trace":
+		fs.StringVar(&outFile, "out", "", "File to write the trace into, stdout if empty")
+	case "ledger":
+		fs.StringVar(&from, "from", "", "Start of the range, RFC3339 or 2006-01-02")
+		fs.StringVar(&to, "to", "", "End of the range, excluded")
+		fs.StringVar(&q.Namespace, "ns", "", "Only the pods of this namespace")
+		fs.StringVar(&q.Pod, "pod", "", "Only this pod")
+		fs.StringVar(&format, "format", "json", "Format of the entries : json or csv")
+		fs.StringVar(&outFile, "out", "", "File to write the entries into, stdout if empty")
+		fs.StringVar(&by, "by", "pod", "Sum the summary by pod or namespace")
+	case "explain":
+		fs.IntVar(&n, "n", 5, "Number of decisions to explain")
+	}
+	args = parseInterleaved(fs, args)
+
+	switch command {
+	case "status":
+		status, err := client.Status()
+		if err != nil {
+			return err
+		}
+		return show(status, func(w io.Writer) { printStatus(w, status) })
+
+	case "pods":
+		pods, err := client.Pods()
+		if err != nil {
+			return err
+		}
+		return show(pods, func(w io.Writer) { printPods(w, pods) })
+
+	case "describe":
+		if len(args) != 1 {
+			return fmt.Errorf("usage : describe <namespace>/<pod>")
+		}
+		ns, podName, err := splitPod(args[0])
+		if err != nil {
+			return err
+		}
+		pod, err := client.Pod(ns, podName)
+		if err != nil {
+			return err
+		}
+		history, err := client.History(ns, podName)
+		if err != nil {
+			return err
+		}
+		return show(struct {
+			*kuapi.Pod
+			History []kuapi.Sample `json:"history"`
+		}{pod, history.Samples}, func(w io.Writer) { printDescribe(w, pod, history) })
+
+	case "pin":
+		if len(args) < 2 {
+			return fmt.Errorf("usage : pin <namespace>/<pod> <cpu|gpu>=<limit>... -for 10m")
+		}
+		ns, podName, err := splitPod(args[0])
+		if err != nil {
+			return err
+		}
+		var pod *kuapi.Pod
+		for _, arg := range args[1:] {
+			kv := strings.SplitN(arg, "=", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("%q is not <resource>=<limit>", arg)
+			}
+			limit, err := strconv.ParseFloat(kv[1], 64)
+			if err != nil {
+				return fmt.Errorf("invalid limit %q", kv[1])
+			}
+			if pod, err = client.Pin(ns, podName, kv[0], limit, pinFor); err != nil {
+				return err
+			}
+		}
+		return show(pod, func(w io.Writer) { printPods(w, []kuapi.Pod{*pod}) })
+
+	case "unpin":
+		if len(args) != 2 {
+			return fmt.Errorf("usage : unpin <namespace>/<pod> <cpu|gpu>")
+		}
+		ns, podName, err := splitPod(args[0])
+		if err != nil {
+			return err
+		}
+		pod, err := client.Unpin(ns, podName, args[1])
+		if err != nil {
+			return err
+		}
+		return show(pod, func(w io.Writer) { printPods(w, []kuapi.Pod{*pod}) })
+
+	case "reservation":
+		if len(args) != 2 {
+			return fmt.Errorf("usage : reservation <namespace>/<pod> <tokens>")
+		}
+		ns, podName, err := splitPod(args[0])
+		if err != nil {
+			return err
+		}
+		tokens, err := strconv.ParseFloat(args[1], 64)
+		if err != nil {
+			return fmt.Errorf("invalid tokens %q", args[1])
+		}
+		pod, err := client.SetTokenReservation(ns, podName, tokens)
+		if err != nil {
+			return err
+		}
+		return show(pod, func(w io.Writer) { printPods(w, []kuapi.Pod{*pod}) })
+
+	case "pause", "resume":
+		if len(args) > 1 {
+			return fmt.Errorf("usage : %s [<namespace>/<pod>]", command)
+		}
+		var ns, podName string
+		if len(args) == 1 {
+			var err error
+			if ns, podName, err = splitPod(args[0]); err != nil {
+				return err
+			}
+		}
+		result, err := client.SetPaused(ns, podName, command == "pause")
+		if err != nil {
+			return err
+		}
+		return show(result, func(w io.Writer) {
+			switch v := result.(type) {
+			case *kuapi.Pod:
+				printPods(w, []kuapi.Pod{*v})
+			case *kuapi.Status:
+				printStatus(w, v)
+			}
+		})
+
+	case "policy":
+		if len(args) == 0 {
+			return fmt.Errorf("usage : policy get | policy set <name> [<param>=<value>]...")
+		}
+		var spec *kuapi.PolicySpec
+		var err error
+		switch args[0] {
+		case "get":
+			spec, err = client.Policy()
+		case "set":
+			if len(args) < 2 {
+				return fmt.Errorf("usage : policy set <name> [<param>=<value>]...")
+			}
+			req := kuapi.PolicySpec{Name: args[1], Params: make(map[string]float64)}
+			for _, arg := range args[2:] {
+				kv := strings.SplitN(arg, "=", 2)
+				if len(kv) != 2 {
+					return fmt.Errorf("%q is not <param>=<value>", arg)
+				}
+				if req.Params[kv[0]], err = strconv.ParseFloat(kv[1], 64); err != nil {
+					return fmt.Errorf("invalid value %q", kv[1])
+				}
+			}
+			spec, err = client.SetPolicy(req)
+		default:
+			return fmt.Errorf("unknown policy command %q", args[0])
+		}
+		if err != nil {
+			return err
+		}
+		return show(spec, func(w io.Writer) { printPolicy(w, spec) })
+
+	case "trace":
+		if len(args) != 1 || args[0] != "dump" {
+			return fmt.Errorf("usage : trace dump [-out file]")
+		}
+		return writeOut(outFile, client.Trace)
+
+	case "ledger":
+		var err error
+		if q.From, err = parseTime(from); err != nil {
+			return fmt.Errorf("invalid -from : %w", err)
+		}
+		if q.To, err = parseTime(to); err != nil {
+			return fmt.Errorf("invalid -to : %w", err)
+		}
+		if len(args) == 0 {
+			return writeOut(outFile, func(w io.Writer) error { return client.Ledger(q, format, w) })
+		}
+		if len(args) != 1 || args[0] != "summary" {
+			return fmt.Errorf("usage : ledger [summary] [-from t] [-to t] ...")
+		}
+		usages, err := client.LedgerSummary(q, by == "namespace")
+		if err != nil {
+			return err
+		}
+		return show(usages, func(w io.Writer) { printUsages(w, usages) })
+
+	case "explain":
+		if len(args) != 1 {
+			return fmt.Errorf("usage : explain <namespace>/<pod> [-n 5]")
+		}
+		ns, podName, err := splitPod(args[0])
+		if err != nil {
+			return err
+		}
+		explanation, err := client.Explain(ns, podName, n)
+		if err != nil {
+			return err
+		}
+		return show(explanation, func(w io.Writer) { explanation.WriteText(w) })
+	}
+	return fmt.Errorf("unknown command %q, see -h", command)
+}
+
+// show prints v in JSON, or calls table.
+func show(v interface{}, table func(w io.Writer)) error {
+	if output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	table(os.Stdout)
+	return nil
+}
@@ -0,0 +1,169 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kuapi"
+	"github.com/sslab-konkuk/KuScale/pkg/kuledger"
+)
+
+func resourceNames(resources map[string]kuapi.Resource) []string {
+	names := make([]string, 0, len(resources))
+	for name := range resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// usage/limit, with a * for a pinned limit
+func formatResource(r kuapi.Resource) string {
+	pin := ""
+	if r.Pin != nil {
+		pin = "*"
+	}
+	return fmt.Sprintf("%.1f/%.1f%s", r.Usage, r.Limit, pin)
+}
+
+// formatEnforced tells how the limit in effect compares with the limit.
+func formatEnforced(r kuapi.Resource) string {
+	switch {
+	case r.EnforcedLimit == 0:
+		return "-"
+	case r.Drifted:
+		return fmt.Sprintf("%.1f, drifted", r.EnforcedLimit)
+	case r.EnforcementBroken:
+		return fmt.Sprintf("%.1f, usage over it", r.EnforcedLimit)
+	}
+	return fmt.Sprintf("%.1f", r.EnforcedLimit)
+}
+
+// orNone is s, or <none> like kubectl when it is empty.
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
+
+func printStatus(w io.Writer, status *kuapi.Status) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Node:\t%s\n", status.Node)
+	fmt.Fprintf(tw, "API Version:\t%s\n", status.APIVersion)
+	fmt.Fprintf(tw, "Policy:\t%s %v\n", status.Policy, status.PolicyParams)
+	fmt.Fprintf(tw, "Period:\t%gs\n", status.Period)
+	fmt.Fprintf(tw, "Monitoring Mode:\t%v\n", status.MonitoringMode)
+	fmt.Fprintf(tw, "Paused:\t%v\n", status.Paused)
+	fmt.Fprintf(tw, "Pods:\t%d running, %d completed\n", status.RunningPods, status.CompletedPods)
+	tw.Flush()
+}
+
+func printPods(w io.Writer, pods []kuapi.Pod) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSTATUS\tPAUSED\tCPU\tGPU\tRESERVATION\tQUEUE\tUPDATED")
+	for _, pod := range pods {
+		fmt.Fprintf(tw, "%s\t%s\t%v\t%s\t%s\t%.1f\t%.1f\t%d\n", pod.Name, pod.Status, pod.Paused,
+			formatResource(pod.Resources["CPU"]), formatResource(pod.Resources["GPU"]),
+			pod.TokenReservation, pod.TokenQueue, pod.UpdatedCount)
+	}
+	tw.Flush()
+}
+
+func printDescribe(w io.Writer, pod *kuapi.Pod, history *kuapi.History) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Name:\t%s\n", pod.Name)
+	if pod.Namespace != "" {
+		fmt.Fprintf(tw, "Namespace:\t%s\n", pod.Namespace)
+	}
+	if pod.UID != "" {
+		fmt.Fprintf(tw, "UID:\t%s\n", pod.UID)
+		fmt.Fprintf(tw, "Owner:\t%s\n", orNone(pod.Owner))
+		fmt.Fprintf(tw, "QoS Class:\t%s\n", orNone(pod.QOSClass))
+	}
+	fmt.Fprintf(tw, "Status:\t%s\n", pod.Status)
+	fmt.Fprintf(tw, "Paused:\t%v\n", pod.Paused)
+	fmt.Fprintf(tw, "Fail-Safe:\t%v\n", pod.FailSafe)
+	fmt.Fprintf(tw, "Token Reservation:\t%.1f\n", pod.TokenReservation)
+	fmt.Fprintf(tw, "Token Queue:\t%.1f\n", pod.TokenQueue)
+	fmt.Fprintf(tw, "Available Token:\t%.1f\n", pod.AvailableToken)
+	fmt.Fprintf(tw, "Updated:\t%d times\n", pod.UpdatedCount)
+	tw.Flush()
+
+	fmt.Fprintln(w, "\nResources:")
+	tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "  NAME\tUSAGE\tAVG USAGE\tLIMIT\tDYNAMIC WEIGHT\tPINNED\tENFORCED")
+	for _, name := range resourceNames(pod.Resources) {
+		r := pod.Resources[name]
+		pinned := "-"
+		if r.Pin != nil && r.Pin.Annotation {
+			pinned = fmt.Sprintf("%.1f by annotation", r.Pin.Limit)
+		} else if r.Pin != nil {
+			pinned = fmt.Sprintf("%.1f until %s", r.Pin.Limit, r.Pin.Until.Format(time.RFC3339))
+		}
+		fmt.Fprintf(tw, "  %s\t%.1f\t%.1f\t%.1f\t%.1f\t%s\t%s\n", name, r.Usage, r.AvgUsage, r.Limit, r.DynamicWeight, pinned,
+			formatEnforced(r))
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w, "\nHistory:")
+	tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	names := resourceNames(pod.Resources)
+	header := []string{"  TIME"}
+	for _, name := range names {
+		header = append(header, name)
+	}
+	header = append(header, "QUEUE", "AVAILABLE")
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, sample := range history.Samples {
+		row := []string{"  " + sample.Time.Format("15:04:05")}
+		for _, name := range names {
+			r := sample.Resources[name]
+			row = append(row, fmt.Sprintf("%.1f/%.1f", r.Usage, r.Limit))
+		}
+		row = append(row, fmt.Sprintf("%.1f", sample.TokenQueue), fmt.Sprintf("%.1f", sample.AvailableToken))
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	tw.Flush()
+}
+
+func printPolicy(w io.Writer, spec *kuapi.PolicySpec) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Policy:\t%s\n", spec.Name)
+	params := make([]string, 0, len(spec.Params))
+	for name := range spec.Params {
+		params = append(params, name)
+	}
+	sort.Strings(params)
+	for _, name := range params {
+		fmt.Fprintf(tw, "  %s:\t%v\n", name, spec.Params[name])
+	}
+	tw.Flush()
+}
+
+func printUsages(w io.Writer, usages []kuledger.Usage) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tPOD\tPOD HOURS\tRESERVED\tCONSUMED CPU\tCONSUMED GPU\tUNUSED")
+	for _, u := range usages {
+		fmt.Fprintf(tw, "%s\t%s\t%.2f\t%.1f\t%.1f\t%.1f\t%.1f\n", u.Namespace, u.Pod, u.Seconds/3600,
+			u.Reserved, u.Consumed["CPU"], u.Consumed["GPU"], u.Unused)
+	}
+	tw.Flush()
+}
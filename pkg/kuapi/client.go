@@ -0,0 +1,184 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package kuapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Transport sends a request to the API and returns the body of a successful
+// response. path starts with the API version, like /v1/pods.
+type Transport interface {
+	Do(method, path string, body []byte) (io.ReadCloser, error)
+}
+
+type unixTransport struct {
+	client *http.Client
+}
+
+// NewUnixTransport talks to the control socket of the daemon.
+func NewUnixTransport(socketFile string) Transport {
+	return &unixTransport{client: &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socketFile)
+			},
+		},
+	}}
+}
+
+func (t *unixTransport) Do(method, path string, body []byte) (io.ReadCloser, error) {
+	req, err := http.NewRequest(method, "http://kuscale"+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		var apiErr Error
+		if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil || apiErr.Error == "" {
+			return nil, fmt.Errorf("%s %s : %s", method, path, resp.Status)
+		}
+		return nil, errors.New(apiErr.Error)
+	}
+	return resp.Body, nil
+}
+
+type Client struct {
+	t Transport
+}
+
+func NewClient(t Transport) *Client { return &Client{t: t} }
+
+func (c *Client) call(method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	resp, err := c.t.Do(method, "/"+APIVersion+path, body)
+	if err != nil {
+		return err
+	}
+	if out != nil {
+		err = json.NewDecoder(resp).Decode(out)
+	}
+	// A transport may only know that the request failed once it is closed
+	if cerr := resp.Close(); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+func podPath(namespace, podName, action string) string {
+	path := "/pods/" + url.PathEscape(namespace) + "/" + url.PathEscape(podName)
+	if action != "" {
+		path += "/" + action
+	}
+	return path
+}
+
+func (c *Client) Status() (*Status, error) {
+	var status Status
+	return &status, c.call(http.MethodGet, "/status", nil, &status)
+}
+
+func (c *Client) Pods() ([]Pod, error) {
+	var pods []Pod
+	return pods, c.call(http.MethodGet, "/pods", nil, &pods)
+}
+
+func (c *Client) Pod(namespace, podName string) (*Pod, error) {
+	var pod Pod
+	return &pod, c.call(http.MethodGet, podPath(namespace, podName, ""), nil, &pod)
+}
+
+func (c *Client) History(namespace, podName string) (*History, error) {
+	var history History
+	return &history, c.call(http.MethodGet, podPath(namespace, podName, "history"), nil, &history)
+}
+
+func (c *Client) Pin(namespace, podName, resource string, limit float64, duration time.Duration) (*Pod, error) {
+	var pod Pod
+	req := PinRequest{Resource: resource, Limit: limit, Duration: duration.String()}
+	return &pod, c.call(http.MethodPost, podPath(namespace, podName, "pin"), req, &pod)
+}
+
+func (c *Client) Unpin(namespace, podName, resource string) (*Pod, error) {
+	var pod Pod
+	path := podPath(namespace, podName, "pin") + "?resource=" + url.QueryEscape(resource)
+	return &pod, c.call(http.MethodDelete, path, nil, &pod)
+}
+
+func (c *Client) SetTokenReservation(namespace, podName string, tokenReservation float64) (*Pod, error) {
+	var pod Pod
+	req := ReservationRequest{TokenReservation: tokenReservation}
+	return &pod, c.call(http.MethodPut, podPath(namespace, podName, "reservation"), req, &pod)
+}
+
+// SetPaused pauses or resumes a pod, or the node when podName is empty.
+// It returns the pod or the node status.
+func (c *Client) SetPaused(namespace, podName string, paused bool) (interface{}, error) {
+	action := "resume"
+	if paused {
+		action = "pause"
+	}
+	if podName == "" {
+		var status Status
+		return &status, c.call(http.MethodPost, "/"+action, struct{}{}, &status)
+	}
+	var pod Pod
+	return &pod, c.call(http.MethodPost, podPath(namespace, podName, action), struct{}{}, &pod)
+}
+
+func (c *Client) Policy() (*PolicySpec, error) {
+	var spec PolicySpec
+	return &spec, c.call(http.MethodGet, "/policy", nil, &spec)
+}
+
+func (c *Client) SetPolicy(spec PolicySpec) (*PolicySpec, error) {
+	var updated PolicySpec
+	return &updated, c.call(http.MethodPut, "/policy", spec, &updated)
+}
+
+// Trace copies the recorded trace into w.
+func (c *Client) Trace(w io.Writer) error {
+	resp, err := c.t.Do(http.MethodGet, "/"+APIVersion+"/trace", nil)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, resp)
+	if cerr := resp.Close(); cerr != nil {
+		return cerr
+	}
+	return err
+}
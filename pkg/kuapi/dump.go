@@ -0,0 +1,94 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package kuapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kumonitor"
+	"github.com/sslab-konkuk/KuScale/pkg/kuprofiler"
+)
+
+func sortedPods(podMap kumonitor.PodInfoMap) []Pod {
+	pods := []Pod{}
+	for _, pi := range podMap {
+		pods = append(pods, newPod(pi))
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
+	return pods
+}
+
+// NewDump takes the state of m in one go, so it is consistent with a tick.
+func NewDump(m *kumonitor.Monitor) Dump {
+	var dump Dump
+	m.Do(func() {
+		dump = Dump{
+			Status:          newStatus(m),
+			DecisionTracing: m.DecisionTracing(),
+			Pods:            sortedPods(m.RunningPodMap),
+			CompletedPods:   sortedPods(m.CompletedPodMap),
+		}
+	})
+	var profiler bytes.Buffer
+	kuprofiler.Fprint(&profiler)
+	dump.Profiler = profiler.String()
+	return dump
+}
+
+/*
+Func Name : WriteDump()
+Objective : 1) Write the state of m to kuscale-dump-<time>.json in dir
+			2) Write a temporary file first, so a reader never sees half a dump
+*/
+func WriteDump(m *kumonitor.Monitor, dir string) (string, error) {
+	dump := NewDump(m)
+	name := fmt.Sprintf("kuscale-dump-%s.json", dump.Status.Time.UTC().Format("20060102T150405.000"))
+	return writeDump(dump, dir, name)
+}
+
+// WriteCheckpoint writes the final state of m to kuscale-checkpoint.json in
+// dir on shutdown, replacing the one of the previous run.
+func WriteCheckpoint(m *kumonitor.Monitor, dir string) (string, error) {
+	return writeDump(NewDump(m), dir, "kuscale-checkpoint.json")
+}
+
+func writeDump(dump Dump, dir, name string) (string, error) {
+	data, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	name = filepath.Join(dir, name)
+	tmp, err := ioutil.TempFile(dir, ".kuscale-dump-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return name, os.Rename(tmp.Name(), name)
+}
@@ -0,0 +1,307 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kuapi
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kumonitor"
+	"k8s.io/klog"
+)
+
+// Bindings of a limit, what bounds it.
+const (
+	BindingTokens   = "tokens"   // the limits spend every available token
+	BindingFloor    = "floor"    // raised to the minimum limit
+	BindingCapacity = "capacity" // the policy wants more than the node has
+	BindingPin      = "pin"      // pinned from the control API
+	BindingStatic   = "static"   // even split of the static policy
+	BindingFailSafe = "failSafe" // fail-safe limit of the watchdog for stale usage readings
+	BindingNone     = "none"     // follows the usage with tokens to spare
+)
+
+const defaultExplained = 5
+
+// explainer keeps the decisions of the last ticks of every running pod. It is
+// a kumonitor.DecisionObserver.
+type explainer struct {
+	mu      sync.Mutex
+	size    int
+	pending map[string][]kumonitor.Decision   // of the current tick, by kumonitor.PodKey
+	ticks   map[string][][]kumonitor.Decision // oldest first, by kumonitor.PodKey
+}
+
+func newExplainer(size int) *explainer {
+	return &explainer{size: size,
+		pending: make(map[string][]kumonitor.Decision),
+		ticks:   make(map[string][][]kumonitor.Decision)}
+}
+
+func (e *explainer) Decided(d *kumonitor.Decision) {
+	// The pin itself is explained by the decisions of the next ticks, and a
+	// reapply writes the limit of the last tick again
+	if d.Branch == kumonitor.BranchManual || d.Branch == kumonitor.BranchReapply {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	key := kumonitor.PodKey(d.Namespace, d.Pod)
+	e.pending[key] = append(e.pending[key], *d)
+}
+
+func (e *explainer) PodAdded(now int64, pi *kumonitor.PodInfo) {}
+
+func (e *explainer) PodCompleted(now int64, pi *kumonitor.PodInfo) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.ticks, pi.Key())
+	delete(e.pending, pi.Key())
+}
+
+func (e *explainer) Ticked(now int64, m *kumonitor.Monitor) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for pod, ds := range e.pending {
+		ticks := append(e.ticks[pod], ds)
+		if len(ticks) > e.size {
+			ticks = ticks[len(ticks)-e.size:]
+		}
+		e.ticks[pod] = ticks
+	}
+	e.pending = make(map[string][]kumonitor.Decision)
+}
+
+func (e *explainer) last(key string, n int) [][]kumonitor.Decision {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ticks := e.ticks[key]
+	if len(ticks) > n {
+		ticks = ticks[len(ticks)-n:]
+	}
+	return append([][]kumonitor.Decision(nil), ticks...)
+}
+
+func binding(d *kumonitor.Decision, capacity float64) string {
+	switch {
+	case d.Outcome == kumonitor.OutcomePinned:
+		return BindingPin
+	case d.Outcome == kumonitor.OutcomeClamped:
+		return BindingFloor
+	case d.Branch == kumonitor.BranchFailSafe:
+		return BindingFailSafe
+	case capacity > 0 && d.ProposedLimit >= capacity:
+		return BindingCapacity
+	case d.Branch == kumonitor.BranchTokenLimited:
+		return BindingTokens
+	case d.Branch == kumonitor.BranchStatic:
+		return BindingStatic
+	}
+	return BindingNone
+}
+
+/*
+Func Name : explain()
+Objective : 1) Tell what bounds every limit decided at a tick, and the predicted token balance
+			2) Tell what would change the outcome
+*/
+func explain(ds []kumonitor.Decision, capacity map[kumonitor.ResourceName]float64) ExplainedDecision {
+	first := ds[0]
+	ed := ExplainedDecision{
+		Time:             time.Unix(0, first.Time),
+		Policy:           first.Policy,
+		Branch:           first.Branch,
+		TokenReservation: first.TokenReservation,
+		TokenQueue:       first.TokenQueue,
+		AvailableToken:   first.AvailableToken,
+		Resources:        make(map[string]ExplainedResource),
+		Hints:            []string{},
+	}
+
+	// Like UpdateTokenQueue at the next tick
+	ed.TokenBalance = first.AvailableToken
+	queue := first.TokenQueue + first.TokenReservation*first.Period
+	bound := make(map[string]bool)
+	for i := range ds {
+		d := &ds[i]
+		ed.TokenBalance -= d.Price * d.ProposedLimit * d.Period
+		queue -= d.Price * d.NewLimit * d.Period
+
+		er := ExplainedResource{
+			Usage:         d.Usage,
+			AvgUsage:      d.AvgUsage,
+			DynamicWeight: d.DynamicWeight,
+			Price:         d.Price,
+			OldLimit:      d.OldLimit,
+			ProposedLimit: d.ProposedLimit,
+			NewLimit:      d.NewLimit,
+			Outcome:       d.Outcome,
+			Binding:       binding(d, capacity[d.Resource]),
+		}
+		if d.Err != nil {
+			er.Error = d.Err.Error()
+		}
+		ed.Resources[string(d.Resource)] = er
+		bound[er.Binding] = true
+
+		switch er.Binding {
+		case BindingPin:
+			ed.Hints = append(ed.Hints, fmt.Sprintf("%s is pinned to %.1f instead of %.1f, unpin it to give it back to the policy",
+				d.Resource, d.NewLimit, d.ProposedLimit))
+		case BindingFloor:
+			ed.Hints = append(ed.Hints, fmt.Sprintf("%s's limit %.1f is under the minimum, it gets %.1f until its usage grows",
+				d.Resource, d.ProposedLimit, d.NewLimit))
+		case BindingFailSafe:
+			ed.Hints = append(ed.Hints, fmt.Sprintf("%s's usage readings are stale, it is on the fail-safe limit %.1f until they come back",
+				d.Resource, d.NewLimit))
+		case BindingCapacity:
+			ed.Hints = append(ed.Hints, fmt.Sprintf("%s's limit %.1f is over the capacity of the node %.0f, only less load on the node would help",
+				d.Resource, d.ProposedLimit, capacity[d.Resource]))
+		}
+		if er.Error != "" {
+			ed.Hints = append(ed.Hints, fmt.Sprintf("%s's limit couldn't be written : %s", d.Resource, er.Error))
+		}
+	}
+	if queue < 0 {
+		queue = 0
+	} else if queue > first.TokenReservation {
+		queue = first.TokenReservation
+	}
+	ed.PredictedTokenQueue = queue
+
+	switch {
+	case bound[BindingTokens]:
+		if enough, ok := kumonitor.EnoughReservation(ds); ok {
+			ed.EnoughReservation = enough
+			ed.Hints = append(ed.Hints, fmt.Sprintf("the limits spend all the %.1f available tokens, "+
+				"a token reservation of %.1f instead of %.1f would let every resource follow its usage",
+				first.AvailableToken, enough, first.TokenReservation))
+		} else {
+			ed.Hints = append(ed.Hints, fmt.Sprintf("the limits spend all the %.1f available tokens, "+
+				"and no token reservation is enough with these dynamic weights", first.AvailableToken))
+		}
+	case bound[BindingStatic]:
+		ed.Hints = append(ed.Hints, "the static policy splits the token reservation evenly whatever the usages, the kuscale policy follows them")
+	case len(ed.Hints) == 0:
+		ed.Hints = append(ed.Hints, fmt.Sprintf("no limit is bound, they follow the usages with %.1f tokens to spare", ed.TokenBalance))
+	}
+	return ed
+}
+
+/*
+Func Name : handleExplain()
+Objective : 1) Explain the last n decisions of /v1/explain/<namespace>/<pod>
+			2) Answer JSON, or text with format=text
+*/
+func (s *Server) handleExplain(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"+APIVersion+"/explain/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s should be /%s/explain/<namespace>/<pod>", r.URL.Path, APIVersion))
+		return
+	}
+	namespace, podName := parts[0], parts[1]
+
+	n := defaultExplained
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("n should be a positive number, not %q", v))
+			return
+		}
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "text" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown format %q", format))
+		return
+	}
+
+	explanation := Explanation{Namespace: namespace, Pod: podName, Decisions: []ExplainedDecision{}}
+	var ok bool
+	s.m.Do(func() {
+		var pi *kumonitor.PodInfo
+		if pi, ok = s.m.RunningPodMap[kumonitor.PodKey(namespace, podName)]; ok {
+			explanation.Paused = pi.Paused() || s.m.Paused()
+		}
+	})
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%w: %s/%s", kumonitor.ErrNoSuchPod, namespace, podName))
+		return
+	}
+	for _, ds := range s.explainer.last(kumonitor.PodKey(namespace, podName), n) {
+		explanation.Decisions = append(explanation.Decisions, explain(ds, s.Capacity))
+	}
+
+	if format == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := explanation.WriteText(w); err != nil {
+			klog.V(4).Info("Couldn't write explanation : ", err)
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, explanation)
+}
+
+// WriteText writes the explanation for people, newest decision last.
+func (e *Explanation) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Pod %s/%s\n", e.Namespace, e.Pod)
+	if e.Paused {
+		fmt.Fprintln(tw, "Autoscaling is paused, the limits stay as they are until it is resumed")
+	}
+	if len(e.Decisions) == 0 {
+		fmt.Fprintln(tw, "No decision yet")
+	}
+	for _, d := range e.Decisions {
+		fmt.Fprintf(tw, "\n%s by %s (%s)\n", d.Time.UTC().Format(time.RFC3339), d.Policy, d.Branch)
+		fmt.Fprintf(tw, "  tokens : reservation %.1f, queue %.1f, available %.1f, balance %.1f, predicted queue %.1f\n",
+			d.TokenReservation, d.TokenQueue, d.AvailableToken, d.TokenBalance, d.PredictedTokenQueue)
+		fmt.Fprintln(tw, "  RESOURCE\tUSAGE\tAVG\tWEIGHT\tPRICE\tLIMIT\tPROPOSED\tNEW\tOUTCOME\tBINDING")
+		names := make([]string, 0, len(d.Resources))
+		for name := range d.Resources {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			r := d.Resources[name]
+			fmt.Fprintf(tw, "  %s\t%.1f\t%.1f\t%.2f\t%g\t%.1f\t%.1f\t%.1f\t%s\t%s\n", name,
+				r.Usage, r.AvgUsage, r.DynamicWeight, r.Price, r.OldLimit, r.ProposedLimit, r.NewLimit, r.Outcome, r.Binding)
+		}
+		for _, hint := range d.Hints {
+			fmt.Fprintf(tw, "  - %s\n", hint)
+		}
+	}
+	return tw.Flush()
+}
+
+// Explain returns the last n decisions of a pod, 0 being the default.
+func (c *Client) Explain(namespace, podName string, n int) (*Explanation, error) {
+	var explanation Explanation
+	path := "/explain/" + url.PathEscape(namespace) + "/" + url.PathEscape(podName)
+	if n > 0 {
+		path += "?n=" + strconv.Itoa(n)
+	}
+	return &explanation, c.call(http.MethodGet, path, nil, &explanation)
+}
@@ -0,0 +1,77 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package kuapi
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kumonitor"
+)
+
+// history keeps the last samples of every running pod. It is a
+// kumonitor.Observer.
+type history struct {
+	mu      sync.Mutex
+	size    int
+	samples map[string][]Sample // by kumonitor.PodKey
+}
+
+func newHistory(size int) *history {
+	return &history{size: size, samples: make(map[string][]Sample)}
+}
+
+func newSample(now int64, pi *kumonitor.PodInfo) Sample {
+	sample := Sample{
+		Time:           time.Unix(0, now),
+		TokenQueue:     pi.TokenQueue,
+		AvailableToken: pi.AvailableToken(),
+		Resources:      make(map[string]SampleResource),
+	}
+	for rn, ri := range pi.RIs {
+		sample.Resources[string(rn)] = SampleResource{Usage: ri.Usage(), Limit: ri.Limit()}
+	}
+	return sample
+}
+
+func (h *history) PodAdded(now int64, pi *kumonitor.PodInfo) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.samples[pi.Key()] = []Sample{newSample(now, pi)}
+}
+
+func (h *history) PodCompleted(now int64, pi *kumonitor.PodInfo) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.samples, pi.Key())
+}
+
+func (h *history) Ticked(now int64, m *kumonitor.Monitor) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for key, pi := range m.RunningPodMap {
+		samples := append(h.samples[key], newSample(now, pi))
+		if len(samples) > h.size {
+			samples = samples[len(samples)-h.size:]
+		}
+		h.samples[key] = samples
+	}
+}
+
+func (h *history) get(key string) ([]Sample, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	samples, ok := h.samples[key]
+	return append([]Sample(nil), samples...), ok
+}
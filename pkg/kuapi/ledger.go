@@ -0,0 +1,144 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package kuapi
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kuledger"
+	"k8s.io/klog"
+)
+
+// LedgerValues encodes q as the parameters of /v1/ledger.
+func LedgerValues(q kuledger.Query) url.Values {
+	values := url.Values{}
+	if !q.From.IsZero() {
+		values.Set("from", q.From.Format(time.RFC3339))
+	}
+	if !q.To.IsZero() {
+		values.Set("to", q.To.Format(time.RFC3339))
+	}
+	if q.Namespace != "" {
+		values.Set("namespace", q.Namespace)
+	}
+	if q.Pod != "" {
+		values.Set("pod", q.Pod)
+	}
+	return values
+}
+
+func parseLedgerQuery(values url.Values) (kuledger.Query, error) {
+	q := kuledger.Query{Namespace: values.Get("namespace"), Pod: values.Get("pod")}
+	for _, bound := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &q.From}, {"to", &q.To}} {
+		if v := values.Get(bound.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return q, fmt.Errorf("%s should be RFC3339 : %w", bound.name, err)
+			}
+			*bound.t = t
+		}
+	}
+	return q, nil
+}
+
+func (s *Server) ledgerQuery(w http.ResponseWriter, r *http.Request) (kuledger.Query, bool) {
+	if !allow(w, r, http.MethodGet) {
+		return kuledger.Query{}, false
+	}
+	if s.LedgerDir == "" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("the ledger is not kept, see -ledgerDir"))
+		return kuledger.Query{}, false
+	}
+	q, err := parseLedgerQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return q, false
+	}
+	return q, true
+}
+
+// handleLedger streams the entries as JSON lines, or as CSV with format=csv.
+func (s *Server) handleLedger(w http.ResponseWriter, r *http.Request) {
+	q, ok := s.ledgerQuery(w, r)
+	if !ok {
+		return
+	}
+	var err error
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		err = kuledger.WriteJSON(w, s.LedgerDir, q)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		err = kuledger.WriteCSV(w, s.LedgerDir, q)
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown format %q, json or csv", format))
+		return
+	}
+	if err != nil {
+		// The status is sent already
+		klog.V(4).Info("Couldn't send ledger : ", err)
+	}
+}
+
+// handleLedgerSummary sums the entries by pod, or by namespace with by=namespace.
+func (s *Server) handleLedgerSummary(w http.ResponseWriter, r *http.Request) {
+	q, ok := s.ledgerQuery(w, r)
+	if !ok {
+		return
+	}
+	by := r.URL.Query().Get("by")
+	if by != "" && by != "pod" && by != "namespace" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown by %q, pod or namespace", by))
+		return
+	}
+	usages, err := kuledger.Summarize(s.LedgerDir, q, by == "namespace")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, usages)
+}
+
+// Ledger copies the entries matching q into w, as JSON lines or as CSV.
+func (c *Client) Ledger(q kuledger.Query, format string, w io.Writer) error {
+	values := LedgerValues(q)
+	values.Set("format", format)
+	resp, err := c.t.Do(http.MethodGet, "/"+APIVersion+"/ledger?"+values.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, resp)
+	if cerr := resp.Close(); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// LedgerSummary sums the entries matching q by pod, or by namespace.
+func (c *Client) LedgerSummary(q kuledger.Query, byNamespace bool) ([]kuledger.Usage, error) {
+	values := LedgerValues(q)
+	if byNamespace {
+		values.Set("by", "namespace")
+	}
+	var usages []kuledger.Usage
+	return usages, c.call(http.MethodGet, "/ledger/summary?"+values.Encode(), nil, &usages)
+}
@@ -0,0 +1,402 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package kuapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kumonitor"
+	"github.com/sslab-konkuk/KuScale/pkg/kutrace"
+	"k8s.io/klog"
+)
+
+type Server struct {
+	m       *kumonitor.Monitor
+	history *history
+	mux     *http.ServeMux
+
+	// TraceDir is where the recorder writes, served by /v1/trace.
+	TraceDir string
+	// LedgerDir is where the ledger is, served by /v1/ledger.
+	LedgerDir string
+	// Capacity of the node, which /v1/explain tells when it bounds a limit.
+	Capacity map[kumonitor.ResourceName]float64
+
+	explainer *explainer
+}
+
+// NewServer serves the state of m, keeping historySize ticks of every pod.
+func NewServer(m *kumonitor.Monitor, historySize int) *Server {
+	s := &Server{m: m, history: newHistory(historySize), explainer: newExplainer(historySize), mux: http.NewServeMux()}
+	m.AddObserver(s.history)
+	m.AddObserver(s.explainer)
+
+	prefix := "/" + APIVersion
+	s.mux.HandleFunc(prefix+"/status", s.handleStatus)
+	s.mux.HandleFunc(prefix+"/pause", s.handlePause(true))
+	s.mux.HandleFunc(prefix+"/resume", s.handlePause(false))
+	s.mux.HandleFunc(prefix+"/pods", s.handlePods)
+	s.mux.HandleFunc(prefix+"/pods/", s.handlePod)
+	s.mux.HandleFunc(prefix+"/policy", s.handlePolicy)
+	s.mux.HandleFunc(prefix+"/trace", s.handleTrace)
+	s.mux.HandleFunc(prefix+"/ledger", s.handleLedger)
+	s.mux.HandleFunc(prefix+"/ledger/summary", s.handleLedgerSummary)
+	s.mux.HandleFunc(prefix+"/explain/", s.handleExplain)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) { s.mux.ServeHTTP(w, r) }
+
+// ReadOnly serves only the GET requests, for a listener anyone can reach.
+func (s *Server) ReadOnly() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeError(w, http.StatusForbidden, fmt.Errorf("%s is only allowed on the control socket", r.Method))
+			return
+		}
+		s.mux.ServeHTTP(w, r)
+	})
+}
+
+/*
+Func Name : Run()
+Objective : 1) Listen on the unix socket, replacing a stale one
+			2) Serve the API until stopCh is closed
+*/
+func (s *Server) Run(socketFile string, stopCh chan string) error {
+	if err := os.MkdirAll(filepath.Dir(socketFile), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(socketFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	listener, err := net.Listen("unix", socketFile)
+	if err != nil {
+		return err
+	}
+	// Only root and the group of the socket can control KuScale
+	if err := os.Chmod(socketFile, 0660); err != nil {
+		listener.Close()
+		return err
+	}
+
+	server := &http.Server{Handler: s}
+	go func() {
+		<-stopCh
+		server.Close()
+		os.Remove(socketFile)
+	}()
+	klog.V(4).Info("Serving control API on ", socketFile)
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	klog.V(4).Info("Shutting control API down")
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		klog.V(4).Info("Couldn't write control API response : ", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, Error{Error: err.Error()})
+}
+
+func writeMonitorError(w http.ResponseWriter, err error) {
+	if errors.Is(err, kumonitor.ErrNoSuchPod) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if errors.Is(err, kumonitor.ErrNotEnforcing) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	writeError(w, http.StatusBadRequest, err)
+}
+
+func allow(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed", r.Method))
+		return false
+	}
+	return true
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body : %w", err))
+		return false
+	}
+	return true
+}
+
+func newPod(pi *kumonitor.PodInfo) Pod {
+	pod := Pod{
+		Name:             pi.PodName,
+		Namespace:        pi.Namespace,
+		UID:              pi.UID,
+		Owner:            pi.Owner,
+		QOSClass:         pi.QOSClass,
+		Status:           string(pi.Status()),
+		Paused:           pi.Paused(),
+		FailSafe:         pi.FailSafe(),
+		TokenReservation: pi.TokenReservation,
+		TokenQueue:       pi.TokenQueue,
+		AvailableToken:   pi.AvailableToken(),
+		UpdatedCount:     pi.UpdatedCount,
+		Resources:        make(map[string]Resource),
+	}
+	for rn, ri := range pi.RIs {
+		resource := Resource{
+			Usage:         ri.Usage(),
+			AvgUsage:      ri.AvgUsage(),
+			DynamicWeight: ri.DynamicWeight(),
+			Limit:         ri.Limit(),
+		}
+		resource.EnforcedLimit, resource.Drifted, resource.EnforcementBroken = ri.Enforced()
+		if limit, until, ok := ri.Pinned(); ok && ri.PinnedByAnnotation() {
+			resource.Pin = &Pin{Limit: limit, Annotation: true}
+		} else if ok {
+			resource.Pin = &Pin{Limit: limit, Until: time.Unix(0, until)}
+		}
+		pod.Resources[string(rn)] = resource
+	}
+	return pod
+}
+
+// newStatus returns the node-wide state of m. Call it in m.Do.
+func newStatus(m *kumonitor.Monitor) Status {
+	return Status{
+		APIVersion:     APIVersion,
+		Node:           m.NodeName(),
+		Time:           time.Unix(0, m.Now()),
+		Period:         m.Period().Seconds(),
+		Policy:         m.Policy().Name(),
+		PolicyParams:   m.Policy().Params(),
+		MonitoringMode: m.MonitoringMode(),
+		Paused:         m.Paused(),
+		RunningPods:    len(m.RunningPodMap),
+		CompletedPods:  len(m.CompletedPodMap),
+	}
+}
+
+func (s *Server) status() Status {
+	var status Status
+	s.m.Do(func() { status = newStatus(s.m) })
+	return status
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, s.status())
+}
+
+func (s *Server) handlePause(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !allow(w, r, http.MethodPost) {
+			return
+		}
+		s.m.SetPaused("", "", paused)
+		writeJSON(w, http.StatusOK, s.status())
+	}
+}
+
+func (s *Server) policySpec() PolicySpec {
+	var spec PolicySpec
+	s.m.Do(func() { spec = PolicySpec{Name: s.m.Policy().Name(), Params: s.m.Policy().Params()} })
+	return spec
+}
+
+func (s *Server) handlePolicy(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var spec PolicySpec
+		if !readJSON(w, r, &spec) {
+			return
+		}
+		policy, err := kumonitor.NewPolicy(spec.Name, spec.Params)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		s.m.SetPolicy(policy)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed", r.Method))
+		return
+	}
+	writeJSON(w, http.StatusOK, s.policySpec())
+}
+
+func (s *Server) handleTrace(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	if s.TraceDir == "" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("trace is not recorded, see -traceDir"))
+		return
+	}
+	files, err := kutrace.TraceFiles(s.TraceDir)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			// Removed by the rotation meanwhile
+			continue
+		}
+		_, err = io.Copy(w, f)
+		f.Close()
+		if err != nil {
+			klog.V(4).Info("Couldn't send trace : ", err)
+			return
+		}
+	}
+}
+
+func (s *Server) handlePods(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	var pods []Pod
+	s.m.Do(func() { pods = sortedPods(s.m.RunningPodMap) })
+	writeJSON(w, http.StatusOK, pods)
+}
+
+func (s *Server) getPod(w http.ResponseWriter, namespace, podName string) {
+	key := kumonitor.PodKey(namespace, podName)
+	var pod Pod
+	var ok bool
+	s.m.Do(func() {
+		var pi *kumonitor.PodInfo
+		if pi, ok = s.m.RunningPodMap[key]; ok {
+			pod = newPod(pi)
+		}
+	})
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%w: %s", kumonitor.ErrNoSuchPod, key))
+		return
+	}
+	writeJSON(w, http.StatusOK, pod)
+}
+
+/*
+Func Name : handlePod()
+Objective : 1) Route /v1/pods/<namespace>/<pod>[/<action>]
+			2) Answer the pod after a change
+*/
+func (s *Server) handlePod(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/"+APIVersion+"/pods/")
+	parts := strings.SplitN(rest, "/", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s should be /%s/pods/<namespace>/<pod>[/<action>]", r.URL.Path, APIVersion))
+		return
+	}
+	namespace, podName, action := parts[0], parts[1], ""
+	if len(parts) == 3 {
+		action = parts[2]
+	}
+
+	var err error
+	switch action {
+	case "":
+		if allow(w, r, http.MethodGet) {
+			s.getPod(w, namespace, podName)
+		}
+		return
+
+	case "history":
+		if !allow(w, r, http.MethodGet) {
+			return
+		}
+		samples, ok := s.history.get(kumonitor.PodKey(namespace, podName))
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("%w: %s/%s", kumonitor.ErrNoSuchPod, namespace, podName))
+			return
+		}
+		writeJSON(w, http.StatusOK, History{Pod: podName, Samples: samples})
+		return
+
+	case "pin":
+		switch r.Method {
+		case http.MethodPost:
+			var req PinRequest
+			if !readJSON(w, r, &req) {
+				return
+			}
+			duration, perr := time.ParseDuration(req.Duration)
+			if perr != nil || duration <= 0 {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid duration %q", req.Duration))
+				return
+			}
+			until := s.m.Now() + int64(duration)
+			err = s.m.PinLimit(namespace, podName, kumonitor.ResourceName(strings.ToUpper(req.Resource)), req.Limit, until)
+		case http.MethodDelete:
+			resource := strings.ToUpper(r.URL.Query().Get("resource"))
+			err = s.m.Unpin(namespace, podName, kumonitor.ResourceName(resource))
+		default:
+			w.Header().Set("Allow", "POST, DELETE")
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed", r.Method))
+			return
+		}
+
+	case "reservation":
+		if !allow(w, r, http.MethodPut) {
+			return
+		}
+		var req ReservationRequest
+		if !readJSON(w, r, &req) {
+			return
+		}
+		err = s.m.SetTokenReservation(namespace, podName, req.TokenReservation)
+
+	case "pause", "resume":
+		if !allow(w, r, http.MethodPost) {
+			return
+		}
+		err = s.m.SetPaused(namespace, podName, action == "pause")
+
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown path %s", r.URL.Path))
+		return
+	}
+
+	if err != nil {
+		writeMonitorError(w, err)
+		return
+	}
+	s.getPod(w, namespace, podName)
+}
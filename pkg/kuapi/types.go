@@ -0,0 +1,174 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package kuapi is the local control API of KuScale. It is HTTP with JSON
+// bodies on a unix socket, and every path starts with the API version.
+//
+//	GET    /v1/status                  node-wide state
+//	POST   /v1/pause, /v1/resume       autoscaling of every pod
+//	GET    /v1/pods                    running pods
+//	GET    /v1/pods/<pod>              one running pod
+//	GET    /v1/pods/<pod>/history      recent ticks of the pod
+//	POST   /v1/pods/<pod>/pin          PinRequest
+//	DELETE /v1/pods/<pod>/pin?resource=CPU
+//	PUT    /v1/pods/<pod>/reservation  ReservationRequest
+//	POST   /v1/pods/<pod>/pause, /v1/pods/<pod>/resume
+//	GET    /v1/policy
+//	PUT    /v1/policy                  PolicySpec
+//	GET    /v1/trace                   the recorded trace, as JSON lines
+//	GET    /v1/ledger?from=&to=&namespace=&pod=&format=csv
+//	GET    /v1/ledger/summary?from=&to=&by=namespace
+//	GET    /v1/explain/<namespace>/<pod>?n=5&format=text
+package kuapi
+
+import (
+	"time"
+)
+
+const APIVersion = "v1"
+
+type Status struct {
+	APIVersion     string             `json:"apiVersion"`
+	Node           string             `json:"node"`
+	Time           time.Time          `json:"time"`
+	Period         float64            `json:"period"` // seconds
+	Policy         string             `json:"policy"`
+	PolicyParams   map[string]float64 `json:"policyParams,omitempty"`
+	MonitoringMode bool               `json:"monitoringMode"`
+	Paused         bool               `json:"paused"`
+	RunningPods    int                `json:"runningPods"`
+	CompletedPods  int                `json:"completedPods"`
+}
+
+type Pod struct {
+	Name             string              `json:"name"`
+	Namespace        string              `json:"namespace,omitempty"`
+	UID              string              `json:"uid,omitempty"`      // from the pod informer
+	Owner            string              `json:"owner,omitempty"`    // Kind/Name of the controller
+	QOSClass         string              `json:"qosClass,omitempty"` // from the pod informer
+	Status           string              `json:"status"`
+	Paused           bool                `json:"paused"`
+	FailSafe         bool                `json:"failSafe"` // on fail-safe limits for stale usage readings
+	TokenReservation float64             `json:"tokenReservation"`
+	TokenQueue       float64             `json:"tokenQueue"`
+	AvailableToken   float64             `json:"availableToken"`
+	UpdatedCount     int64               `json:"updatedCount"`
+	Resources        map[string]Resource `json:"resources"`
+}
+
+type Resource struct {
+	Usage         float64 `json:"usage"`
+	AvgUsage      float64 `json:"avgUsage"`
+	DynamicWeight float64 `json:"dynamicWeight"`
+	Limit         float64 `json:"limit"`
+	Pin           *Pin    `json:"pin,omitempty"`
+	// Read back at the last reconciliation, 0 if it didn't run yet
+	EnforcedLimit     float64 `json:"enforcedLimit,omitempty"`
+	Drifted           bool    `json:"drifted,omitempty"`           // the limit in effect isn't Limit
+	EnforcementBroken bool    `json:"enforcementBroken,omitempty"` // the usage stays over Limit
+}
+
+type Pin struct {
+	Limit float64   `json:"limit"`
+	Until time.Time `json:"until"` // zero when pinned by an annotation
+	// Pinned by the kuscale/cpu_limit or kuscale/gpu_limit annotation of the pod
+	Annotation bool `json:"annotation,omitempty"`
+}
+
+// Sample is the state of a pod after a tick.
+type Sample struct {
+	Time           time.Time                 `json:"time"`
+	TokenQueue     float64                   `json:"tokenQueue"`
+	AvailableToken float64                   `json:"availableToken"`
+	Resources      map[string]SampleResource `json:"resources"`
+}
+
+type SampleResource struct {
+	Usage float64 `json:"usage"`
+	Limit float64 `json:"limit"`
+}
+
+type History struct {
+	Pod     string   `json:"pod"`
+	Samples []Sample `json:"samples"`
+}
+
+type PinRequest struct {
+	Resource string  `json:"resource"` // CPU or GPU
+	Limit    float64 `json:"limit"`
+	Duration string  `json:"duration"` // time.ParseDuration, like 10m
+}
+
+type ReservationRequest struct {
+	TokenReservation float64 `json:"tokenReservation"`
+}
+
+type PolicySpec struct {
+	Name   string             `json:"name"`
+	Params map[string]float64 `json:"params,omitempty"`
+}
+
+// Dump is the full state of KuScale, written to a file on SIGUSR1.
+type Dump struct {
+	Status          Status `json:"status"`
+	DecisionTracing bool   `json:"decisionTracing"`
+	Pods            []Pod  `json:"pods"`
+	CompletedPods   []Pod  `json:"completedPods"`
+	Profiler        string `json:"profiler,omitempty"` // latency summary, when profiling
+}
+
+// Explanation breaks down the last decisions on the limits of a pod.
+type Explanation struct {
+	Namespace string              `json:"namespace"`
+	Pod       string              `json:"pod"`
+	Paused    bool                `json:"paused"`
+	Decisions []ExplainedDecision `json:"decisions"` // oldest first
+}
+
+// ExplainedDecision is the decision on the limits of a pod at a tick.
+type ExplainedDecision struct {
+	Time             time.Time `json:"time"`
+	Policy           string    `json:"policy"`
+	Branch           string    `json:"branch,omitempty"`
+	TokenReservation float64   `json:"tokenReservation"`
+	TokenQueue       float64   `json:"tokenQueue"`
+	AvailableToken   float64   `json:"availableToken"`
+	// TokenBalance is what the proposed limits leave of the available tokens.
+	TokenBalance float64 `json:"tokenBalance"`
+	// PredictedTokenQueue is the token queue after the next period if the
+	// pod uses its new limits.
+	PredictedTokenQueue float64 `json:"predictedTokenQueue"`
+	// EnoughReservation is the least token reservation with which no
+	// resource is bound by the tokens, when there is one.
+	EnoughReservation float64                      `json:"enoughReservation,omitempty"`
+	Resources         map[string]ExplainedResource `json:"resources"`
+	Hints             []string                     `json:"hints"` // what would change the outcome
+}
+
+type ExplainedResource struct {
+	Usage         float64 `json:"usage"`
+	AvgUsage      float64 `json:"avgUsage"`
+	DynamicWeight float64 `json:"dynamicWeight"`
+	Price         float64 `json:"price"`
+	OldLimit      float64 `json:"oldLimit"`
+	ProposedLimit float64 `json:"proposedLimit"`
+	NewLimit      float64 `json:"newLimit"`
+	Outcome       string  `json:"outcome"`
+	Error         string  `json:"error,omitempty"`
+	// Binding is what bounds the limit : tokens, floor, capacity, pin, static or none.
+	Binding string `json:"binding"`
+}
+
+type Error struct {
+	Error string `json:"error"`
+}
@@ -0,0 +1,135 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kuaudit writes an audit log of every limit change of the monitor,
+// and of every change suppressed by a pin or the minimum limit, with the
+// inputs the policy decided it from. It is JSON lines in rotating files.
+package kuaudit
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kumonitor"
+	kutrace "github.com/sslab-konkuk/KuScale/pkg/kutrace"
+	"k8s.io/klog"
+)
+
+const (
+	filePrefix = "audit-"
+	fileSuffix = ".jsonl"
+)
+
+// Record is a line of the audit log.
+type Record struct {
+	Time      time.Time `json:"time"`
+	Pod       string    `json:"pod"`
+	Namespace string    `json:"namespace,omitempty"`
+	Resource  string    `json:"resource"`
+	OldLimit  float64   `json:"oldLimit"`
+	NewLimit  float64   `json:"newLimit"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+	Policy    string    `json:"policy,omitempty"`
+	Branch    string    `json:"branch,omitempty"`
+	Inputs    Inputs    `json:"inputs"`
+}
+
+// Inputs are what the policy decided the limit from.
+type Inputs struct {
+	ProposedLimit    float64 `json:"proposedLimit"`
+	Usage            float64 `json:"usage"`
+	AvgUsage         float64 `json:"avgUsage"`
+	DynamicWeight    float64 `json:"dynamicWeight"`
+	AvailableToken   float64 `json:"availableToken"`
+	TokenQueue       float64 `json:"tokenQueue"`
+	TokenReservation float64 `json:"tokenReservation"`
+}
+
+func NewRecord(d *kumonitor.Decision) Record {
+	rec := Record{
+		Time:      time.Unix(0, d.Time).UTC(),
+		Pod:       d.Pod,
+		Namespace: d.Namespace,
+		Resource:  string(d.Resource),
+		OldLimit:  d.OldLimit,
+		NewLimit:  d.NewLimit,
+		Outcome:   d.Outcome,
+		Policy:    d.Policy,
+		Branch:    d.Branch,
+		Inputs: Inputs{
+			ProposedLimit:    d.ProposedLimit,
+			Usage:            d.Usage,
+			AvgUsage:         d.AvgUsage,
+			DynamicWeight:    d.DynamicWeight,
+			AvailableToken:   d.AvailableToken,
+			TokenQueue:       d.TokenQueue,
+			TokenReservation: d.TokenReservation,
+		},
+	}
+	if d.Err != nil {
+		rec.Error = d.Err.Error()
+	}
+	return rec
+}
+
+// Log writes the decisions of a monitor. It is a kumonitor.DecisionObserver.
+type Log struct {
+	mu sync.Mutex
+	w  *kutrace.RotatingWriter
+}
+
+// Open starts a new audit file in dir, moving on to a new one over maxSize
+// bytes and keeping the last maxFiles.
+func Open(dir string, maxSize int64, maxFiles int) (*Log, error) {
+	w, err := kutrace.NewRotatingWriter(dir, filePrefix, fileSuffix, maxSize, maxFiles, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{w: w}, nil
+}
+
+func (l *Log) Decided(d *kumonitor.Decision) {
+	if !d.Changed() {
+		return
+	}
+	data, err := json.Marshal(NewRecord(d))
+	if err != nil {
+		klog.Info("Couldn't encode audit record : ", err)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.w.Write(append(data, '\n')); err != nil {
+		klog.Info("Couldn't write audit record : ", err)
+	}
+}
+
+func (l *Log) PodAdded(now int64, pi *kumonitor.PodInfo)     {}
+func (l *Log) PodCompleted(now int64, pi *kumonitor.PodInfo) {}
+
+func (l *Log) Ticked(now int64, m *kumonitor.Monitor) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.w.Flush(); err != nil {
+		klog.Info("Couldn't write audit log : ", err)
+	}
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Close()
+}
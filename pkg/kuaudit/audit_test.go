@@ -0,0 +1,100 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kuaudit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kumonitor"
+)
+
+func TestLogRotatesAtMaxSizeKeepingMaxFiles(t *testing.T) {
+	const maxSizeMB, maxFiles, decisions = 1, 3, 20000
+	dir := t.TempDir()
+	l, err := Open(dir, maxSizeMB<<20, maxFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC).UnixNano()
+	for i := 0; i < decisions; i++ {
+		l.Decided(&kumonitor.Decision{
+			Time: start + int64(i), Pod: "pod0", Namespace: "default", Resource: "CPU",
+			Policy: "token", Outcome: kumonitor.OutcomeApplied,
+			OldLimit: 100, ProposedLimit: 150, NewLimit: 150, TokenReservation: 300,
+		})
+		// Unchanged limits aren't audited
+		l.Decided(&kumonitor.Decision{Time: start + int64(i), Pod: "pod1", Resource: "CPU", OldLimit: 100, ProposedLimit: 100, NewLimit: 100})
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, filePrefix+"*"+fileSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != maxFiles {
+		t.Fatalf("%d audit files, want %d", len(files), maxFiles)
+	}
+	sort.Strings(files)
+
+	// The kept files have the last decisions, in order
+	var times []int64
+	for i, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > maxSizeMB<<20 {
+			t.Errorf("%s has %d bytes, over %d MB", file, info.Size(), maxSizeMB)
+		}
+		if i < len(files)-1 && info.Size() < maxSizeMB<<19 {
+			t.Errorf("%s was rotated at %d bytes, under %d MB", file, info.Size(), maxSizeMB)
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var rec Record
+			if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+				t.Fatalf("%s : %v", file, err)
+			}
+			if rec.Pod != "pod0" {
+				t.Errorf("audited the unchanged limit of %s", rec.Pod)
+			}
+			times = append(times, rec.Time.UnixNano())
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(times) == 0 || len(times) >= decisions {
+		t.Fatalf("kept %d of %d decisions", len(times), decisions)
+	}
+	for i, tm := range times {
+		if want := start + int64(decisions-len(times)+i); tm != want {
+			t.Fatalf("record %d of the kept files is of %v, want %v", i, time.Unix(0, tm).UTC(), time.Unix(0, want).UTC())
+		}
+	}
+}
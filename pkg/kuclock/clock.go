@@ -0,0 +1,78 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kuclock is the time of the control loop. It is monotonic, so that a
+// step of the wall clock doesn't turn into tokens, and can be replaced by a
+// fake clock which only moves when it is told to.
+package kuclock
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Clock tells the time in nanoseconds since the Unix epoch, never going back.
+type Clock interface {
+	Now() int64
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker is a time.Ticker of a Clock.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the clock of the host.
+var Real Clock = newMonotonic()
+
+// monotonic is CLOCK_MONOTONIC anchored to the wall clock when it was made,
+// so that its times can still be read as dates in traces and logs.
+type monotonic struct {
+	wall, mono int64
+}
+
+func newMonotonic() *monotonic {
+	mono, err := Monotonic()
+	if err != nil {
+		panic(err)
+	}
+	return &monotonic{wall: time.Now().UnixNano(), mono: mono}
+}
+
+func (c *monotonic) Now() int64 {
+	mono, err := Monotonic()
+	if err != nil {
+		// CLOCK_MONOTONIC is always there on Linux
+		panic(err)
+	}
+	return c.wall + mono - c.mono
+}
+
+func (c *monotonic) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
+
+// Monotonic reads CLOCK_MONOTONIC in nanoseconds.
+func Monotonic() (int64, error) {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return 0, err
+	}
+	return unix.TimespecToNsec(ts), nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kuclock
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake is a Clock which only moves with Advance. Its tickers fire during
+// Advance, and like a time.Ticker drop the ticks nobody received.
+type Fake struct {
+	mu      sync.Mutex
+	now     int64
+	tickers []*fakeTicker
+}
+
+func NewFake(now int64) *Fake { return &Fake{now: now} }
+
+func (f *Fake) Now() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Advance moves the clock d forward, firing every ticker due meanwhile.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if d < 0 {
+		return
+	}
+	f.now += int64(d)
+	tickers := f.tickers[:0]
+	for _, t := range f.tickers {
+		if t.stopped {
+			continue
+		}
+		for t.next <= f.now {
+			select {
+			case t.c <- time.Unix(0, t.next):
+			default:
+			}
+			t.next += int64(t.period)
+		}
+		tickers = append(tickers, t)
+	}
+	f.tickers = tickers
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for kuclock.Fake.NewTicker")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := &fakeTicker{clock: f, period: d, next: f.now + int64(d), c: make(chan time.Time, 1)}
+	f.tickers = append(f.tickers, t)
+	return t
+}
+
+type fakeTicker struct {
+	clock   *Fake
+	period  time.Duration
+	next    int64
+	c       chan time.Time
+	stopped bool
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.c }
+
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.stopped = true
+}
@@ -0,0 +1,430 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package kuconfig is the configuration of the KuScale daemon, read from a
+// YAML file and the command-line flags.
+//
+// Only the sections in Reloadable are applied when the file changes, the
+// others are read once at start and need a restart.
+package kuconfig
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kufs"
+	"github.com/sslab-konkuk/KuScale/pkg/kumonitor"
+	"github.com/sslab-konkuk/KuScale/pkg/kuruntime"
+	"github.com/sslab-konkuk/KuScale/pkg/kusync"
+	"github.com/sslab-konkuk/KuScale/pkg/kutokenmanager"
+	"gopkg.in/yaml.v2"
+)
+
+// Reloadable are the sections applied while KuScale is running. The policy
+// takes effect from the next tick, and the prices for the pods which come
+// after the reload. (*Config).Reload copies them.
+var Reloadable = []string{"policy", "prices"}
+
+type Config struct {
+	NodeName string `yaml:"nodeName"`
+	GPUs     int    `yaml:"gpus"` // shared by the pods, for the capacity metrics
+
+	Monitor    MonitorConfig   `yaml:"monitor"`
+	Policy     PolicyConfig    `yaml:"policy"`
+	Prices     PricesConfig    `yaml:"prices"`
+	Exporter   ExporterConfig  `yaml:"exporter"`
+	BPFWatcher bool            `yaml:"bpfWatcher"`
+	Control    ControlConfig   `yaml:"control"`
+	Trace      TraceConfig     `yaml:"trace"`
+	Audit      AuditConfig     `yaml:"audit"`
+	DumpDir    string          `yaml:"dumpDir"`   // state dumps of SIGUSR1
+	LedgerDir  string          `yaml:"ledgerDir"` // token ledger, kept across restarts
+	Shutdown   ShutdownConfig  `yaml:"shutdown"`
+	Watchdog   WatchdogConfig  `yaml:"watchdog"`
+	Reconcile  ReconcileConfig `yaml:"reconcile"`
+
+	HostRoot  string        `yaml:"hostRoot"`
+	Roots     kufs.Roots    `yaml:"roots"`
+	Runtime   RuntimeConfig `yaml:"runtime"`
+	GPUModule GPUModule     `yaml:"gpuModule"`
+	Token     Token         `yaml:"token"`
+	Gemini    Gemini        `yaml:"gemini"`
+
+	Kubeconfig  string            `yaml:"kubeconfig"` // in-cluster config if empty
+	PodSync     PodSyncConfig     `yaml:"podSync"`
+	PodInformer PodInformerConfig `yaml:"podInformer"`
+}
+
+type MonitorConfig struct {
+	Period         float64 `yaml:"period"` // seconds, may be under a second
+	WindowSize     int64   `yaml:"windowSize"`
+	MonitoringMode bool    `yaml:"monitoringMode"`
+	Workers        int     `yaml:"workers"` // pods read and limits written at once
+}
+
+// MinPeriod is the shortest monitoring period, as a tick reads and writes
+// every pod's cgroup and ku-gpu-layer files.
+const MinPeriod = 100 * time.Millisecond
+
+func (c MonitorConfig) PeriodDuration() time.Duration {
+	return time.Duration(c.Period * float64(time.Second))
+}
+
+type PolicyConfig struct {
+	Name    string  `yaml:"name"`
+	StaticV float64 `yaml:"staticV"`
+}
+
+// PricesConfig are the prices of a resource, which weight its share of the tokens.
+type PricesConfig struct {
+	CPU float64 `yaml:"cpu"`
+	GPU float64 `yaml:"gpu"`
+}
+
+type ExporterConfig struct {
+	Enabled  bool `yaml:"enabled"`
+	API      bool `yaml:"api"`
+	APIWrite bool `yaml:"apiWrite"`
+}
+
+type ControlConfig struct {
+	Socket      string `yaml:"socket"`
+	HistorySize int    `yaml:"historySize"`
+}
+
+type TraceConfig struct {
+	Dir       string `yaml:"dir"`
+	MaxSizeMB int64  `yaml:"maxSizeMB"`
+	MaxFiles  int    `yaml:"maxFiles"`
+}
+
+// AuditConfig is where the audit log of the limit decisions is written.
+type AuditConfig struct {
+	Dir       string `yaml:"dir"`
+	MaxSizeMB int64  `yaml:"maxSizeMB"`
+	MaxFiles  int    `yaml:"maxFiles"`
+}
+
+// ShutdownConfig is what KuScale does with the pods when it stops.
+type ShutdownConfig struct {
+	Timeout       int64 `yaml:"timeout"` // seconds
+	RestoreLimits bool  `yaml:"restoreLimits"`
+	// SafeLimits are restored when the limits set before KuScale are unknown.
+	SafeLimits LimitsConfig `yaml:"safeLimits"`
+}
+
+// WatchdogConfig is when the pods are moved to fail-safe limits.
+type WatchdogConfig struct {
+	Enabled bool  `yaml:"enabled"`
+	Grace   int64 `yaml:"grace"` // seconds a tick, a limit write or usage readings may be late
+	// FailSafeLimits are used when the limits set before KuScale are unknown.
+	FailSafeLimits LimitsConfig `yaml:"failSafeLimits"`
+}
+
+// ReconcileConfig is how the limits in effect are checked against those of KuScale.
+type ReconcileConfig struct {
+	Enabled  bool    `yaml:"enabled"`
+	Interval float64 `yaml:"interval"` // seconds
+	Action   string  `yaml:"action"`   // reapply or report
+	// Tolerance is how far in percent the usage may go over the limit.
+	Tolerance float64 `yaml:"tolerance"`
+	// Breaches are the checks in a row over the limit which flag the enforcement broken.
+	Breaches int `yaml:"breaches"`
+}
+
+// LimitsConfig are limits in percent of a core or of the GPU, 0 being no limit.
+type LimitsConfig struct {
+	CPU float64 `yaml:"cpu"`
+	GPU float64 `yaml:"gpu"`
+}
+
+// RuntimeConfig is how the CPU limits are written on the node.
+type RuntimeConfig struct {
+	LimitWriter string `yaml:"limitWriter"` // cgroup, docker or cri
+	CRISocket   string `yaml:"criSocket"`
+}
+
+// PodSyncConfig is how the limits are written to the pods of the Kubernetes API.
+type PodSyncConfig struct {
+	Enabled   bool    `yaml:"enabled"`
+	Mode      string  `yaml:"mode"`      // auto or annotations
+	Interval  float64 `yaml:"interval"`  // seconds
+	MinChange float64 `yaml:"minChange"` // percent of the synced limit
+	QPS       float64 `yaml:"qps"`       // pods patched a second
+	Burst     int     `yaml:"burst"`
+}
+
+// PodInformerConfig is how the pods of the node are watched in the Kubernetes API.
+type PodInformerConfig struct {
+	Enabled bool    `yaml:"enabled"`
+	Resync  float64 `yaml:"resync"` // seconds, 0 for never
+}
+
+type GPUModule struct {
+	Path   string `yaml:"path"`
+	SHA256 string `yaml:"sha256"`
+	Unload bool   `yaml:"unload"`
+}
+
+// Token is the extended resource advertised by the device plugin.
+type Token struct {
+	ResourceName string `yaml:"resourceName"`
+	Size         int    `yaml:"size"`
+	Socket       string `yaml:"socket"` // in the device plugin directory of kubelet
+}
+
+// Gemini is where the Gemini hook is on the host. Dir is mounted at the same
+// path in the containers, so Hook and IPCDir should be in it.
+type Gemini struct {
+	Dir    string `yaml:"dir"`
+	Hook   string `yaml:"hook"`
+	IPCDir string `yaml:"ipcDir"`
+}
+
+// Default is the config of KuScale without a file nor flags. What needs a
+// directory or a socket of the host, or writes limits on its own, is off.
+func Default() *Config {
+	return &Config{
+		NodeName:    "node4",
+		GPUs:        1,
+		Monitor:     MonitorConfig{Period: 2, WindowSize: 15, MonitoringMode: true, Workers: kumonitor.DefaultWorkers},
+		Policy:      PolicyConfig{Name: "kuscale", StaticV: 10},
+		Prices:      PricesConfig{CPU: 1, GPU: 3},
+		Exporter:    ExporterConfig{Enabled: true, API: true},
+		BPFWatcher:  false,
+		Control:     ControlConfig{HistorySize: 300},
+		Trace:       TraceConfig{MaxSizeMB: 64, MaxFiles: 10},
+		Audit:       AuditConfig{MaxSizeMB: 16, MaxFiles: 10},
+		DumpDir:     "/var/run/kuscale",
+		Shutdown:    ShutdownConfig{Timeout: 10, RestoreLimits: true},
+		Watchdog:    WatchdogConfig{Grace: 10},
+		Reconcile:   ReconcileConfig{Interval: 30, Action: kumonitor.ReconcileReapply, Tolerance: 10, Breaches: 3},
+		Roots:       kufs.DefaultRoots,
+		Runtime:     RuntimeConfig{LimitWriter: kuruntime.WriterCgroup, CRISocket: "/var/run/dockershim.sock"},
+		GPUModule:   GPUModule{Path: "./ku-gpu-layer.ko"},
+		Token:       Token{ResourceName: "kuscale.com/token", Size: 6000, Socket: "dorry-token.sock"},
+		Gemini:      Gemini(kutokenmanager.DefaultGeminiPaths),
+		PodSync:     PodSyncConfig{Mode: kusync.ModeAuto, Interval: 30, MinChange: 10, QPS: 5, Burst: 10},
+		PodInformer: PodInformerConfig{Resync: 300},
+	}
+}
+
+// AddFlags registers the flags of KuScale on fs, setting c.
+func (c *Config) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.NodeName, "NodeName", c.NodeName, "NodeName")
+	fs.IntVar(&c.GPUs, "gpus", c.GPUs, "Number of GPUs of the node shared by the pods")
+
+	fs.Float64Var(&c.Monitor.Period, "MonitoringPeriod", c.Monitor.Period, "MonitoringPeriod in seconds")
+	fs.IntVar(&c.Monitor.Workers, "monitorWorkers", c.Monitor.Workers, "Pods read and limits written at once in a tick")
+	fs.Int64Var(&c.Monitor.WindowSize, "WindowSize", c.Monitor.WindowSize, "WindowSize")
+
+	fs.BoolVar(&c.Monitor.MonitoringMode, "MonitoringMode", c.Monitor.MonitoringMode, "MonitoringMode")
+	fs.BoolVar(&c.Exporter.Enabled, "exporterMode", c.Exporter.Enabled, "exporterMode")
+	fs.BoolVar(&c.BPFWatcher, "bpfwatcherMode", c.BPFWatcher, "bpfwatcherMode")
+
+	fs.Float64Var(&c.Policy.StaticV, "staticV", c.Policy.StaticV, "Static V Weight")
+	fs.StringVar(&c.Policy.Name, "policy", c.Policy.Name, "Scaling policy : kuscale or static")
+
+	fs.StringVar(&c.Trace.Dir, "traceDir", c.Trace.Dir, "Directory to record the usage/limit trace, disabled if empty")
+	fs.Int64Var(&c.Trace.MaxSizeMB, "traceMaxSize", c.Trace.MaxSizeMB, "Max size of a trace file in MB")
+	fs.IntVar(&c.Trace.MaxFiles, "traceMaxFiles", c.Trace.MaxFiles, "Number of trace files to keep")
+	fs.StringVar(&c.Audit.Dir, "auditDir", c.Audit.Dir, "Directory of the audit log of the limit decisions, disabled if empty")
+	fs.Int64Var(&c.Audit.MaxSizeMB, "auditMaxSize", c.Audit.MaxSizeMB, "Max size of an audit file in MB")
+	fs.IntVar(&c.Audit.MaxFiles, "auditMaxFiles", c.Audit.MaxFiles, "Number of audit files to keep")
+	fs.StringVar(&c.DumpDir, "dumpDir", c.DumpDir, "Directory of the state dumps written on SIGUSR1")
+	fs.StringVar(&c.LedgerDir, "ledgerDir", c.LedgerDir, "Directory of the token ledger, disabled if empty")
+	fs.Int64Var(&c.Shutdown.Timeout, "shutdownTimeout", c.Shutdown.Timeout, "Seconds to restore the limits and stop the device plugin on shutdown")
+	fs.BoolVar(&c.Shutdown.RestoreLimits, "restoreLimits", c.Shutdown.RestoreLimits, "Restore the limits set before KuScale on shutdown")
+	fs.Float64Var(&c.Shutdown.SafeLimits.CPU, "safeCPULimit", c.Shutdown.SafeLimits.CPU, "CPU limit restored when the one before KuScale is unknown, 0 for none")
+	fs.Float64Var(&c.Shutdown.SafeLimits.GPU, "safeGPULimit", c.Shutdown.SafeLimits.GPU, "GPU limit restored when the one before KuScale is unknown, 0 for none")
+	fs.BoolVar(&c.Watchdog.Enabled, "watchdog", c.Watchdog.Enabled, "Move the pods to fail-safe limits when the control loop stalls or their usage readings are stale")
+	fs.Int64Var(&c.Watchdog.Grace, "watchdogGrace", c.Watchdog.Grace, "Seconds a tick, a limit write or usage readings may be late before the watchdog steps in")
+	fs.Float64Var(&c.Watchdog.FailSafeLimits.CPU, "failSafeCPULimit", c.Watchdog.FailSafeLimits.CPU, "Fail-safe CPU limit when the one before KuScale is unknown, 0 for none")
+	fs.Float64Var(&c.Watchdog.FailSafeLimits.GPU, "failSafeGPULimit", c.Watchdog.FailSafeLimits.GPU, "Fail-safe GPU limit when the one before KuScale is unknown, 0 for none")
+	fs.BoolVar(&c.Reconcile.Enabled, "reconcile", c.Reconcile.Enabled, "Read back the limits in effect and check them against those of KuScale")
+	fs.Float64Var(&c.Reconcile.Interval, "reconcileInterval", c.Reconcile.Interval, "Seconds between two reconciliations of the limits")
+	fs.StringVar(&c.Reconcile.Action, "reconcileAction", c.Reconcile.Action, "What to do with a drifted limit : reapply or report")
+	fs.Float64Var(&c.Reconcile.Tolerance, "reconcileTolerance", c.Reconcile.Tolerance, "Percent the usage may go over the limit before the enforcement is broken")
+	fs.IntVar(&c.Reconcile.Breaches, "reconcileBreaches", c.Reconcile.Breaches, "Reconciliations in a row over the limit which flag the enforcement broken")
+
+	fs.StringVar(&c.HostRoot, "hostRoot", c.HostRoot, "Prefix of every host file path, for testing on a copy of the host files")
+	fs.StringVar(&c.Roots.Cgroup, "cgroupRoot", c.Roots.Cgroup, "Where the cgroup hierarchy of the host is mounted")
+	fs.StringVar(&c.Roots.GPU, "gpuRoot", c.Roots.GPU, "Where the sysfs of ku-gpu-layer is")
+	fs.StringVar(&c.Roots.Proc, "procRoot", c.Roots.Proc, "Where the procfs of the host is mounted")
+	fs.StringVar(&c.Runtime.LimitWriter, "limitWriter", c.Runtime.LimitWriter, "Writer of the CPU limits : cgroup, docker or cri, which fall back to cgroup")
+	fs.StringVar(&c.Runtime.CRISocket, "criSocket", c.Runtime.CRISocket, "Socket of the CRI runtime service, for the cri limit writer")
+
+	fs.StringVar(&c.Kubeconfig, "kubeconfig", c.Kubeconfig, "Kubeconfig of the Kubernetes API, the in-cluster config if empty")
+	fs.BoolVar(&c.PodSync.Enabled, "podSync", c.PodSync.Enabled, "Write the limits to the pods of the Kubernetes API")
+	fs.StringVar(&c.PodSync.Mode, "podSyncMode", c.PodSync.Mode, "auto resizes the CPU limits in place when the cluster can and annotates, annotations only annotates")
+	fs.Float64Var(&c.PodSync.Interval, "podSyncInterval", c.PodSync.Interval, "Seconds between two syncs of the pods")
+	fs.Float64Var(&c.PodSync.MinChange, "podSyncMinChange", c.PodSync.MinChange, "Percent a limit changes before it is synced again")
+	fs.Float64Var(&c.PodSync.QPS, "podSyncQPS", c.PodSync.QPS, "Pods synced a second")
+	fs.IntVar(&c.PodSync.Burst, "podSyncBurst", c.PodSync.Burst, "Pods synced at once above podSyncQPS")
+	fs.BoolVar(&c.PodInformer.Enabled, "podInformer", c.PodInformer.Enabled, "Watch the pods of the node in the Kubernetes API for their metadata, annotations and deletion")
+	fs.Float64Var(&c.PodInformer.Resync, "podInformerResync", c.PodInformer.Resync, "Seconds between two listings of the pods, 0 for never")
+
+	fs.StringVar(&c.Control.Socket, "controlSocket", c.Control.Socket, "Unix socket of the control API, disabled if empty")
+	fs.IntVar(&c.Control.HistorySize, "historySize", c.Control.HistorySize, "Number of ticks of every pod kept for the control API")
+	fs.BoolVar(&c.Exporter.API, "exporterAPI", c.Exporter.API, "Serve the control API read-only on the exporter port, for kubectl-kuscale")
+	fs.BoolVar(&c.Exporter.APIWrite, "exporterAPIWrite", c.Exporter.APIWrite, "Allow changes through the control API on the exporter port")
+
+	fs.StringVar(&c.GPUModule.Path, "gpuModule", c.GPUModule.Path, "ku-gpu-layer module to load")
+	fs.StringVar(&c.GPUModule.SHA256, "gpuModuleSHA256", c.GPUModule.SHA256, "Expected sha256 of the module, read from <gpuModule>.sha256 if empty")
+	fs.BoolVar(&c.GPUModule.Unload, "unloadGpuModule", c.GPUModule.Unload, "Unload the module on exit when no vGPU ID is in use")
+}
+
+// SetFlags sets c from the flags given on the command line of from, so they
+// take precedence over the file.
+func (c *Config) SetFlags(from *flag.FlagSet) error {
+	fs := flag.NewFlagSet("kuconfig", flag.ContinueOnError)
+	c.AddFlags(fs)
+	var err error
+	from.Visit(func(f *flag.Flag) {
+		if err == nil && fs.Lookup(f.Name) != nil {
+			err = fs.Set(f.Name, f.Value.String())
+		}
+	})
+	return err
+}
+
+/*
+Func Name : Load()
+Objective : 1) Read the file over the defaults, failing on unknown fields
+			2) Apply the flags given on the command line of flags, if not nil
+			3) Validate the result
+*/
+func Load(path string, flags *flag.FlagSet) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := Default()
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if flags != nil {
+		if err := c.SetFlags(flags); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// NewPolicy makes the scaling policy of the config.
+func (c *Config) NewPolicy() (kumonitor.Policy, error) {
+	return kumonitor.NewPolicy(c.Policy.Name, map[string]float64{"staticV": c.Policy.StaticV})
+}
+
+// Validate returns every invalid setting of c in one error.
+func (c *Config) Validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.NodeName != "", "nodeName is empty")
+	check(c.GPUs > 0, "gpus %d should be positive", c.GPUs)
+	check(c.Monitor.PeriodDuration() >= MinPeriod, "monitor.period %gs should be at least %s", c.Monitor.Period, MinPeriod)
+	check(c.Monitor.Workers > 0, "monitor.workers %d should be positive", c.Monitor.Workers)
+	check(c.Monitor.WindowSize > 0, "monitor.windowSize %d should be positive", c.Monitor.WindowSize)
+
+	if _, err := c.NewPolicy(); err != nil {
+		errs = append(errs, "policy.name: "+err.Error())
+	}
+	check(c.Policy.StaticV >= 0, "policy.staticV %g should not be negative", c.Policy.StaticV)
+	check(c.Prices.CPU > 0, "prices.cpu %g should be positive", c.Prices.CPU)
+	check(c.Prices.GPU > 0, "prices.gpu %g should be positive", c.Prices.GPU)
+
+	check(c.Control.HistorySize > 0, "control.historySize %d should be positive", c.Control.HistorySize)
+	if c.Trace.Dir != "" {
+		check(c.Trace.MaxSizeMB > 0, "trace.maxSizeMB %d should be positive", c.Trace.MaxSizeMB)
+		check(c.Trace.MaxFiles > 0, "trace.maxFiles %d should be positive", c.Trace.MaxFiles)
+	}
+	if c.Audit.Dir != "" {
+		check(c.Audit.MaxSizeMB > 0, "audit.maxSizeMB %d should be positive", c.Audit.MaxSizeMB)
+		check(c.Audit.MaxFiles > 0, "audit.maxFiles %d should be positive", c.Audit.MaxFiles)
+	}
+
+	check(c.DumpDir != "", "dumpDir is empty")
+	check(c.Shutdown.Timeout > 0, "shutdown.timeout %d should be positive", c.Shutdown.Timeout)
+	check(c.Shutdown.SafeLimits.CPU >= 0, "shutdown.safeLimits.cpu %g should not be negative", c.Shutdown.SafeLimits.CPU)
+	check(c.Shutdown.SafeLimits.GPU >= 0, "shutdown.safeLimits.gpu %g should not be negative", c.Shutdown.SafeLimits.GPU)
+	if c.Watchdog.Enabled {
+		check(c.Watchdog.Grace > 0, "watchdog.grace %d should be positive", c.Watchdog.Grace)
+		check(c.Watchdog.FailSafeLimits.CPU >= 0, "watchdog.failSafeLimits.cpu %g should not be negative", c.Watchdog.FailSafeLimits.CPU)
+		check(c.Watchdog.FailSafeLimits.GPU >= 0, "watchdog.failSafeLimits.gpu %g should not be negative", c.Watchdog.FailSafeLimits.GPU)
+	}
+	if c.Reconcile.Enabled {
+		check(c.Reconcile.Interval > 0, "reconcile.interval %g should be positive", c.Reconcile.Interval)
+		check(c.Reconcile.Action == kumonitor.ReconcileReapply || c.Reconcile.Action == kumonitor.ReconcileReport,
+			"reconcile.action %q should be %s or %s", c.Reconcile.Action, kumonitor.ReconcileReapply, kumonitor.ReconcileReport)
+		check(c.Reconcile.Tolerance >= 0, "reconcile.tolerance %g should not be negative", c.Reconcile.Tolerance)
+		check(c.Reconcile.Breaches > 0, "reconcile.breaches %d should be positive", c.Reconcile.Breaches)
+	}
+
+	check(c.Roots.Cgroup != "", "roots.cgroup is empty")
+	check(c.Roots.GPU != "", "roots.gpu is empty")
+	check(c.Roots.Proc != "", "roots.proc is empty")
+	if c.PodSync.Enabled {
+		check(c.PodSync.Mode == kusync.ModeAuto || c.PodSync.Mode == kusync.ModeAnnotations,
+			"podSync.mode %q should be %s or %s", c.PodSync.Mode, kusync.ModeAuto, kusync.ModeAnnotations)
+		check(c.PodSync.Interval > 0, "podSync.interval %g should be positive", c.PodSync.Interval)
+		check(c.PodSync.MinChange >= 0, "podSync.minChange %g should not be negative", c.PodSync.MinChange)
+		check(c.PodSync.QPS > 0, "podSync.qps %g should be positive", c.PodSync.QPS)
+		check(c.PodSync.Burst > 0, "podSync.burst %d should be positive", c.PodSync.Burst)
+	}
+	check(c.PodInformer.Resync >= 0, "podInformer.resync %g should not be negative", c.PodInformer.Resync)
+	switch c.Runtime.LimitWriter {
+	case kuruntime.WriterCgroup, kuruntime.WriterDocker:
+	case kuruntime.WriterCRI:
+		check(c.Runtime.CRISocket != "", "runtime.criSocket is empty")
+	default:
+		check(false, "runtime.limitWriter %q should be %s, %s or %s", c.Runtime.LimitWriter,
+			kuruntime.WriterCgroup, kuruntime.WriterDocker, kuruntime.WriterCRI)
+	}
+	check(c.GPUModule.Path != "", "gpuModule.path is empty")
+	if c.GPUModule.SHA256 != "" {
+		_, err := hex.DecodeString(c.GPUModule.SHA256)
+		check(err == nil && len(c.GPUModule.SHA256) == 64, "gpuModule.sha256 %q is not a sha256", c.GPUModule.SHA256)
+	}
+
+	check(strings.Count(c.Token.ResourceName, "/") == 1, "token.resourceName %q should be <domain>/<name>", c.Token.ResourceName)
+	check(c.Token.Size > 0, "token.size %d should be positive", c.Token.Size)
+	check(c.Token.Socket != "" && !strings.Contains(c.Token.Socket, "/"),
+		"token.socket %q should be a file name in the device plugin directory", c.Token.Socket)
+
+	check(filepath.IsAbs(c.Gemini.Dir), "gemini.dir %q should be absolute", c.Gemini.Dir)
+	check(inDir(c.Gemini.Dir, c.Gemini.Hook), "gemini.hook %q is not in gemini.dir", c.Gemini.Hook)
+	check(inDir(c.Gemini.Dir, c.Gemini.IPCDir), "gemini.ipcDir %q is not in gemini.dir", c.Gemini.IPCDir)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config : %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func inDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && filepath.IsAbs(path) && rel != ".." && !strings.HasPrefix(rel, "../")
+}
@@ -0,0 +1,198 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kuconfig
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes data to a config file of the test.
+func writeConfig(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// parseFlags parses args as the command line of KuScale.
+func parseFlags(t *testing.T, args ...string) *flag.FlagSet {
+	t.Helper()
+	fs := flag.NewFlagSet("kuscale", flag.ContinueOnError)
+	Default().AddFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+func TestDefaultIsValidAndOptIn(t *testing.T) {
+	c := Default()
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Control.Socket != "" || c.LedgerDir != "" || c.Watchdog.Enabled || c.Reconcile.Enabled {
+		t.Errorf("control socket %q, ledger %q, watchdog %v and reconcile %v are on by default",
+			c.Control.Socket, c.LedgerDir, c.Watchdog.Enabled, c.Reconcile.Enabled)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	file := `
+nodeName: node1
+monitor:
+  period: 0.5
+  monitoringMode: false
+policy:
+  staticV: 5
+ledgerDir: /var/lib/kuscale/ledger
+`
+	tests := []struct {
+		name  string
+		file  string
+		args  []string
+		check func(c *Config) bool
+		err   string
+	}{
+		{name: "file over defaults", file: file,
+			check: func(c *Config) bool {
+				return c.NodeName == "node1" && c.Monitor.Period == 0.5 && !c.Monitor.MonitoringMode &&
+					c.Policy.StaticV == 5 && c.LedgerDir == "/var/lib/kuscale/ledger" && c.Monitor.WindowSize == 15
+			}},
+		{name: "flags over file", file: file, args: []string{"-NodeName", "node2", "-staticV=7", "-ledgerDir="},
+			check: func(c *Config) bool {
+				return c.NodeName == "node2" && c.Policy.StaticV == 7 && c.LedgerDir == "" && c.Monitor.Period == 0.5
+			}},
+		{name: "flags left to their default don't override the file", file: file, args: []string{"-gpus", "2"},
+			check: func(c *Config) bool { return c.GPUs == 2 && c.NodeName == "node1" && c.Policy.StaticV == 5 }},
+		{name: "unknown field", file: "monitor:\n  perod: 1\n", err: "perod"},
+		{name: "invalid file", file: "monitor:\n  period: 0.01\n", err: "monitor.period"},
+		{name: "invalid flag", file: file, args: []string{"-policy", "greedy"}, err: "policy.name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var flags *flag.FlagSet
+			if tt.args != nil {
+				flags = parseFlags(t, tt.args...)
+			}
+			c, err := Load(writeConfig(t, tt.file), flags)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Load = %v, want an error about %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(c) {
+				t.Errorf("Load = %+v", c)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		errs   []string
+	}{
+		{name: "default", change: func(c *Config) {}},
+		{name: "sub-second period", change: func(c *Config) { c.Monitor.Period = 0.1 }},
+		{name: "too short period", change: func(c *Config) { c.Monitor.Period = 0.05 }, errs: []string{"monitor.period"}},
+		{name: "every error at once",
+			change: func(c *Config) { c.NodeName, c.GPUs, c.Prices.GPU = "", 0, -1 },
+			errs:   []string{"nodeName", "gpus", "prices.gpu"}},
+		{name: "watchdog without grace", change: func(c *Config) { c.Watchdog.Enabled, c.Watchdog.Grace = true, 0 },
+			errs: []string{"watchdog.grace"}},
+		{name: "grace of a disabled watchdog", change: func(c *Config) { c.Watchdog.Grace = 0 }},
+		{name: "reconcile action", change: func(c *Config) { c.Reconcile.Enabled, c.Reconcile.Action = true, "fix" },
+			errs: []string{"reconcile.action"}},
+		{name: "trace without files", change: func(c *Config) { c.Trace.Dir, c.Trace.MaxFiles = "/tmp", 0 },
+			errs: []string{"trace.maxFiles"}},
+		{name: "cri without socket", change: func(c *Config) { c.Runtime.LimitWriter, c.Runtime.CRISocket = "cri", "" },
+			errs: []string{"runtime.criSocket"}},
+		{name: "module checksum", change: func(c *Config) { c.GPUModule.SHA256 = "abc" }, errs: []string{"gpuModule.sha256"}},
+		{name: "token socket path", change: func(c *Config) { c.Token.Socket = "/tmp/token.sock" }, errs: []string{"token.socket"}},
+		{name: "hook out of gemini.dir", change: func(c *Config) { c.Gemini.Hook = "/usr/lib/libgemhook.so.1" },
+			errs: []string{"gemini.hook"}},
+		{name: "pod sync mode", change: func(c *Config) { c.PodSync.Enabled, c.PodSync.Mode = true, "patch" },
+			errs: []string{"podSync.mode"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			tt.change(c)
+			err := c.Validate()
+			if len(tt.errs) == 0 {
+				if err != nil {
+					t.Errorf("Validate = %v, want it valid", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate = nil, want errors about %v", tt.errs)
+			}
+			for _, want := range tt.errs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Validate = %v, want an error about %s", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	tests := []struct {
+		name              string
+		change            func(c *Config)
+		reloaded, restart []string
+	}{
+		{name: "nothing", change: func(c *Config) {}},
+		{name: "policy and prices",
+			change:   func(c *Config) { c.Policy.Name, c.Policy.StaticV, c.Prices.GPU = "static", 3, 5 },
+			reloaded: []string{"policy.name", "policy.staticV", "prices.gpu"}},
+		{name: "restart only",
+			change:  func(c *Config) { c.Monitor.Period, c.Roots.GPU, c.LedgerDir = 1, "/gpu", "/ledger" },
+			restart: []string{"monitor.period", "ledgerDir", "roots.gpu"}},
+		{name: "both",
+			change:   func(c *Config) { c.Prices.CPU, c.Watchdog.Enabled = 2, true },
+			reloaded: []string{"prices.cpu"}, restart: []string{"watchdog.enabled"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			running, next := Default(), Default()
+			tt.change(next)
+			reloaded, restart := running.Reload(next)
+			if !reflect.DeepEqual(reloaded, tt.reloaded) || !reflect.DeepEqual(restart, tt.restart) {
+				t.Errorf("Reload = %v, %v, want %v, %v", reloaded, restart, tt.reloaded, tt.restart)
+			}
+			if running.Policy != next.Policy || running.Prices != next.Prices {
+				t.Errorf("policy %+v and prices %+v weren't reloaded", running.Policy, running.Prices)
+			}
+			if want := Default(); running.Monitor != want.Monitor || running.LedgerDir != want.LedgerDir ||
+				running.Watchdog != want.Watchdog || running.Roots != want.Roots {
+				t.Error("settings which need a restart were reloaded")
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package kuconfig
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Diff returns the settings which differ between a and b, as "section.field".
+func Diff(a, b *Config) []string {
+	return diff("", reflect.ValueOf(*a), reflect.ValueOf(*b))
+}
+
+func diff(prefix string, a, b reflect.Value) []string {
+	if a.Kind() != reflect.Struct {
+		if reflect.DeepEqual(a.Interface(), b.Interface()) {
+			return nil
+		}
+		return []string{prefix}
+	}
+	var changed []string
+	for i := 0; i < a.NumField(); i++ {
+		changed = append(changed, diff(prefix+fieldName(a.Type().Field(i)), a.Field(i), b.Field(i))...)
+	}
+	return changed
+}
+
+// fieldName is the yaml key of the field, followed by "." for a section.
+func fieldName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+	if name == "" {
+		name = strings.ToLower(f.Name)
+	}
+	if f.Type.Kind() == reflect.Struct {
+		name += "."
+	}
+	return name
+}
+
+// IsReloadable reports whether the setting is applied without a restart.
+func IsReloadable(setting string) bool {
+	for _, section := range Reloadable {
+		if strings.HasPrefix(setting, section+".") {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Func Name : (c *Config) Reload(next *Config)
+Objective : 1) Copy the reloadable sections of next into c
+			2) Return the reloaded settings, and the changed ones which need a restart
+*/
+func (c *Config) Reload(next *Config) (reloaded, restart []string) {
+	for _, setting := range Diff(c, next) {
+		if IsReloadable(setting) {
+			reloaded = append(reloaded, setting)
+		} else {
+			restart = append(restart, setting)
+		}
+	}
+	c.Policy = next.Policy
+	c.Prices = next.Prices
+	return reloaded, restart
+}
@@ -19,135 +19,167 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sslab-konkuk/KuScale/pkg/kuhealth"
 	kumonitor "github.com/sslab-konkuk/KuScale/pkg/kumonitor"
 	"k8s.io/klog"
 )
 
+// Labels of every pod metric. The node is a constant label.
+var podLabels = []string{"namespace", "pod", "container"}
+var resourceLabels = []string{"namespace", "pod", "container", "resource"}
+
+// Exporter exposes the state of the monitor at every scrape, as const metrics
+// read with the monitor locked. While the watchdog is engaged, the control
+// loop may hold the lock, so only what doesn't need it is exposed.
 type Exporter struct {
-	Limit            *prometheus.CounterVec
-	Usage            *prometheus.CounterVec
-	AvgUsage         *prometheus.CounterVec
-	DynamicWeight    *prometheus.CounterVec
-	UpdatedCount     *prometheus.GaugeVec
-	TokenReservation *prometheus.GaugeVec
-	TokenQueue       *prometheus.GaugeVec
+	monitor *kumonitor.Monitor
+	node    Node
+	nodeMetrics
+
+	limit         *prometheus.Desc
+	usage         *prometheus.Desc
+	avgUsage      *prometheus.Desc
+	dynamicWeight *prometheus.Desc
+	usageSeconds  *prometheus.Desc
+	enforcedLimit *prometheus.Desc
+	limitDrifted  *prometheus.Desc
+	broken        *prometheus.Desc
+
+	tokenReservation *prometheus.Desc
+	tokenQueue       *prometheus.Desc
+	availableTokens  *prometheus.Desc
+	tokensConsumed   *prometheus.Desc
+	limitUpdates     *prometheus.Desc
+	failSafe         *prometheus.Desc
 }
 
-type ExporterCollector struct {
-	exporter         *Exporter
-	connectedMonitor *kumonitor.Monitor
-	nodeName         string
-}
+func NewExporter(reg prometheus.Registerer, m *kumonitor.Monitor, node Node) *Exporter {
+	constLabels := prometheus.Labels{"node": node.Name}
+	desc := func(name, help string, labels []string) *prometheus.Desc {
+		return prometheus.NewDesc("kuscale_"+name, help, labels, constLabels)
+	}
+	e := &Exporter{
+		monitor:     m,
+		node:        node,
+		nodeMetrics: newNodeMetrics(constLabels, desc),
+
+		limit:         desc("resource_limit_percent", "Limit of the resource, 100 is one CPU core or one GPU.", resourceLabels),
+		usage:         desc("resource_usage_percent", "Usage of the resource in the last tick, 100 is one CPU core or one GPU.", resourceLabels),
+		avgUsage:      desc("resource_avg_usage_percent", "Exponential moving average of the usage of the resource.", resourceLabels),
+		dynamicWeight: desc("resource_dynamic_weight", "Weight of the resource in the token split of the policy.", resourceLabels),
+		usageSeconds:  desc("resource_usage_seconds_total", "CPU time of the cgroup or GPU runtime of the vGPU ID used by the container.", resourceLabels),
+		enforcedLimit: desc("resource_enforced_limit_percent", "Limit in effect read back at the last reconciliation.", resourceLabels),
+		limitDrifted:  desc("resource_limit_drifted", "1 while the limit in effect isn't the one KuScale wrote.", resourceLabels),
+		broken:        desc("resource_enforcement_broken", "1 while the usage stays over the limit.", resourceLabels),
+
+		tokenReservation: desc("pod_token_reservation", "Tokens per second reserved by the pod.", podLabels),
+		tokenQueue:       desc("pod_token_queue", "Tokens saved in the queue of the pod.", podLabels),
+		availableTokens:  desc("pod_available_tokens", "Tokens available to the pod in the last tick.", podLabels),
+		tokensConsumed:   desc("pod_tokens_consumed_total", "Tokens consumed by the limits of the pod.", podLabels),
+		limitUpdates:     desc("pod_limit_updates_total", "Times the limits of the pod were set.", podLabels),
+		failSafe:         desc("pod_failsafe", "1 while the pod is on fail-safe limits for stale usage readings.", podLabels),
+	}
 
-func (ec ExporterCollector) Describe(ch chan<- *prometheus.Desc) {
-	prometheus.DescribeByCollect(ec, ch)
+	reg.MustRegister(e)
+	m.AddObserver(e)
+	return e
 }
 
-func (ec ExporterCollector) collect() error {
-	for _, pod := range ec.connectedMonitor.RunningPodMap {
-		name := pod.PodName
-		id := pod.PodName
-		node := ec.nodeName
-
-		for rn, ri := range pod.RIs {
-			resourceName := string(rn)
-			ec.exporter.Limit.WithLabelValues([]string{resourceName, id, node}...).Add(ri.Limit())
-			ec.exporter.Usage.WithLabelValues([]string{resourceName, id, node}...).Add(ri.Usage())
-			ec.exporter.AvgUsage.WithLabelValues([]string{resourceName, id, node}...).Add(ri.AvgUsage())
-			ec.exporter.DynamicWeight.WithLabelValues([]string{resourceName, id, node}...).Add(ri.DynamicWeight())
-		}
+// Describe sends every descriptor, as there are no pods to collect yet when
+// the exporter is registered.
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		e.limit, e.usage, e.avgUsage, e.dynamicWeight, e.usageSeconds, e.enforcedLimit, e.limitDrifted, e.broken,
+		e.tokenReservation, e.tokenQueue, e.availableTokens, e.tokensConsumed, e.limitUpdates, e.failSafe,
+		e.tokenCapacity, e.tokensAlloc, e.tokensFree, e.limitSum, e.capacity, e.pods, e.vgpuIDs, e.sinceLastTick,
+		e.wdEngaged, e.wdPods, e.wdTransitions, e.rcEvents, e.podSyncs,
+	} {
+		ch <- desc
+	}
+	e.tickDuration.Describe(ch)
+	e.tickOverruns.Describe(ch)
+}
 
-		ec.exporter.UpdatedCount.WithLabelValues([]string{name, id, node}...).Add(float64(pod.UpdatedCount))
-		ec.exporter.TokenReservation.WithLabelValues([]string{name, id, node}...).Add(pod.TokenReservation)
-		ec.exporter.TokenQueue.WithLabelValues([]string{name, id, node}...).Add(pod.TokenQueue)
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	if e.node.Watchdog == nil || !e.node.Watchdog.Engaged() {
+		e.monitor.Do(func() {
+			for _, pi := range e.monitor.RunningPodMap {
+				e.collectPod(ch, pi)
+			}
+			e.collectNode(ch)
+		})
 	}
-	return nil
+	e.collectWatchdog(ch)
+	e.collectReconciler(ch)
+	e.collectPodSync(ch)
+	e.collectGPU(ch)
+	e.tickDuration.Collect(ch)
+	e.tickOverruns.Collect(ch)
 }
 
-func (ec ExporterCollector) Collect(ch chan<- prometheus.Metric) {
-	ec.exporter.Limit.Reset()
-	ec.exporter.Usage.Reset()
-	ec.exporter.AvgUsage.Reset()
-	ec.exporter.DynamicWeight.Reset()
-	ec.exporter.UpdatedCount.Reset()
-	ec.exporter.TokenReservation.Reset()
-	ec.exporter.TokenQueue.Reset()
-
-	if err := ec.collect(); err != nil {
-		klog.Infof("Error reading container stats: %s", err)
+func (e *Exporter) collectPod(ch chan<- prometheus.Metric, pi *kumonitor.PodInfo) {
+	labels := []string{pi.Namespace, pi.PodName, pi.Container}
+	gauge := func(desc *prometheus.Desc, value float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labels...)
+	}
+	counter := func(desc *prometheus.Desc, value float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, value, labels...)
+	}
+
+	for rn, ri := range pi.RIs {
+		resource := append(labels[:len(labels):len(labels)], string(rn))
+		gauge(e.limit, ri.Limit(), resource...)
+		gauge(e.usage, ri.Usage(), resource...)
+		gauge(e.avgUsage, ri.AvgUsage(), resource...)
+		gauge(e.dynamicWeight, ri.DynamicWeight(), resource...)
+		counter(e.usageSeconds, float64(ri.AcctUsage())/1e9, resource...) // both in ns
+		if e.node.Reconciler != nil {
+			enforced, drifted, broken := ri.Enforced()
+			gauge(e.enforcedLimit, enforced, resource...)
+			gauge(e.limitDrifted, flag(drifted), resource...)
+			gauge(e.broken, flag(broken), resource...)
+		}
 	}
 
-	ec.exporter.Limit.Collect(ch)
-	ec.exporter.Usage.Collect(ch)
-	ec.exporter.AvgUsage.Collect(ch)
-	ec.exporter.DynamicWeight.Collect(ch)
-	ec.exporter.UpdatedCount.Collect(ch)
-	ec.exporter.TokenReservation.Collect(ch)
-	ec.exporter.TokenQueue.Collect(ch)
+	gauge(e.tokenReservation, pi.TokenReservation, labels...)
+	gauge(e.tokenQueue, pi.TokenQueue, labels...)
+	gauge(e.availableTokens, pi.AvailableToken(), labels...)
+	counter(e.tokensConsumed, pi.ConsumedToken(), labels...)
+	counter(e.limitUpdates, float64(pi.UpdatedCount), labels...)
+	gauge(e.failSafe, flag(pi.FailSafe()), labels...)
 }
 
-func NewExporter(reg prometheus.Registerer, m *kumonitor.Monitor, nodeName string) *Exporter {
-	dm := &Exporter{
-		Limit: prometheus.NewCounterVec(prometheus.CounterOpts{
-			Name: "Limit",
-			Help: "Resource Limit",
-		}, []string{"name", "id", "node"},
-		),
-		Usage: prometheus.NewCounterVec(prometheus.CounterOpts{
-			Name: "Usage",
-			Help: "Resource Usage",
-		},
-			[]string{"name", "id", "node"},
-		),
-		AvgUsage: prometheus.NewCounterVec(prometheus.CounterOpts{
-			Name: "AvgUsage",
-			Help: "Resource AvgUsage",
-		},
-			[]string{"name", "id", "node"},
-		),
-		DynamicWeight: prometheus.NewCounterVec(prometheus.CounterOpts{
-			Name: "DynamicWeight",
-			Help: "Resource DynamicWeight",
-		},
-			[]string{"name", "id", "node"},
-		),
-		UpdatedCount: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "UpdatedCount",
-			Help: "UpdatedCount",
-		},
-			[]string{"name", "id", "node"},
-		),
-		TokenReservation: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "TokenReservation",
-			Help: "TokenReservation",
-		},
-			[]string{"name", "id", "node"},
-		),
-		TokenQueue: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "TokenQueue",
-			Help: "TokenQueue",
-		},
-			[]string{"name", "id", "node"},
-		),
+// flag is a boolean gauge.
+func flag(b bool) float64 {
+	if b {
+		return 1
 	}
-	ec := ExporterCollector{exporter: dm, connectedMonitor: m, nodeName: nodeName}
-
-	reg.MustRegister(ec)
-	return dm
+	return 0
 }
 
-func ExporterRun(m *kumonitor.Monitor, nodeName string, stopCh chan string) {
+// ExporterRun serves the metrics, the health checks, and api under /v1/ if
+// it is not nil.
+func ExporterRun(m *kumonitor.Monitor, node Node, stopCh chan string, api http.Handler) {
 
 	klog.V(4).Info("Starting Exporter")
 
 	reg := prometheus.NewPedanticRegistry()
-	NewExporter(reg, m, nodeName)
+	NewExporter(reg, m, node)
 	reg.MustRegister(
 		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
 		collectors.NewGoCollector(),
 	)
 	http.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
-	go http.ListenAndServe(":9091", nil)
+	http.Handle("/healthz", kuhealth.Handler(true))
+	http.Handle("/readyz", kuhealth.Handler(false))
+	if api != nil {
+		http.Handle("/v1/", api)
+	}
+	go func() {
+		if err := http.ListenAndServe(":9091", nil); err != nil {
+			klog.Error("Exporter stopped serving : ", err)
+		}
+	}()
 
 	klog.V(4).Info("Started Exporter")
 	<-stopCh
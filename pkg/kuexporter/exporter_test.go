@@ -0,0 +1,142 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kumexporter
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sslab-konkuk/KuScale/pkg/kuclock"
+	"github.com/sslab-konkuk/KuScale/pkg/kufs"
+	kumonitor "github.com/sslab-konkuk/KuScale/pkg/kumonitor"
+	"github.com/sslab-konkuk/KuScale/pkg/kuprofiler"
+)
+
+var testRoots = kufs.Roots{Cgroup: "/cgroup", GPU: "/gpu", Proc: "/proc"}
+
+// newTestMonitor runs a tick of a monitor of a MemFS with a pod using half
+// of a core and of a GPU.
+func newTestMonitor(t *testing.T) (*kumonitor.Monitor, *kumonitor.PodInfo) {
+	t.Helper()
+	kuprofiler.NewLatencyInfo(false)
+	fs := kufs.NewMemFS()
+	for name, data := range map[string]string{
+		"configs/init": "", "configs/destroy": "", "configs/totalIDs": "0", "gemini/resource_conf": "",
+	} {
+		fs.SetFile(kufs.Join(testRoots.GPU, name), []byte(data))
+	}
+	cpuPath := kufs.Join(testRoots.Cgroup, "cpu/kubepods/pod0")
+	gpuPath := kufs.Join(testRoots.GPU, "IDs/0")
+	fs.SetFile(cpuPath+"/cpu.stat", []byte("usage_usec 0\n"))
+	fs.SetFile(cpuPath+"/cpu.cfs_quota_us", []byte("-1"))
+	for _, name := range []string{"total_runtime", "gpu_limit", "gpu_request"} {
+		fs.SetFile(kufs.Join(gpuPath, name), []byte("0"))
+	}
+
+	clock := kuclock.NewFake(int64(1e18))
+	m := kumonitor.NewMonitorWithHost(time.Second, 5, "node", false, 0, kumonitor.NewFSHostWithClock(fs, testRoots, clock))
+	pi := kumonitor.NewPodInfoWithPaths("pod0", cpuPath, gpuPath)
+	pi.Namespace, pi.Container, pi.TokenReservation = "default", "main", 300
+	m.AddPod(pi)
+
+	fs.SetFile(cpuPath+"/cpu.stat", []byte("usage_usec 500000\n"))
+	fs.SetFile(kufs.Join(gpuPath, "total_runtime"), []byte("500000000"))
+	clock.Advance(time.Second)
+	m.MonitorAndAutoScale()
+	return m, pi
+}
+
+func TestExporterCollectsPodAndNodeMetrics(t *testing.T) {
+	m, pi := newTestMonitor(t)
+	reg := prometheus.NewPedanticRegistry()
+	e := NewExporter(reg, m, Node{
+		Name:     "node",
+		Tokens:   1000,
+		Capacity: map[kumonitor.ResourceName]float64{"CPU": 400, "GPU": 100},
+	})
+
+	cpu, gpu := pi.RIs["CPU"], pi.RIs["GPU"]
+	if cpu.Limit() == 10 || gpu.Limit() == 10 {
+		t.Fatalf("limits = %v and %v, want those scaled by the tick", cpu.Limit(), gpu.Limit())
+	}
+	g := func(v float64) string { return fmt.Sprint(v) }
+	expected := `
+# HELP kuscale_resource_limit_percent Limit of the resource, 100 is one CPU core or one GPU.
+# TYPE kuscale_resource_limit_percent gauge
+kuscale_resource_limit_percent{container="main",namespace="default",node="node",pod="pod0",resource="CPU"} ` + g(cpu.Limit()) + `
+kuscale_resource_limit_percent{container="main",namespace="default",node="node",pod="pod0",resource="GPU"} ` + g(gpu.Limit()) + `
+# HELP kuscale_resource_usage_percent Usage of the resource in the last tick, 100 is one CPU core or one GPU.
+# TYPE kuscale_resource_usage_percent gauge
+kuscale_resource_usage_percent{container="main",namespace="default",node="node",pod="pod0",resource="CPU"} 50
+kuscale_resource_usage_percent{container="main",namespace="default",node="node",pod="pod0",resource="GPU"} 50
+# HELP kuscale_resource_usage_seconds_total CPU time of the cgroup or GPU runtime of the vGPU ID used by the container.
+# TYPE kuscale_resource_usage_seconds_total counter
+kuscale_resource_usage_seconds_total{container="main",namespace="default",node="node",pod="pod0",resource="CPU"} 0.5
+kuscale_resource_usage_seconds_total{container="main",namespace="default",node="node",pod="pod0",resource="GPU"} 0.5
+# HELP kuscale_pod_token_reservation Tokens per second reserved by the pod.
+# TYPE kuscale_pod_token_reservation gauge
+kuscale_pod_token_reservation{container="main",namespace="default",node="node",pod="pod0"} 300
+# HELP kuscale_pod_limit_updates_total Times the limits of the pod were set.
+# TYPE kuscale_pod_limit_updates_total counter
+kuscale_pod_limit_updates_total{container="main",namespace="default",node="node",pod="pod0"} ` + g(float64(pi.UpdatedCount)) + `
+# HELP kuscale_node_tokens_allocated Tokens reserved by the running pods.
+# TYPE kuscale_node_tokens_allocated gauge
+kuscale_node_tokens_allocated{node="node"} 300
+# HELP kuscale_node_tokens_free Token devices not reserved by the running pods.
+# TYPE kuscale_node_tokens_free gauge
+kuscale_node_tokens_free{node="node"} 700
+# HELP kuscale_node_resource_limit_percent Sum of the limits of the running pods.
+# TYPE kuscale_node_resource_limit_percent gauge
+kuscale_node_resource_limit_percent{node="node",resource="CPU"} ` + g(cpu.Limit()) + `
+kuscale_node_resource_limit_percent{node="node",resource="GPU"} ` + g(gpu.Limit()) + `
+# HELP kuscale_node_pods Pods managed by KuScale by status.
+# TYPE kuscale_node_pods gauge
+kuscale_node_pods{node="node",status="completed"} 0
+kuscale_node_pods{node="node",status="initializing"} 0
+kuscale_node_pods{node="node",status="not ready"} 0
+kuscale_node_pods{node="node",status="running"} 1
+# HELP kuscale_monitor_seconds_since_last_tick Time since the control loop last ticked.
+# TYPE kuscale_monitor_seconds_since_last_tick gauge
+kuscale_monitor_seconds_since_last_tick{node="node"} 0
+`
+	if err := testutil.CollectAndCompare(e, strings.NewReader(expected),
+		"kuscale_resource_limit_percent", "kuscale_resource_usage_percent", "kuscale_resource_usage_seconds_total",
+		"kuscale_pod_token_reservation", "kuscale_pod_limit_updates_total",
+		"kuscale_node_tokens_allocated", "kuscale_node_tokens_free", "kuscale_node_resource_limit_percent",
+		"kuscale_node_pods", "kuscale_monitor_seconds_since_last_tick"); err != nil {
+		t.Error(err)
+	}
+
+	// The metrics without a watchdog nor a reconciler aren't exposed
+	for _, name := range []string{"kuscale_watchdog_engaged", "kuscale_resource_enforced_limit_percent"} {
+		if n := testutil.CollectAndCount(e, name); n != 0 {
+			t.Errorf("%d %s metrics, want none", n, name)
+		}
+	}
+	problems, err := testutil.CollectAndLint(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range problems {
+		t.Errorf("%s : %s", p.Metric, p.Text)
+	}
+	if _, err := reg.Gather(); err != nil {
+		t.Error(err)
+	}
+}
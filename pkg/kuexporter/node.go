@@ -0,0 +1,186 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package kumexporter
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sslab-konkuk/KuScale/pkg/kugpu"
+	kumonitor "github.com/sslab-konkuk/KuScale/pkg/kumonitor"
+	"github.com/sslab-konkuk/KuScale/pkg/kusync"
+	"k8s.io/klog"
+)
+
+// Node is what the exporter knows about the node besides the monitor.
+type Node struct {
+	Name       string
+	Tokens     int                                // token devices advertised by the device plugin
+	Capacity   map[kumonitor.ResourceName]float64 // in percent, like the limits
+	GPU        *kugpu.Module                      // counts the live vGPU IDs, if not nil
+	Watchdog   *kumonitor.Watchdog                // of the monitor, if not nil
+	Reconciler *kumonitor.Reconciler              // of the monitor, if not nil
+	PodSync    *kusync.Syncer                     // of the limits into the pods, if not nil
+}
+
+type nodeMetrics struct {
+	tokenCapacity *prometheus.Desc
+	tokensAlloc   *prometheus.Desc
+	tokensFree    *prometheus.Desc
+	limitSum      *prometheus.Desc
+	capacity      *prometheus.Desc
+	pods          *prometheus.Desc
+	vgpuIDs       *prometheus.Desc
+	sinceLastTick *prometheus.Desc
+	wdEngaged     *prometheus.Desc
+	wdPods        *prometheus.Desc
+	wdTransitions *prometheus.Desc
+	rcEvents      *prometheus.Desc
+	podSyncs      *prometheus.Desc
+	tickDuration  prometheus.Histogram
+	tickOverruns  prometheus.Counter
+}
+
+func newNodeMetrics(constLabels prometheus.Labels, desc func(name, help string, labels []string) *prometheus.Desc) nodeMetrics {
+	return nodeMetrics{
+		tokenCapacity: desc("node_token_capacity", "Token devices advertised by the device plugin.", nil),
+		tokensAlloc:   desc("node_tokens_allocated", "Tokens reserved by the running pods.", nil),
+		tokensFree:    desc("node_tokens_free", "Token devices not reserved by the running pods.", nil),
+		limitSum:      desc("node_resource_limit_percent", "Sum of the limits of the running pods.", []string{"resource"}),
+		capacity:      desc("node_resource_capacity_percent", "Capacity of the node, 100 is one CPU core or one GPU.", []string{"resource"}),
+		pods:          desc("node_pods", "Pods managed by KuScale by status.", []string{"status"}),
+		vgpuIDs:       desc("node_vgpu_ids", "Live vGPU IDs of the ku-gpu-layer module.", nil),
+		sinceLastTick: desc("monitor_seconds_since_last_tick", "Time since the control loop last ticked.", nil),
+		wdEngaged:     desc("watchdog_engaged", "1 while the control loop is stalled and every pod is on fail-safe limits.", nil),
+		wdPods:        desc("watchdog_failsafe_pods", "Pods on fail-safe limits for stale usage readings.", nil),
+		wdTransitions: desc("watchdog_transitions_total", "Transitions of the watchdog.", []string{"transition"}),
+		rcEvents:      desc("reconcile_events_total", "Drifted limits, reapplied limits, broken enforcements and read errors of the reconciler.", []string{"event"}),
+		podSyncs:      desc("pod_syncs_total", "Syncs of the limits into the pods of the Kubernetes API by result.", []string{"result"}),
+		tickDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:        "kuscale_monitor_tick_duration_seconds",
+			Help:        "Time to monitor and scale the running pods in a tick.",
+			ConstLabels: constLabels,
+			Buckets:     prometheus.ExponentialBuckets(0.0005, 4, 9), // 0.5ms to 33s
+		}),
+		tickOverruns: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "kuscale_monitor_tick_overruns_total",
+			Help:        "Ticks which took longer than the monitoring period.",
+			ConstLabels: constLabels,
+		}),
+	}
+}
+
+// collectNode sends the aggregates of the pods. Call it in Do.
+func (e *Exporter) collectNode(ch chan<- prometheus.Metric) {
+	gauge := func(desc *prometheus.Desc, value float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labels...)
+	}
+	m := e.monitor
+
+	allocated := 0.
+	limits := make(map[kumonitor.ResourceName]float64)
+	pods := map[kumonitor.PodStatus]float64{
+		kumonitor.PodInitializing: 0,
+		kumonitor.PodNotReady:     0,
+		kumonitor.PodRunning:      0,
+		kumonitor.PodCompleted:    float64(len(m.CompletedPodMap)),
+	}
+	for _, pi := range m.RunningPodMap {
+		allocated += pi.TokenReservation
+		pods[pi.Status()]++
+		for rn, ri := range pi.RIs {
+			limits[rn] += ri.Limit()
+		}
+	}
+
+	gauge(e.tokenCapacity, float64(e.node.Tokens))
+	gauge(e.tokensAlloc, allocated)
+	gauge(e.tokensFree, float64(e.node.Tokens)-allocated)
+	for rn, capacity := range e.node.Capacity {
+		gauge(e.capacity, capacity, string(rn))
+		gauge(e.limitSum, limits[rn], string(rn))
+	}
+	for status, n := range pods {
+		gauge(e.pods, n, string(status))
+	}
+}
+
+// collectWatchdog sends the state of the watchdog, and of the control loop
+// which it can tell without the monitor lock.
+func (e *Exporter) collectWatchdog(ch chan<- prometheus.Metric) {
+	m := e.monitor
+	ch <- prometheus.MustNewConstMetric(e.sinceLastTick, prometheus.GaugeValue, float64(m.Now()-m.LastTick())/1e9)
+
+	w := e.node.Watchdog
+	if w == nil {
+		return
+	}
+	engaged := 0.
+	if w.Engaged() {
+		engaged = 1
+	}
+	ch <- prometheus.MustNewConstMetric(e.wdEngaged, prometheus.GaugeValue, engaged)
+	ch <- prometheus.MustNewConstMetric(e.wdPods, prometheus.GaugeValue, float64(w.FailSafePods()))
+	for transition, n := range w.Transitions() {
+		ch <- prometheus.MustNewConstMetric(e.wdTransitions, prometheus.CounterValue, float64(n), transition)
+	}
+}
+
+// collectReconciler sends the events of the reconciler, which don't need the monitor lock.
+func (e *Exporter) collectReconciler(ch chan<- prometheus.Metric) {
+	r := e.node.Reconciler
+	if r == nil {
+		return
+	}
+	for event, n := range r.Events() {
+		ch <- prometheus.MustNewConstMetric(e.rcEvents, prometheus.CounterValue, float64(n), event)
+	}
+}
+
+// collectPodSync sends the results of the pod syncer, which don't need the monitor lock.
+func (e *Exporter) collectPodSync(ch chan<- prometheus.Metric) {
+	s := e.node.PodSync
+	if s == nil {
+		return
+	}
+	for result, n := range s.Results() {
+		ch <- prometheus.MustNewConstMetric(e.podSyncs, prometheus.CounterValue, float64(n), result)
+	}
+}
+
+// collectGPU sends the number of vGPU IDs, skipped while the module is not loaded.
+func (e *Exporter) collectGPU(ch chan<- prometheus.Metric) {
+	if e.node.GPU == nil {
+		return
+	}
+	ids, err := e.node.GPU.ListIDs()
+	if err != nil {
+		klog.V(4).Info("Couldn't list the vGPU IDs : ", err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(e.vgpuIDs, prometheus.GaugeValue, float64(len(ids)))
+}
+
+func (e *Exporter) PodAdded(now int64, pi *kumonitor.PodInfo)     {}
+func (e *Exporter) PodCompleted(now int64, pi *kumonitor.PodInfo) {}
+
+// Ticked observes the duration of the tick, and counts it as an overrun if it
+// took longer than the monitoring period.
+func (e *Exporter) Ticked(now int64, m *kumonitor.Monitor) {
+	duration := time.Duration(now - m.TickStart())
+	e.tickDuration.Observe(duration.Seconds())
+	if duration > m.Period() {
+		e.tickOverruns.Inc()
+	}
+}
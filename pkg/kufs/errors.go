@@ -0,0 +1,70 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kufs
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+var (
+	// ErrNotExist : the file is gone, usually because the pod is gone.
+	ErrNotExist = errors.New("file does not exist")
+	// ErrPermission : KuScale is not privileged enough.
+	ErrPermission = errors.New("permission denied")
+	// ErrInvalid : the contents couldn't be parsed or the value was refused.
+	ErrInvalid = errors.New("invalid value")
+	// ErrIO : any other failure.
+	ErrIO = errors.New("i/o error")
+)
+
+// Error records the operation and the path that failed. Kind is one of the
+// Err values above, so errors.Is(err, kufs.ErrNotExist) works.
+type Error struct {
+	Op   string
+	Path string
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Op + " " + e.Path + ": " + e.Kind.Error()
+	}
+	return e.Op + " " + e.Path + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+func (e *Error) Is(target error) bool { return target == e.Kind }
+
+func wrap(op, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	kind := ErrIO
+	switch {
+	case os.IsNotExist(err):
+		kind = ErrNotExist
+	case os.IsPermission(err):
+		kind = ErrPermission
+	case errors.Is(err, os.ErrInvalid), errors.Is(err, syscall.EINVAL), errors.Is(err, syscall.ERANGE):
+		kind = ErrInvalid
+	}
+	return &Error{Op: op, Path: path, Kind: kind, Err: err}
+}
+
+func IsNotExist(err error) bool { return errors.Is(err, ErrNotExist) }
@@ -0,0 +1,84 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kufs is the only way KuScale touches cgroup, sysfs and procfs
+// files, so that the host can be replaced by an in-memory one.
+package kufs
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type FS interface {
+	ReadFile(path string) ([]byte, error)
+	// WriteFile writes into an existing file or creates one in an existing
+	// directory, like writing into cgroup or sysfs.
+	WriteFile(path string, data []byte) error
+	// ReadDir returns the names in the directory, sorted.
+	ReadDir(path string) ([]string, error)
+	Exists(path string) bool
+}
+
+// Roots are where the host directories are mounted in the KuScale container.
+type Roots struct {
+	Cgroup string // cgroup hierarchy, /sys/fs/cgroup of the host
+	GPU    string // ku-gpu-layer kernel module
+	Proc   string // procfs of the host
+}
+
+var DefaultRoots = Roots{
+	Cgroup: "/home/cgroup",
+	GPU:    "/sys/kernel/gpu",
+	Proc:   "/home/proc",
+}
+
+// ParseUint parses s, returning 0 for negative values as cgroup does for -1.
+func ParseUint(s string) (uint64, error) {
+	value, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		intValue, intErr := strconv.ParseInt(s, 10, 64)
+		if intErr == nil && intValue < 0 {
+			return 0, nil
+		} else if intErr != nil && intErr.(*strconv.NumError).Err == strconv.ErrRange && strings.HasPrefix(s, "-") {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return value, nil
+}
+
+// ReadUint reads a file which contains a single unsigned integer.
+func ReadUint(fs FS, path string) (uint64, error) {
+	contents, err := fs.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	value, err := ParseUint(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return 0, &Error{Op: "parse", Path: path, Kind: ErrInvalid, Err: err}
+	}
+	return value, nil
+}
+
+// WriteUint writes a single unsigned integer into a file.
+func WriteUint(fs FS, path string, value uint64) error {
+	return fs.WriteFile(path, []byte(strconv.FormatUint(value, 10)))
+}
+
+// Join is filepath.Join, to keep the callers free of path/filepath.
+func Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
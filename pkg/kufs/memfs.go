@@ -0,0 +1,150 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kufs
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemFS is an in-memory FS for tests and simulations. Like cgroup and
+// sysfs, files can only be written in directories that exist.
+type MemFS struct {
+	mu    sync.RWMutex
+	files map[string][]byte
+	dirs  map[string]bool
+	// OnWrite, if set, is called after every successful write.
+	OnWrite func(path string, data []byte)
+}
+
+func NewMemFS() *MemFS {
+	return &MemFS{
+		files: make(map[string][]byte),
+		dirs:  map[string]bool{"/": true},
+	}
+}
+
+func clean(path string) string {
+	return filepath.Clean("/" + path)
+}
+
+// MkdirAll makes path and its parents.
+func (fs *MemFS) MkdirAll(path string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.mkdirAll(clean(path))
+}
+
+func (fs *MemFS) mkdirAll(path string) {
+	for p := path; !fs.dirs[p]; p = filepath.Dir(p) {
+		fs.dirs[p] = true
+	}
+}
+
+// SetFile makes the file with its parents, whatever the directory is.
+func (fs *MemFS) SetFile(path string, data []byte) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	path = clean(path)
+	fs.mkdirAll(filepath.Dir(path))
+	fs.files[path] = append([]byte(nil), data...)
+}
+
+// RemoveAll removes path and everything under it, like a pod going away.
+func (fs *MemFS) RemoveAll(path string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	path = clean(path)
+	prefix := path + "/"
+	for p := range fs.files {
+		if p == path || strings.HasPrefix(p, prefix) {
+			delete(fs.files, p)
+		}
+	}
+	for p := range fs.dirs {
+		if p == path || strings.HasPrefix(p, prefix) {
+			delete(fs.dirs, p)
+		}
+	}
+}
+
+func (fs *MemFS) ReadFile(path string) ([]byte, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	data, ok := fs.files[clean(path)]
+	if !ok {
+		return nil, &Error{Op: "read", Path: path, Kind: ErrNotExist, Err: os.ErrNotExist}
+	}
+	return append([]byte(nil), data...), nil
+}
+
+func (fs *MemFS) WriteFile(path string, data []byte) error {
+	fs.mu.Lock()
+	p := clean(path)
+	if !fs.dirs[filepath.Dir(p)] {
+		fs.mu.Unlock()
+		return &Error{Op: "write", Path: path, Kind: ErrNotExist, Err: os.ErrNotExist}
+	}
+	fs.files[p] = append([]byte(nil), data...)
+	onWrite := fs.OnWrite
+	fs.mu.Unlock()
+
+	if onWrite != nil {
+		onWrite(p, data)
+	}
+	return nil
+}
+
+func (fs *MemFS) ReadDir(path string) ([]string, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	path = clean(path)
+	if !fs.dirs[path] {
+		return nil, &Error{Op: "readdir", Path: path, Kind: ErrNotExist, Err: os.ErrNotExist}
+	}
+	seen := make(map[string]bool)
+	for _, m := range []map[string]bool{fs.dirs, fs.fileSet()} {
+		for p := range m {
+			if p != path && filepath.Dir(p) == path {
+				seen[filepath.Base(p)] = true
+			}
+		}
+	}
+	var names []string
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (fs *MemFS) fileSet() map[string]bool {
+	set := make(map[string]bool, len(fs.files))
+	for p := range fs.files {
+		set[p] = true
+	}
+	return set
+}
+
+func (fs *MemFS) Exists(path string) bool {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	path = clean(path)
+	_, ok := fs.files[path]
+	return ok || fs.dirs[path]
+}
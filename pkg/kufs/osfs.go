@@ -0,0 +1,74 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kufs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// OSFS is the real filesystem. Every path is taken relative to Root, so a
+// directory can stand in for the host with OSFS{Root: dir}.
+type OSFS struct {
+	Root string
+}
+
+func NewOSFS(root string) *OSFS { return &OSFS{Root: root} }
+
+func (fs *OSFS) real(path string) string {
+	if fs.Root == "" {
+		return path
+	}
+	return filepath.Join(fs.Root, path)
+}
+
+func (fs *OSFS) ReadFile(path string) ([]byte, error) {
+	contents, err := ioutil.ReadFile(fs.real(path))
+	return contents, wrap("read", path, err)
+}
+
+func (fs *OSFS) WriteFile(path string, data []byte) error {
+	// O_TRUNC is ignored by cgroup and sysfs, but not by regular files.
+	f, err := os.OpenFile(fs.real(path), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return wrap("write", path, err)
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return wrap("write", path, err)
+}
+
+func (fs *OSFS) ReadDir(path string) ([]string, error) {
+	f, err := os.Open(fs.real(path))
+	if err != nil {
+		return nil, wrap("readdir", path, err)
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return nil, wrap("readdir", path, err)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (fs *OSFS) Exists(path string) bool {
+	_, err := os.Stat(fs.real(path))
+	return err == nil
+}
@@ -0,0 +1,180 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kugpu
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kufs"
+)
+
+// Fake behaves like the ku-gpu-layer module on top of a plain directory.
+// It is a kufs.FS, so New(fake, root) drives it like the real module, and
+// the files can be inspected in dir.
+type Fake struct {
+	*kufs.OSFS
+	root string
+
+	mu      sync.Mutex
+	reloads int
+}
+
+// NewFake lays out the sysfs of the module at dir/root, as if it was loaded.
+func NewFake(dir, root string) (*Fake, error) {
+	f := &Fake{OSFS: kufs.NewOSFS(dir), root: root}
+	if err := f.layout(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *Fake) layout() error {
+	for _, d := range []string{"configs", "IDs", "gemini"} {
+		if err := os.MkdirAll(filepath.Join(f.OSFS.Root, f.root, d), 0755); err != nil {
+			return err
+		}
+	}
+	files := map[string]string{
+		"configs/init":         "",
+		"configs/destroy":      "",
+		"configs/totalIDs":     "0",
+		"gemini/resource_conf": "",
+	}
+	for name, contents := range files {
+		if err := f.OSFS.WriteFile(kufs.Join(f.root, name), []byte(contents)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Fake) sysModule() string { return filepath.Join(f.OSFS.Root, "/sys/module", ModuleName) }
+
+// Load makes the Fake a Loader : it lays out the sysfs again, and publishes
+// the srcversion of the module file at /sys/module.
+func (f *Fake) Load(path, params string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.OSFS.Exists(kufs.Join(f.root, "configs")) {
+		return &kufs.Error{Op: "load", Path: path, Kind: kufs.ErrInvalid, Err: os.ErrExist}
+	}
+	mi, err := ReadModInfo(path)
+	if err != nil {
+		return err
+	}
+	if err := f.layout(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(f.sysModule(), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(f.sysModule(), "srcversion"), []byte(mi.SrcVersion+"\n"), 0644)
+}
+
+// Unload removes the sysfs of the module and every ID.
+func (f *Fake) Unload(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := os.RemoveAll(filepath.Join(f.OSFS.Root, f.root)); err != nil {
+		return err
+	}
+	return os.RemoveAll(f.sysModule())
+}
+
+// Reloads is how many times resource_conf was written.
+func (f *Fake) Reloads() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.reloads
+}
+
+// AddRuntime advances total_runtime of id by ns.
+func (f *Fake) AddRuntime(id ID, ns uint64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := kufs.Join(f.root, "IDs", id.String(), "total_runtime")
+	runtime, err := kufs.ReadUint(f.OSFS, path)
+	if err != nil {
+		return err
+	}
+	return kufs.WriteUint(f.OSFS, path, runtime+ns)
+}
+
+func invalid(path string, data []byte) error {
+	return &kufs.Error{Op: "write", Path: path, Kind: kufs.ErrInvalid,
+		Err: fmt.Errorf("%q refused", strings.TrimSpace(string(data)))}
+}
+
+// WriteFile handles the writes to the config files as the module does.
+func (f *Fake) WriteFile(path string, data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rel, err := filepath.Rel(f.root, filepath.Clean(path))
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return f.OSFS.WriteFile(path, data)
+	}
+
+	switch {
+	case rel == "configs/init":
+		id, err := ParseID(string(data))
+		if err != nil {
+			return invalid(path, data)
+		}
+		dir := kufs.Join(f.root, "IDs", id.String())
+		if f.OSFS.Exists(dir) {
+			return invalid(path, data)
+		}
+		if err := os.MkdirAll(filepath.Join(f.OSFS.Root, dir), 0755); err != nil {
+			return err
+		}
+		for _, name := range []string{"total_runtime", "gpu_request", "gpu_limit", "gpu_memory", "current_quota"} {
+			if err := f.OSFS.WriteFile(kufs.Join(dir, name), []byte("0")); err != nil {
+				return err
+			}
+		}
+		total, err := kufs.ReadUint(f.OSFS, kufs.Join(f.root, "configs/totalIDs"))
+		if err != nil {
+			return err
+		}
+		return kufs.WriteUint(f.OSFS, kufs.Join(f.root, "configs/totalIDs"), total+1)
+
+	case rel == "configs/destroy":
+		id, err := ParseID(string(data))
+		if err != nil || !f.OSFS.Exists(kufs.Join(f.root, "IDs", id.String())) {
+			return invalid(path, data)
+		}
+		return os.RemoveAll(filepath.Join(f.OSFS.Root, f.root, "IDs", id.String()))
+
+	case rel == "gemini/resource_conf":
+		f.reloads++
+		return f.OSFS.WriteFile(path, data)
+
+	case strings.HasPrefix(rel, "IDs/"):
+		// sysfs files can't be created, and only take numbers
+		if !f.OSFS.Exists(path) {
+			return &kufs.Error{Op: "write", Path: path, Kind: kufs.ErrNotExist, Err: os.ErrNotExist}
+		}
+		if _, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64); err != nil {
+			return invalid(path, data)
+		}
+	}
+	return f.OSFS.WriteFile(path, data)
+}
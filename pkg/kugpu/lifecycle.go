@@ -0,0 +1,269 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package kugpu
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kufs"
+	"k8s.io/klog"
+)
+
+// ModuleName is the name of ku-gpu-layer.ko in the kernel.
+const ModuleName = "ku_gpu_layer"
+
+var (
+	ErrChecksum    = errors.New("checksum of the module doesn't match")
+	ErrVermagic    = errors.New("module was not built for the running kernel")
+	ErrStaleModule = errors.New("loaded module is not the shipped one")
+	ErrInUse       = errors.New("vGPU IDs of the module are in use")
+)
+
+// ModInfo is the .modinfo section of a kernel module file.
+type ModInfo struct {
+	Name       string
+	Vermagic   string
+	SrcVersion string
+	SHA256     string
+}
+
+// Release is the kernel release the module was built for.
+func (mi *ModInfo) Release() string {
+	return strings.SplitN(mi.Vermagic, " ", 2)[0]
+}
+
+/*
+Func Name : ReadModInfo()
+Objective : 1) Hash the module file
+			2) Parse the key=value entries of its .modinfo section
+*/
+func ReadModInfo(path string) (*ModInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+	mi := &ModInfo{SHA256: hex.EncodeToString(hash.Sum(nil))}
+
+	ko, err := elf.NewFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a kernel module : %w", path, err)
+	}
+	section := ko.Section(".modinfo")
+	if section == nil {
+		return nil, fmt.Errorf("%s has no .modinfo section", path)
+	}
+	data, err := section.Data()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range bytes.Split(data, []byte{0}) {
+		kv := strings.SplitN(string(entry), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "name":
+			mi.Name = kv[1]
+		case "vermagic":
+			mi.Vermagic = kv[1]
+		case "srcversion":
+			mi.SrcVersion = kv[1]
+		}
+	}
+	if mi.Vermagic == "" {
+		return nil, fmt.Errorf("%s has no vermagic", path)
+	}
+	return mi, nil
+}
+
+// Loader inserts and removes kernel modules.
+type Loader interface {
+	Load(path, params string) error
+	Unload(name string) error
+}
+
+// Lifecycle loads the shipped ku-gpu-layer.ko after checking that it is the
+// expected file and that it fits the running kernel, and unloads it only
+// when no vGPU ID is left.
+type Lifecycle struct {
+	module *Module
+	fs     kufs.FS
+	loader Loader
+
+	// ModulePath is the shipped .ko, read from the KuScale container.
+	ModulePath string
+	// Checksum is the expected sha256 of ModulePath. When empty, it is read
+	// from ModulePath.sha256, in the format of sha256sum.
+	Checksum string
+	// ProcRoot is where the procfs of the host is, in fs.
+	ProcRoot string
+	// SysModule is the sysfs directory of the loaded module, in fs.
+	SysModule string
+
+	shipped *ModInfo // of the last Verify()
+}
+
+func NewLifecycle(module *Module, fs kufs.FS, loader Loader, modulePath, procRoot string) *Lifecycle {
+	return &Lifecycle{
+		module:     module,
+		fs:         fs,
+		loader:     loader,
+		ModulePath: modulePath,
+		ProcRoot:   procRoot,
+		SysModule:  kufs.Join("/sys/module", ModuleName),
+	}
+}
+
+func (l *Lifecycle) expectedChecksum() (string, error) {
+	if l.Checksum != "" {
+		return strings.ToLower(l.Checksum), nil
+	}
+	contents, err := os.ReadFile(l.ModulePath + ".sha256")
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(contents))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("%s.sha256 is empty", l.ModulePath)
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+// KernelRelease is the release of the running kernel, as uname -r.
+func (l *Lifecycle) KernelRelease() (string, error) {
+	contents, err := l.fs.ReadFile(kufs.Join(l.ProcRoot, "sys/kernel/osrelease"))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(contents)), nil
+}
+
+// Verify checks the checksum and the vermagic of the shipped module.
+func (l *Lifecycle) Verify() (*ModInfo, error) {
+	mi, err := ReadModInfo(l.ModulePath)
+	if err != nil {
+		return nil, err
+	}
+	expected, err := l.expectedChecksum()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get the checksum of %s : %w", l.ModulePath, err)
+	}
+	if mi.SHA256 != expected {
+		return nil, fmt.Errorf("%w: %s is %s, expected %s", ErrChecksum, l.ModulePath, mi.SHA256, expected)
+	}
+	release, err := l.KernelRelease()
+	if err != nil {
+		return nil, err
+	}
+	if mi.Release() != release {
+		return nil, fmt.Errorf("%w: built for %s, running %s", ErrVermagic, mi.Release(), release)
+	}
+	l.shipped = mi
+	return mi, nil
+}
+
+// LoadedSrcVersion is the srcversion of the module in the kernel.
+func (l *Lifecycle) LoadedSrcVersion() (string, error) {
+	contents, err := l.fs.ReadFile(kufs.Join(l.SysModule, "srcversion"))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(contents)), nil
+}
+
+/*
+Func Name : EnsureLoaded()
+Objective : 1) Verify the shipped module
+			2) Report a loaded module which is not the shipped one
+			3) Load the shipped module if none is loaded
+*/
+func (l *Lifecycle) EnsureLoaded() error {
+	mi, err := l.Verify()
+	if err != nil {
+		return err
+	}
+
+	if l.module.Loaded() {
+		loaded, err := l.LoadedSrcVersion()
+		if err != nil {
+			return fmt.Errorf("couldn't get the version of the loaded module : %w", err)
+		}
+		if loaded != mi.SrcVersion {
+			// IDs may be in use, so it is left to the operator to reload it
+			return fmt.Errorf("%w: srcversion %s is loaded, %s is shipped", ErrStaleModule, loaded, mi.SrcVersion)
+		}
+		klog.V(5).Info("Already Inserted KU GPU Lyaer Module ", mi.SrcVersion)
+		return nil
+	}
+
+	if err := l.loader.Load(l.ModulePath, ""); err != nil {
+		return fmt.Errorf("couldn't load %s : %w", l.ModulePath, err)
+	}
+	if !l.module.Loaded() {
+		return fmt.Errorf("%w after loading %s", ErrNotLoaded, l.ModulePath)
+	}
+	klog.V(5).Info("Inserted KU GPU Lyaer Module ", mi.SrcVersion)
+	return nil
+}
+
+// Check fails when the module is not loaded, or is not the verified one.
+func (l *Lifecycle) Check() error {
+	if !l.module.Loaded() {
+		return ErrNotLoaded
+	}
+	if l.shipped == nil {
+		return nil
+	}
+	loaded, err := l.LoadedSrcVersion()
+	if err != nil {
+		return err
+	}
+	if loaded != l.shipped.SrcVersion {
+		return fmt.Errorf("%w: srcversion %s is loaded, %s is shipped", ErrStaleModule, loaded, l.shipped.SrcVersion)
+	}
+	return nil
+}
+
+// Unload removes the module, unless a vGPU ID still exists.
+func (l *Lifecycle) Unload() error {
+	if !l.module.Loaded() {
+		return nil
+	}
+	ids, err := l.module.ListIDs()
+	if err != nil {
+		return err
+	}
+	if len(ids) > 0 {
+		return fmt.Errorf("%w: %v", ErrInUse, ids)
+	}
+	if err := l.loader.Unload(ModuleName); err != nil {
+		return err
+	}
+	klog.V(5).Info("Removed KU GPU Lyaer Module")
+	return nil
+}
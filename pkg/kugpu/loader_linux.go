@@ -0,0 +1,38 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package kugpu
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// KernelLoader loads modules with finit_module and removes them with
+// delete_module, so neither insmod nor rmmod is needed in the container.
+type KernelLoader struct{}
+
+func (KernelLoader) Load(path, params string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return os.NewSyscallError("finit_module", unix.FinitModule(int(file.Fd()), params, 0))
+}
+
+func (KernelLoader) Unload(name string) error {
+	// O_NONBLOCK fails with EWOULDBLOCK instead of waiting for the users
+	return os.NewSyscallError("delete_module", unix.DeleteModule(name, unix.O_NONBLOCK))
+}
@@ -0,0 +1,29 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//go:build !linux
+// +build !linux
+
+package kugpu
+
+import "errors"
+
+type KernelLoader struct{}
+
+func (KernelLoader) Load(path, params string) error {
+	return errors.New("kernel modules can only be loaded on linux")
+}
+
+func (KernelLoader) Unload(name string) error {
+	return errors.New("kernel modules can only be unloaded on linux")
+}
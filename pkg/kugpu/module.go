@@ -0,0 +1,230 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kugpu drives the ku-gpu-layer kernel module through its sysfs
+// interface :
+//
+//	<root>/configs/init           write an ID to create it
+//	<root>/configs/destroy        write an ID to destroy it
+//	<root>/configs/totalIDs       number of IDs created so far
+//	<root>/IDs/<ID>/gpu_limit     quota in 1/1000 of the GPU
+//	<root>/IDs/<ID>/gpu_request   quota in 1/1000 of the GPU
+//	<root>/IDs/<ID>/total_runtime accumulated GPU runtime in ns
+//	<root>/gemini/resource_conf   write to make Gemini reload the quotas
+package kugpu
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kufs"
+)
+
+// ID of a vGPU in the module.
+type ID int
+
+func (id ID) String() string { return strconv.Itoa(int(id)) }
+
+// MaxQuota is the whole GPU in the unit of gpu_limit and gpu_request.
+const MaxQuota = 1000
+
+var (
+	ErrNotLoaded    = errors.New("ku-gpu-layer module is not loaded")
+	ErrInvalidID    = errors.New("invalid vGPU ID")
+	ErrInvalidQuota = errors.New("invalid GPU quota")
+	ErrIDExists     = errors.New("vGPU ID already exists")
+	ErrNoSuchID     = errors.New("no such vGPU ID")
+)
+
+type Module struct {
+	fs   kufs.FS
+	root string
+}
+
+func New(fs kufs.FS, root string) *Module {
+	return &Module{fs: fs, root: root}
+}
+
+func (m *Module) Root() string { return m.root }
+
+// Loaded tells whether the sysfs of the module exists.
+func (m *Module) Loaded() bool {
+	return m.fs.Exists(kufs.Join(m.root, "configs"))
+}
+
+func (m *Module) configPath(name string) string { return kufs.Join(m.root, "configs", name) }
+
+// IDPath is the directory of id, which is also mounted in the container.
+func (m *Module) IDPath(id ID) string { return kufs.Join(m.root, "IDs", id.String()) }
+
+func (m *Module) idFile(id ID, name string) string { return kufs.Join(m.IDPath(id), name) }
+
+// IDOf returns the ID whose directory is path.
+func (m *Module) IDOf(path string) (ID, error) {
+	if filepath.Clean(filepath.Dir(path)) != filepath.Clean(kufs.Join(m.root, "IDs")) {
+		return 0, fmt.Errorf("%w: %s is not under %s/IDs", ErrInvalidID, path, m.root)
+	}
+	return ParseID(filepath.Base(path))
+}
+
+func ParseID(s string) (ID, error) {
+	id, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || id < 0 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidID, s)
+	}
+	return ID(id), nil
+}
+
+func (m *Module) check() error {
+	if !m.Loaded() {
+		return ErrNotLoaded
+	}
+	return nil
+}
+
+func (m *Module) checkID(id ID) error {
+	if err := m.check(); err != nil {
+		return err
+	}
+	if id < 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidID, id)
+	}
+	if !m.fs.Exists(m.IDPath(id)) {
+		// Matches both ErrNoSuchID and kufs.ErrNotExist
+		return &kufs.Error{Op: "check", Path: m.IDPath(id), Kind: kufs.ErrNotExist, Err: ErrNoSuchID}
+	}
+	return nil
+}
+
+// TotalIDs is the number of IDs created since the module was loaded. It is
+// also the next ID to create, since IDs are never reused.
+func (m *Module) TotalIDs() (int, error) {
+	if err := m.check(); err != nil {
+		return 0, err
+	}
+	total, err := kufs.ReadUint(m.fs, m.configPath("totalIDs"))
+	return int(total), err
+}
+
+// ListIDs returns the IDs which exist now, in order.
+func (m *Module) ListIDs() ([]ID, error) {
+	if err := m.check(); err != nil {
+		return nil, err
+	}
+	names, err := m.fs.ReadDir(kufs.Join(m.root, "IDs"))
+	if kufs.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	ids := make([]ID, 0, len(names))
+	for _, name := range names {
+		id, err := ParseID(name)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func (m *Module) CreateID(id ID) error {
+	if err := m.check(); err != nil {
+		return err
+	}
+	if id < 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidID, id)
+	}
+	if m.fs.Exists(m.IDPath(id)) {
+		return fmt.Errorf("%w: %d", ErrIDExists, id)
+	}
+	if err := m.fs.WriteFile(m.configPath("init"), []byte(id.String())); err != nil {
+		return err
+	}
+	if !m.fs.Exists(m.IDPath(id)) {
+		return fmt.Errorf("module didn't create vGPU ID %d", id)
+	}
+	return nil
+}
+
+func (m *Module) DestroyID(id ID) error {
+	if err := m.checkID(id); err != nil {
+		return err
+	}
+	return m.fs.WriteFile(m.configPath("destroy"), []byte(id.String()))
+}
+
+func checkQuota(quota uint64) error {
+	if quota > MaxQuota {
+		return fmt.Errorf("%w: %d is over %d", ErrInvalidQuota, quota, MaxQuota)
+	}
+	return nil
+}
+
+// SetLimit sets gpu_limit of id, in 1/1000 of the GPU.
+func (m *Module) SetLimit(id ID, quota uint64) error {
+	if err := checkQuota(quota); err != nil {
+		return err
+	}
+	if err := m.checkID(id); err != nil {
+		return err
+	}
+	return kufs.WriteUint(m.fs, m.idFile(id, "gpu_limit"), quota)
+}
+
+// SetRequest sets gpu_request of id, in 1/1000 of the GPU.
+func (m *Module) SetRequest(id ID, quota uint64) error {
+	if err := checkQuota(quota); err != nil {
+		return err
+	}
+	if err := m.checkID(id); err != nil {
+		return err
+	}
+	return kufs.WriteUint(m.fs, m.idFile(id, "gpu_request"), quota)
+}
+
+func (m *Module) Limit(id ID) (uint64, error) {
+	if err := m.checkID(id); err != nil {
+		return 0, err
+	}
+	return kufs.ReadUint(m.fs, m.idFile(id, "gpu_limit"))
+}
+
+func (m *Module) Request(id ID) (uint64, error) {
+	if err := m.checkID(id); err != nil {
+		return 0, err
+	}
+	return kufs.ReadUint(m.fs, m.idFile(id, "gpu_request"))
+}
+
+// Runtime returns the accumulated GPU runtime of id in ns.
+func (m *Module) Runtime(id ID) (uint64, error) {
+	if err := m.checkID(id); err != nil {
+		return 0, err
+	}
+	return kufs.ReadUint(m.fs, m.idFile(id, "total_runtime"))
+}
+
+// ReloadResourceConf makes Gemini read the quotas of every ID again.
+func (m *Module) ReloadResourceConf() error {
+	if err := m.check(); err != nil {
+		return err
+	}
+	return kufs.WriteUint(m.fs, kufs.Join(m.root, "gemini", "resource_conf"), 0)
+}
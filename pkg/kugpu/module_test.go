@@ -0,0 +1,180 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kugpu
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kufs"
+)
+
+const testRoot = "/sys/kernel/gpu"
+
+func newTestModule(t *testing.T) (*Module, *Fake) {
+	t.Helper()
+	fake, err := NewFake(t.TempDir(), testRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(fake, testRoot), fake
+}
+
+func TestCreateAndDestroyIDs(t *testing.T) {
+	m, _ := newTestModule(t)
+	for id := ID(0); id < 3; id++ {
+		if err := m.CreateID(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.CreateID(1); !errors.Is(err, ErrIDExists) {
+		t.Errorf("CreateID of an existing ID = %v, want ErrIDExists", err)
+	}
+	if err := m.CreateID(-1); !errors.Is(err, ErrInvalidID) {
+		t.Errorf("CreateID(-1) = %v, want ErrInvalidID", err)
+	}
+	if total, err := m.TotalIDs(); err != nil || total != 3 {
+		t.Errorf("TotalIDs = %d, %v, want 3", total, err)
+	}
+
+	if err := m.DestroyID(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.DestroyID(1); !errors.Is(err, ErrNoSuchID) || !kufs.IsNotExist(err) {
+		t.Errorf("DestroyID of a destroyed ID = %v, want ErrNoSuchID", err)
+	}
+	ids, err := m.ListIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []ID{0, 2}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("ListIDs = %v, want %v", ids, want)
+	}
+	// IDs are never reused
+	if total, err := m.TotalIDs(); err != nil || total != 3 {
+		t.Errorf("TotalIDs = %d, %v, want 3", total, err)
+	}
+}
+
+func TestQuotasAndRuntime(t *testing.T) {
+	m, fake := newTestModule(t)
+	if err := m.CreateID(0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.SetLimit(0, 600); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetRequest(0, 250); err != nil {
+		t.Fatal(err)
+	}
+	if limit, err := m.Limit(0); err != nil || limit != 600 {
+		t.Errorf("Limit = %d, %v, want 600", limit, err)
+	}
+	if request, err := m.Request(0); err != nil || request != 250 {
+		t.Errorf("Request = %d, %v, want 250", request, err)
+	}
+
+	if err := m.SetLimit(0, MaxQuota+1); !errors.Is(err, ErrInvalidQuota) {
+		t.Errorf("SetLimit over MaxQuota = %v, want ErrInvalidQuota", err)
+	}
+	if err := m.SetRequest(1, 100); !errors.Is(err, ErrNoSuchID) {
+		t.Errorf("SetRequest of a missing ID = %v, want ErrNoSuchID", err)
+	}
+	if limit, _ := m.Limit(0); limit != 600 {
+		t.Errorf("Limit = %d after a refused quota, want 600", limit)
+	}
+
+	if err := fake.AddRuntime(0, 1500); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.AddRuntime(0, 500); err != nil {
+		t.Fatal(err)
+	}
+	if runtime, err := m.Runtime(0); err != nil || runtime != 2000 {
+		t.Errorf("Runtime = %d, %v, want 2000", runtime, err)
+	}
+}
+
+func TestReloadResourceConf(t *testing.T) {
+	m, fake := newTestModule(t)
+	for i := 0; i < 2; i++ {
+		if err := m.ReloadResourceConf(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fake.Reloads() != 2 {
+		t.Errorf("%d reloads, want 2", fake.Reloads())
+	}
+}
+
+func TestNotLoaded(t *testing.T) {
+	m, fake := newTestModule(t)
+	if !m.Loaded() {
+		t.Fatal("the fake module isn't loaded")
+	}
+	if err := fake.Unload(ModuleName); err != nil {
+		t.Fatal(err)
+	}
+	if m.Loaded() {
+		t.Error("the module is loaded after Unload")
+	}
+	for name, err := range map[string]error{
+		"CreateID":           m.CreateID(0),
+		"SetLimit":           m.SetLimit(0, 100),
+		"ReloadResourceConf": m.ReloadResourceConf(),
+	} {
+		if !errors.Is(err, ErrNotLoaded) {
+			t.Errorf("%s = %v, want ErrNotLoaded", name, err)
+		}
+	}
+}
+
+func TestIDOf(t *testing.T) {
+	m, _ := newTestModule(t)
+	if id, err := m.IDOf(m.IDPath(7)); err != nil || id != 7 {
+		t.Errorf("IDOf(%s) = %d, %v, want 7", m.IDPath(7), id, err)
+	}
+	for _, path := range []string{"/sys/kernel/gpu/configs/7", "/sys/kernel/gpu/IDs/x", "/other/IDs/7"} {
+		if _, err := m.IDOf(path); !errors.Is(err, ErrInvalidID) {
+			t.Errorf("IDOf(%s) = %v, want ErrInvalidID", path, err)
+		}
+	}
+}
+
+// unloader is a Loader which only counts the unloads.
+type unloader struct{ unloads int }
+
+func (u *unloader) Load(path, params string) error { return errors.New("not supported") }
+func (u *unloader) Unload(name string) error       { u.unloads++; return nil }
+
+func TestUnloadKeepsModuleInUse(t *testing.T) {
+	m, fake := newTestModule(t)
+	loader := &unloader{}
+	l := NewLifecycle(m, fake, loader, "", "/proc")
+	if err := m.CreateID(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Unload(); !errors.Is(err, ErrInUse) {
+		t.Errorf("Unload with an ID = %v, want ErrInUse", err)
+	}
+	if err := m.DestroyID(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Unload(); err != nil || loader.unloads != 1 {
+		t.Errorf("Unload = %v after %d unloads, want it unloaded once", err, loader.unloads)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package kuhealth
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Check returns nil when its part of KuScale works, or the reason it doesn't.
+type Check func() error
+
+type check struct {
+	name     string
+	liveness bool
+	run      Check
+}
+
+var checks []check
+
+// AddCheck adds a check to /readyz. A liveness check is also in /healthz,
+// and should only fail when restarting KuScale would help.
+func AddCheck(name string, liveness bool, run Check) {
+	mu.Lock()
+	defer mu.Unlock()
+	for i := range checks {
+		if checks[i].name == name {
+			checks[i] = check{name, liveness, run}
+			return
+		}
+	}
+	checks = append(checks, check{name, liveness, run})
+}
+
+// Check fails unless the component is healthy.
+func (c *Component) Check() error {
+	status := c.Status()
+	if status.State == Healthy {
+		return nil
+	}
+	if status.Error != "" {
+		return fmt.Errorf("%s (%d retries) : %s", status.State, status.Retries, status.Error)
+	}
+	return errors.New(string(status.State))
+}
+
+type Result struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Run runs the liveness checks, or every check.
+func Run(livenessOnly bool) ([]Result, bool) {
+	mu.Lock()
+	list := make([]check, len(checks))
+	copy(list, checks)
+	mu.Unlock()
+
+	results := make([]Result, 0, len(list))
+	ok := true
+	for _, c := range list {
+		if livenessOnly && !c.liveness {
+			continue
+		}
+		result := Result{Name: c.name, OK: true}
+		if err := c.run(); err != nil {
+			result.OK = false
+			result.Reason = err.Error()
+			ok = false
+		}
+		results = append(results, result)
+	}
+	return results, ok
+}
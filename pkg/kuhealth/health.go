@@ -0,0 +1,114 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package kuhealth keeps the state of every KuScale component, so that one
+// failing component is reported as degraded instead of taking the daemon down.
+package kuhealth
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+type State string
+
+const (
+	Starting State = "starting"
+	Healthy  State = "healthy"
+	Degraded State = "degraded"
+	Stopped  State = "stopped"
+)
+
+type Status struct {
+	Name    string    `json:"name"`
+	State   State     `json:"state"`
+	Error   string    `json:"error,omitempty"`
+	Since   time.Time `json:"since"`
+	Retries int       `json:"retries"`
+}
+
+type Component struct {
+	mu     sync.Mutex
+	status Status
+}
+
+var (
+	mu         sync.Mutex
+	components = make(map[string]*Component)
+)
+
+// Register returns the component called name, creating it as Starting.
+func Register(name string) *Component {
+	mu.Lock()
+	defer mu.Unlock()
+	if c, ok := components[name]; ok {
+		return c
+	}
+	c := &Component{status: Status{Name: name, State: Starting, Since: time.Now()}}
+	components[name] = c
+	return c
+}
+
+func (c *Component) set(state State, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	msg := ""
+	if err != nil {
+		msg = err.Error()
+	}
+	if c.status.State != state {
+		c.status.Since = time.Now()
+		if state == Degraded {
+			klog.Warning(c.status.Name, " is degraded : ", msg)
+		} else {
+			klog.V(4).Info(c.status.Name, " is ", state)
+		}
+	}
+	if state == Degraded {
+		c.status.Retries++
+	} else {
+		c.status.Retries = 0
+	}
+	c.status.State = state
+	c.status.Error = msg
+}
+
+func (c *Component) Healthy()           { c.set(Healthy, nil) }
+func (c *Component) Degraded(err error) { c.set(Degraded, err) }
+func (c *Component) Stopped()           { c.set(Stopped, nil) }
+
+func (c *Component) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status
+}
+
+// Snapshot returns the status of every component, sorted by name.
+func Snapshot() []Status {
+	mu.Lock()
+	list := make([]*Component, 0, len(components))
+	for _, c := range components {
+		list = append(list, c)
+	}
+	mu.Unlock()
+
+	statuses := make([]Status, 0, len(list))
+	for _, c := range list {
+		statuses = append(statuses, c.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
@@ -0,0 +1,67 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package kuhealth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+/*
+Func Name : Handler()
+Objective : 1) Run the checks on every request, for kubelet probes
+			2) Answer 200 when all pass and 503 otherwise, with a line per check
+			3) Answer the results and the component states in JSON with ?format=json
+*/
+func Handler(livenessOnly bool) http.Handler {
+	name := "readyz"
+	if livenessOnly {
+		name = "healthz"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		results, ok := Run(livenessOnly)
+		code := http.StatusOK
+		if !ok {
+			code = http.StatusServiceUnavailable
+		}
+
+		if r.URL.Query().Get("format") == "json" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(code)
+			json.NewEncoder(w).Encode(struct {
+				OK         bool     `json:"ok"`
+				Checks     []Result `json:"checks"`
+				Components []Status `json:"components"`
+			}{ok, results, Snapshot()})
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(code)
+		for _, result := range results {
+			if result.OK {
+				fmt.Fprintf(w, "[+]%s ok\n", result.Name)
+			} else {
+				fmt.Fprintf(w, "[-]%s failed: %s\n", result.Name, result.Reason)
+			}
+		}
+		if ok {
+			fmt.Fprintf(w, "%s check passed\n", name)
+		} else {
+			fmt.Fprintf(w, "%s check failed\n", name)
+		}
+	})
+}
@@ -0,0 +1,104 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package kuhealth
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// ErrStopped is returned by Retry when stopCh is closed before op succeeds.
+var ErrStopped = errors.New("stopped while retrying")
+
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+	Factor  float64
+	// Jitter spreads each wait by up to this fraction of it.
+	Jitter float64
+}
+
+var DefaultBackoff = Backoff{Initial: 500 * time.Millisecond, Max: 30 * time.Second, Factor: 2, Jitter: 0.2}
+
+// Wait returns the wait after the given number of failed attempts.
+func (b Backoff) Wait(attempt int) time.Duration {
+	wait := float64(b.Initial)
+	for i := 0; i < attempt && wait < float64(b.Max); i++ {
+		wait *= b.Factor
+	}
+	if wait > float64(b.Max) {
+		wait = float64(b.Max)
+	}
+	wait += wait * b.Jitter * (rand.Float64()*2 - 1)
+	return time.Duration(wait)
+}
+
+type permanent struct{ err error }
+
+func (p *permanent) Error() string { return p.err.Error() }
+func (p *permanent) Unwrap() error { return p.err }
+
+// Permanent marks err as not worth retrying.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanent{err}
+}
+
+type notYet struct{ err error }
+
+func (n *notYet) Error() string { return n.err.Error() }
+func (n *notYet) Unwrap() error { return n.err }
+
+// NotYet marks err as waiting for something which isn't there yet, retried
+// without degrading the component.
+func NotYet(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &notYet{err}
+}
+
+/*
+Func Name : Retry()
+Objective : 1) Run op until it succeeds, waiting longer after each failure
+			2) Report the component as degraded while op fails, but not on NotYet, and healthy after
+			3) Give up on a Permanent error or when stopCh is closed
+*/
+func Retry(stopCh <-chan string, b Backoff, c *Component, op func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil {
+			if c != nil {
+				c.Healthy()
+			}
+			return nil
+		}
+		var n *notYet
+		if c != nil && !errors.As(err, &n) {
+			c.Degraded(err)
+		}
+		var p *permanent
+		if errors.As(err, &p) {
+			return p.err
+		}
+		select {
+		case <-stopCh:
+			return ErrStopped
+		case <-time.After(b.Wait(attempt)):
+		}
+	}
+}
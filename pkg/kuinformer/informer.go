@@ -0,0 +1,142 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kuinformer watches the pods of the node in the Kubernetes API, and
+// tells the monitor their metadata, annotations and deletion.
+package kuinformer
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kumonitor"
+)
+
+// ownPrefix is of the annotations KuScale writes to the pods, whose updates
+// are not news for the monitor.
+const ownPrefix = "kuscale.com/"
+
+type Informer struct {
+	monitor *kumonitor.Monitor
+	factory informers.SharedInformerFactory
+	synced  cache.InformerSynced
+}
+
+// NewInformer watches the pods on nodeName for m, listing them again every
+// resync if it is positive.
+func NewInformer(client kubernetes.Interface, nodeName string, resync time.Duration, m *kumonitor.Monitor) *Informer {
+	factory := informers.NewSharedInformerFactoryWithOptions(client, resync,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = "spec.nodeName=" + nodeName
+		}))
+	podInformer := factory.Core().V1().Pods().Informer()
+	i := &Informer{monitor: m, factory: factory, synced: podInformer.HasSynced}
+
+	podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: i.update,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldPod, ok1 := oldObj.(*corev1.Pod)
+			newPod, ok2 := newObj.(*corev1.Pod)
+			if ok1 && ok2 && !terminated(newPod) && reflect.DeepEqual(podMeta(oldPod), podMeta(newPod)) {
+				return
+			}
+			i.update(newObj)
+		},
+		DeleteFunc: i.delete,
+	})
+	return i
+}
+
+// Run watches the pods until stopCh is closed.
+func (i *Informer) Run(stopCh chan string) {
+	klog.V(4).Info("Starting Pod Informer")
+	stop := make(chan struct{})
+	go func() {
+		<-stopCh
+		close(stop)
+	}()
+	i.factory.Start(stop)
+	if !cache.WaitForCacheSync(stop, i.synced) {
+		return
+	}
+	klog.V(4).Info("Pod Informer synced")
+}
+
+// Check fails until the pods are listed.
+func (i *Informer) Check() error {
+	if !i.synced() {
+		return errors.New("pods are not listed yet")
+	}
+	return nil
+}
+
+func (i *Informer) update(obj interface{}) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	if terminated(pod) {
+		i.monitor.DeletePod(pod.Namespace, pod.Name, string(pod.UID))
+		return
+	}
+	i.monitor.SetPodMeta(podMeta(pod))
+}
+
+func (i *Informer) delete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		klog.V(5).Info("Recovered deleted object ", tombstone.Key, " from tombstone")
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		klog.Error("Pod Informer : error decoding deleted object, invalid type")
+		return
+	}
+	i.monitor.DeletePod(pod.Namespace, pod.Name, string(pod.UID))
+}
+
+// terminated tells whether every container of the pod is gone for good.
+func terminated(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
+
+// podMeta is the metadata of pod for the monitor, without the annotations
+// of KuScale itself.
+func podMeta(pod *corev1.Pod) kumonitor.PodMeta {
+	meta := kumonitor.PodMeta{
+		Namespace:   pod.Namespace,
+		Name:        pod.Name,
+		UID:         string(pod.UID),
+		QOSClass:    string(pod.Status.QOSClass),
+		Annotations: make(map[string]string, len(pod.Annotations)),
+	}
+	if owner := metav1.GetControllerOf(pod); owner != nil {
+		meta.Owner = owner.Kind + "/" + owner.Name
+	}
+	for k, v := range pod.Annotations {
+		if !strings.HasPrefix(k, ownPrefix) {
+			meta.Annotations[k] = v
+		}
+	}
+	return meta
+}
@@ -0,0 +1,193 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package kuledger keeps an append-only ledger of the tokens consumed by every
+// pod, for chargeback. It is one JSON line per pod and tick in a file per UTC
+// day, which is never rotated nor rewritten, so it survives restarts.
+package kuledger
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kumonitor"
+	"k8s.io/klog"
+)
+
+const (
+	filePrefix = "ledger-"
+	fileSuffix = ".jsonl"
+	dayLayout  = "2006-01-02"
+)
+
+// Entry is what a pod did with its tokens since its previous entry.
+type Entry struct {
+	Time      time.Time          `json:"time"`
+	Seconds   float64            `json:"seconds"`
+	Namespace string             `json:"namespace"`
+	Pod       string             `json:"pod"`
+	Reserved  float64            `json:"reserved"` // TokenReservation * Seconds
+	Consumed  map[string]float64 `json:"consumed"` // price * limit * elapsed time, per resource
+	Unused    float64            `json:"unused"`   // reserved but not consumed
+}
+
+// TotalConsumed is the sum of the tokens consumed by every resource.
+func (e *Entry) TotalConsumed() float64 {
+	total := 0.
+	for _, consumed := range e.Consumed {
+		total += consumed
+	}
+	return total
+}
+
+type podState struct {
+	time     int64
+	consumed map[kumonitor.ResourceName]float64
+}
+
+// Ledger writes the entries of the pods of a monitor. It is a kumonitor.Observer.
+type Ledger struct {
+	mu   sync.Mutex
+	dir  string
+	day  string
+	file *os.File
+	w    *bufio.Writer
+	pods map[string]podState
+}
+
+func Open(dir string) (*Ledger, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Ledger{dir: dir, pods: make(map[string]podState)}, nil
+}
+
+func fileName(dir string, day string) string {
+	return filepath.Join(dir, filePrefix+day+fileSuffix)
+}
+
+// writer returns the writer of the file of the day of t, opening it if needed.
+func (l *Ledger) writer(t time.Time) (*bufio.Writer, error) {
+	day := t.UTC().Format(dayLayout)
+	if l.file != nil && day == l.day {
+		return l.w, nil
+	}
+	if err := l.closeFile(); err != nil {
+		klog.Error("Couldn't close ledger file : ", err)
+	}
+	file, err := os.OpenFile(fileName(l.dir, day), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l.day, l.file, l.w = day, file, bufio.NewWriter(file)
+	return l.w, nil
+}
+
+func (l *Ledger) closeFile() error {
+	if l.file == nil {
+		return nil
+	}
+	err := l.w.Flush()
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	l.file, l.w = nil, nil
+	return err
+}
+
+func consumedOf(pi *kumonitor.PodInfo) map[kumonitor.ResourceName]float64 {
+	consumed := make(map[kumonitor.ResourceName]float64)
+	for rn, ri := range pi.RIs {
+		consumed[rn] = ri.ConsumedToken()
+	}
+	return consumed
+}
+
+/*
+Func Name : (l *Ledger) record()
+Objective : 1) Write what pi did with its tokens since its previous entry
+			2) Keep the consumed tokens as the start of the next entry
+*/
+func (l *Ledger) record(now int64, pi *kumonitor.PodInfo) {
+	prev, ok := l.pods[pi.PodName]
+	consumed := consumedOf(pi)
+	l.pods[pi.PodName] = podState{time: now, consumed: consumed}
+	if !ok || now <= prev.time {
+		return
+	}
+
+	entry := Entry{
+		Time:      time.Unix(0, now).UTC(),
+		Seconds:   float64(now-prev.time) / 1e9,
+		Namespace: pi.Namespace,
+		Pod:       pi.PodName,
+		Consumed:  make(map[string]float64),
+	}
+	entry.Reserved = pi.TokenReservation * entry.Seconds
+	for rn, c := range consumed {
+		entry.Consumed[string(rn)] = c - prev.consumed[rn]
+	}
+	if unused := entry.Reserved - entry.TotalConsumed(); unused > 0 {
+		entry.Unused = unused
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		klog.Error("Couldn't encode ledger entry : ", err)
+		return
+	}
+	w, err := l.writer(entry.Time)
+	if err != nil {
+		klog.Error("Couldn't open ledger file : ", err)
+		return
+	}
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		klog.Error("Couldn't write ledger entry : ", err)
+	}
+}
+
+func (l *Ledger) PodAdded(now int64, pi *kumonitor.PodInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pods[pi.PodName] = podState{time: now, consumed: consumedOf(pi)}
+}
+
+func (l *Ledger) PodCompleted(now int64, pi *kumonitor.PodInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.record(now, pi)
+	delete(l.pods, pi.PodName)
+}
+
+func (l *Ledger) Ticked(now int64, m *kumonitor.Monitor) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, pi := range m.RunningPodMap {
+		l.record(now, pi)
+	}
+	if l.w != nil {
+		if err := l.w.Flush(); err != nil {
+			klog.Error("Couldn't write ledger : ", err)
+		}
+	}
+}
+
+func (l *Ledger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.closeFile()
+}
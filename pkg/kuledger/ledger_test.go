@@ -0,0 +1,126 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kuledger
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kumonitor"
+	"github.com/sslab-konkuk/KuScale/pkg/kuprofiler"
+)
+
+// testHost is a node whose pods use half of a core and of a GPU.
+type testHost struct {
+	now   int64
+	start int64
+}
+
+func (h *testHost) Now() int64 { return h.now }
+
+func (h *testHost) ReadUsage(ri *kumonitor.ResourceInfo) (uint64, error) {
+	return uint64(h.now-h.start) / 2, nil
+}
+
+func (h *testHost) WriteLimit(ri *kumonitor.ResourceInfo, limit float64) error { return nil }
+
+// run runs KuScale with the ledger of dir from start for ticks seconds, and
+// returns the tokens consumed by its pod.
+func run(t *testing.T, dir string, start time.Time, ticks int) map[string]float64 {
+	t.Helper()
+	kuprofiler.NewLatencyInfo(false)
+	host := &testHost{now: start.UnixNano(), start: start.UnixNano()}
+	m := kumonitor.NewMonitorWithHost(time.Second, 5, "node", false, 0, host)
+	l, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.AddObserver(l)
+
+	pi := kumonitor.NewPodInfo("pod0", []kumonitor.ResourceName{"CPU", "GPU"})
+	pi.Namespace = "default"
+	pi.TokenReservation = 100
+	m.AddPod(pi)
+	for i := 0; i < ticks; i++ {
+		host.now += int64(time.Second)
+		m.MonitorAndAutoScale()
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	consumed := make(map[string]float64)
+	for rn, ri := range pi.RIs {
+		consumed[string(rn)] = ri.ConsumedToken()
+	}
+	return consumed
+}
+
+func TestReopenedLedgerResumesBalances(t *testing.T) {
+	dir := t.TempDir()
+	// The first run crosses a UTC day, and KuScale is down for a minute
+	start := time.Date(2022, 5, 1, 23, 59, 50, 0, time.UTC)
+	restart := start.Add(11 * time.Second).Add(time.Minute)
+	first := run(t, dir, start, 11)
+	second := run(t, dir, restart, 5)
+
+	files, err := filepath.Glob(filepath.Join(dir, filePrefix+"*"+fileSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("ledger files = %v, want one of each day", files)
+	}
+	entries := 0
+	if err := Read(dir, Query{}, func(Entry) error { entries++; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if entries != 16 {
+		t.Errorf("%d entries, want the 11 of the first run and the 5 of the second", entries)
+	}
+
+	usages, err := Summarize(dir, Query{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usages) != 1 {
+		t.Fatalf("usages = %+v, want the one of pod0", usages)
+	}
+	u := usages[0]
+	if u.Namespace != "default" || u.Pod != "pod0" {
+		t.Errorf("usage of %s/%s, want default/pod0", u.Namespace, u.Pod)
+	}
+	// The time KuScale was down isn't accounted
+	if u.Seconds != 16 || u.Reserved != 1600 {
+		t.Errorf("seconds = %v and reserved = %v, want 16 and 1600", u.Seconds, u.Reserved)
+	}
+	for _, rn := range []string{"CPU", "GPU"} {
+		want := first[rn] + second[rn]
+		if second[rn] <= 0 || math.Abs(u.Consumed[rn]-want) > 1e-9*want {
+			t.Errorf("%s consumed = %v, want %v of the first run and %v of the second", rn, u.Consumed[rn], first[rn], second[rn])
+		}
+	}
+
+	// The entries of the second run are the ones since the restart
+	usages, err = Summarize(dir, Query{From: restart}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usages) != 1 || usages[0].Seconds != 5 {
+		t.Errorf("usages since the restart = %+v, want 5 seconds of pod0", usages)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package kuledger
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Query selects the entries in [From, To). A zero bound or an empty name
+// matches everything.
+type Query struct {
+	From      time.Time
+	To        time.Time
+	Namespace string
+	Pod       string
+}
+
+func (q *Query) match(e *Entry) bool {
+	return (q.From.IsZero() || !e.Time.Before(q.From)) &&
+		(q.To.IsZero() || e.Time.Before(q.To)) &&
+		(q.Namespace == "" || e.Namespace == q.Namespace) &&
+		(q.Pod == "" || e.Pod == q.Pod)
+}
+
+// files returns the ledger files of dir which may have entries of q, in order.
+func (q *Query) files(dir string) ([]string, error) {
+	names, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, info := range names {
+		name := info.Name()
+		if !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		day, err := time.Parse(dayLayout, strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix))
+		if err != nil {
+			continue
+		}
+		if (!q.From.IsZero() && !day.Add(24*time.Hour).After(q.From)) || (!q.To.IsZero() && !day.Before(q.To)) {
+			continue
+		}
+		files = append(files, fileName(dir, day.Format(dayLayout)))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+/*
+Func Name : Read()
+Objective : 1) Call f with every entry of the ledger in dir matching q, in time order
+			2) Skip the last line of a file if it is being written
+*/
+func Read(dir string, q Query, f func(Entry) error) error {
+	files, err := q.files(dir)
+	if err != nil {
+		return err
+	}
+	for _, name := range files {
+		if err := readFile(name, q, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readFile(name string, q Query, f func(Entry) error) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	for line := 1; ; line++ {
+		data, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var entry Entry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+		if q.match(&entry) {
+			if err := f(entry); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Usage is the sum of the entries of a pod, or of a namespace if Pod is empty.
+type Usage struct {
+	Namespace string             `json:"namespace"`
+	Pod       string             `json:"pod,omitempty"`
+	Seconds   float64            `json:"seconds"`
+	Reserved  float64            `json:"reserved"`
+	Consumed  map[string]float64 `json:"consumed"`
+	Unused    float64            `json:"unused"`
+}
+
+// Summarize sums the entries matching q by pod, or by namespace if byNamespace.
+func Summarize(dir string, q Query, byNamespace bool) ([]Usage, error) {
+	usages := make(map[[2]string]*Usage)
+	err := Read(dir, q, func(e Entry) error {
+		key := [2]string{e.Namespace, e.Pod}
+		if byNamespace {
+			key[1] = ""
+		}
+		u, ok := usages[key]
+		if !ok {
+			u = &Usage{Namespace: key[0], Pod: key[1], Consumed: make(map[string]float64)}
+			usages[key] = u
+		}
+		u.Seconds += e.Seconds
+		u.Reserved += e.Reserved
+		u.Unused += e.Unused
+		for rn, consumed := range e.Consumed {
+			u.Consumed[rn] += consumed
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Usage{}
+	for _, u := range usages {
+		result = append(result, *u)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Namespace != result[j].Namespace {
+			return result[i].Namespace < result[j].Namespace
+		}
+		return result[i].Pod < result[j].Pod
+	})
+	return result, nil
+}
+
+// Resources are the columns of the consumed tokens in CSV.
+var Resources = []string{"CPU", "GPU"}
+
+// CSVHeader is the first row of WriteCSV.
+func CSVHeader() []string {
+	header := []string{"time", "namespace", "pod", "seconds", "reserved", "unused"}
+	for _, rn := range Resources {
+		header = append(header, "consumed_"+rn)
+	}
+	return header
+}
+
+// WriteCSV writes the entries matching q as CSV, with a header.
+func WriteCSV(w io.Writer, dir string, q Query) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(CSVHeader()); err != nil {
+		return err
+	}
+	format := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	err := Read(dir, q, func(e Entry) error {
+		row := []string{e.Time.Format(time.RFC3339Nano), e.Namespace, e.Pod,
+			format(e.Seconds), format(e.Reserved), format(e.Unused)}
+		for _, rn := range Resources {
+			row = append(row, format(e.Consumed[rn]))
+		}
+		return cw.Write(row)
+	})
+	cw.Flush()
+	if err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// WriteJSON writes the entries matching q as JSON lines.
+func WriteJSON(w io.Writer, dir string, q Query) error {
+	encoder := json.NewEncoder(w)
+	return Read(dir, q, func(e Entry) error { return encoder.Encode(e) })
+}
@@ -0,0 +1,159 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kumonitor
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func near(a, b float64) bool { return math.Abs(a-b) < 1e-6 }
+
+// checkTokens checks the time pi was last accounted for, and the tokens its
+// resources consumed since, at the limits they had before the tick.
+func checkTokens(t *testing.T, pi *PodInfo, elapsed float64, consumed, limits map[ResourceName]float64) {
+	t.Helper()
+	if !near(pi.lastElaspedTime, elapsed) {
+		t.Errorf("%s was accounted for %vs, want %vs", pi.PodName, pi.lastElaspedTime, elapsed)
+	}
+	for rn, ri := range pi.RIs {
+		want := consumed[rn] + ri.Price()*limits[rn]*elapsed
+		if !near(ri.consumedToken, want) {
+			t.Errorf("%s's %s consumed %v tokens, want %v", pi.PodName, rn, ri.consumedToken, want)
+		}
+	}
+}
+
+// tokens returns the tokens consumed and the limits of the resources of pi.
+func tokens(pi *PodInfo) (consumed, limits map[ResourceName]float64) {
+	consumed, limits = make(map[ResourceName]float64), make(map[ResourceName]float64)
+	for rn, ri := range pi.RIs {
+		consumed[rn], limits[rn] = ri.consumedToken, ri.Limit()
+	}
+	return consumed, limits
+}
+
+func TestFirstTickAccountsFromAddPod(t *testing.T) {
+	fs := newTestFS()
+	m := newTestMonitor(fs, false)
+	pi := newTestPod(t, fs, 0, 300)
+	m.AddPod(pi)
+
+	use(t, fs, pi, 5e8, 2e8)
+	consumed, limits := tokens(pi)
+	tick(m)
+
+	checkTokens(t, pi, 1, consumed, limits)
+	// 300 reserved a second, less 10 of CPU and 10 of GPU at its prices
+	if want := 300 - initLimit*(Price("CPU")+Price("GPU")); !near(pi.TokenQueue, want) {
+		t.Errorf("token queue = %v after the first tick, want %v", pi.TokenQueue, want)
+	}
+	if !near(pi.CPU().Usage(), 50) || !near(pi.GPU().Usage(), 20) {
+		t.Errorf("usages = %v, %v, want 50, 20", pi.CPU().Usage(), pi.GPU().Usage())
+	}
+}
+
+func TestPodAddedMidPeriodIsAccountedFromAddPod(t *testing.T) {
+	fs := newTestFS()
+	m := newTestMonitor(fs, false)
+	early := newTestPod(t, fs, 0, 300)
+	m.AddPod(early)
+	advance(m, 400*time.Millisecond)
+	late := newTestPod(t, fs, 1, 300)
+	m.AddPod(late)
+
+	consumedEarly, limitsEarly := tokens(early)
+	consumedLate, limitsLate := tokens(late)
+	advance(m, 600*time.Millisecond)
+	m.MonitorAndAutoScale()
+
+	checkTokens(t, early, 1, consumedEarly, limitsEarly)
+	checkTokens(t, late, 0.6, consumedLate, limitsLate)
+	if want := 0.6 * (300 - initLimit*(Price("CPU")+Price("GPU"))); !near(late.TokenQueue, want) {
+		t.Errorf("%s's token queue = %v, want %v for 0.6s", late.PodName, late.TokenQueue, want)
+	}
+}
+
+func TestLateTickIsAccountedForItsElapsedTime(t *testing.T) {
+	fs := newTestFS()
+	m := newTestMonitor(fs, false)
+	pi := newTestPod(t, fs, 0, 300)
+	m.AddPod(pi)
+	use(t, fs, pi, 5e8, 2e8)
+	tick(m)
+
+	// The next tick comes 2.5 periods late
+	use(t, fs, pi, 3*5e8, 3*2e8)
+	consumed, limits := tokens(pi)
+	advance(m, 3500*time.Millisecond)
+	m.MonitorAndAutoScale()
+
+	checkTokens(t, pi, 3.5, consumed, limits)
+	if !near(pi.CPU().Usage(), 150./3.5) {
+		t.Errorf("CPU usage = %v, want %v over 3.5s", pi.CPU().Usage(), 150./3.5)
+	}
+}
+
+// waitFor waits for cond while the watchdog checks m, advancing its fake clock
+// by a second at each try if tryAdvance.
+func waitFor(t *testing.T, m *Monitor, tryAdvance bool, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		if tryAdvance {
+			advance(m, time.Second)
+		}
+	}
+}
+
+func TestWatchdogEngagesAndRecoversOnTheMonitorClock(t *testing.T) {
+	fs := newTestFS()
+	m := newTestMonitor(fs, false)
+	pi := newTestPod(t, fs, 0, 300)
+	// The limits set before KuScale, which are the fail-safe ones
+	fs.SetFile(pi.CPU().path+"/cpu.cfs_quota_us", []byte("150000"))
+	fs.SetFile(pi.GPU().path+"/gpu_limit", []byte("400"))
+	m.AddPod(pi)
+	w := NewWatchdog(m, 2*time.Second, nil)
+	use(t, fs, pi, 5e8, 2e8)
+	tick(m)
+
+	stopCh := make(chan string)
+	defer close(stopCh)
+	go w.Run(stopCh)
+
+	// Nothing ticks the monitor, so it stalls after its period and the grace
+	waitFor(t, m, true, w.Engaged)
+	if stalled := m.Now() - m.LastTick(); stalled <= int64(3*time.Second) {
+		t.Errorf("engaged %s after the last tick, before the period and the grace", time.Duration(stalled))
+	}
+	if got := readFile(t, fs, pi.CPU().path+"/cpu.cfs_quota_us"); got != "150000" {
+		t.Errorf("cpu.cfs_quota_us = %s, want the fail-safe 150000", got)
+	}
+	if got := readFile(t, fs, pi.GPU().path+"/gpu_limit"); got != "400" {
+		t.Errorf("gpu_limit = %s, want the fail-safe 400", got)
+	}
+
+	use(t, fs, pi, 5e8, 2e8)
+	m.MonitorAndAutoScale()
+	advance(m, time.Second)
+	waitFor(t, m, false, func() bool { return !w.Engaged() })
+	if n := w.Transitions()[TransitionRecovered]; n != 1 {
+		t.Errorf("recovered %d times, want 1", n)
+	}
+}
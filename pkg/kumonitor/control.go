@@ -0,0 +1,248 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package kumonitor
+
+import (
+	"errors"
+	"fmt"
+
+	"k8s.io/klog"
+)
+
+var (
+	ErrNoSuchPod = errors.New("no such running pod")
+	// ErrNotEnforcing is returned for a limit change while KuScale doesn't
+	// write limits, in monitoring mode or after it restored them.
+	ErrNotEnforcing = errors.New("limits are not written")
+)
+
+// Do runs f with the monitor locked, so that f can read RunningPodMap and the
+// pods in it while the monitor loop runs.
+func (m *Monitor) Do(f func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f()
+}
+
+// Paused tells whether autoscaling is paused for every pod. Call it in Do.
+func (m *Monitor) Paused() bool { return m.paused }
+
+func (m *Monitor) runningPod(namespace, podName string) (*PodInfo, error) {
+	key := PodKey(namespace, podName)
+	pi, ok := m.RunningPodMap[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchPod, key)
+	}
+	return pi, nil
+}
+
+// enforcing returns ErrNotEnforcing, wrapped with why, if no limit should be
+// written.
+func (m *Monitor) enforcing() error {
+	if m.config.monitoringMode {
+		return fmt.Errorf("%w in monitoring mode", ErrNotEnforcing)
+	} else if m.stopped {
+		return fmt.Errorf("%w after they were restored", ErrNotEnforcing)
+	}
+	return nil
+}
+
+// Pinned returns the pinned limit and until when it is pinned, in ns.
+func (ri *ResourceInfo) Pinned() (float64, int64, bool) {
+	return ri.pinnedLimit, ri.pinnedUntil, ri.pinnedUntil != 0
+}
+
+// PinnedByAnnotation tells whether the limit is pinned by the annotation of
+// the pod, until the annotation changes.
+func (ri *ResourceInfo) PinnedByAnnotation() bool { return ri.annotatedLimit != 0 }
+
+func (pi *PodInfo) expirePins(now int64) {
+	for _, ri := range pi.RIs {
+		if ri.pinnedUntil != 0 && now >= ri.pinnedUntil {
+			klog.V(4).Info(pi.PodName, "'s ", ri.name, " limit is not pinned anymore")
+			ri.pinnedLimit, ri.pinnedUntil = 0, 0
+		}
+	}
+}
+
+/*
+Func Name : PinLimit()
+Objective : 1) Set the limit of a resource of a running pod now
+			2) Keep the policy from changing it until the pin expires
+*/
+func (m *Monitor) PinLimit(namespace, podName string, rn ResourceName, limit float64, until int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pi, err := m.runningPod(namespace, podName)
+	if err != nil {
+		return err
+	}
+	ri, ok := pi.RIs[rn]
+	if !ok {
+		return fmt.Errorf("%s has no %s resource", podName, rn)
+	}
+	if limit <= 0 {
+		return fmt.Errorf("limit should be positive, not %v", limit)
+	}
+	if until <= m.host.Now() {
+		return fmt.Errorf("pin of %s's %s already expired", podName, rn)
+	}
+	return m.pinLimit(pi, ri, limit, until, BranchManual)
+}
+
+// pinLimit writes limit and pins it until, as a decision of branch.
+func (m *Monitor) pinLimit(pi *PodInfo, ri *ResourceInfo, limit float64, until int64, branch string) error {
+	if err := m.enforcing(); err != nil {
+		return err
+	}
+	d := newDecision(m.host.Now(), pi, ri, "", 0)
+	d.Branch, d.ProposedLimit = branch, limit
+	if d.Err = m.setLimit(ri, limit); d.Err != nil {
+		d.Outcome, d.NewLimit = OutcomeFailed, ri.limit
+		m.decided(&d)
+		return d.Err
+	}
+	ri.pinnedLimit, ri.pinnedUntil, ri.annotatedLimit = limit, until, 0
+	d.Outcome, d.NewLimit, d.PinnedUntil = OutcomeApplied, ri.limit, until
+	m.decided(&d)
+	klog.V(4).Info(pi.PodName, "'s ", ri.name, " limit is pinned to ", limit)
+	return nil
+}
+
+/*
+Func Name : SetLimit()
+Objective : 1) Write the limit of a resource of a running pod now, as a decision of branch
+			2) Leave it to the policy, which changes it at the next tick, as the watchdog and the reconciler do
+*/
+func (m *Monitor) SetLimit(namespace, podName string, rn ResourceName, limit float64, branch string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pi, err := m.runningPod(namespace, podName)
+	if err != nil {
+		return err
+	}
+	ri, ok := pi.RIs[rn]
+	if !ok {
+		return fmt.Errorf("%s has no %s resource", podName, rn)
+	}
+	if err := m.enforcing(); err != nil {
+		return err
+	}
+	d := newDecision(m.host.Now(), pi, ri, "", 0)
+	d.Branch, d.ProposedLimit, d.Outcome = branch, limit, OutcomeApplied
+	if d.Err = m.setLimit(ri, limit); d.Err != nil {
+		d.Outcome = OutcomeFailed
+	}
+	d.NewLimit = ri.limit
+	m.decided(&d)
+	return d.Err
+}
+
+// unpin gives the limit of ri back to the policy, and tells the observers.
+func (m *Monitor) unpin(pi *PodInfo, ri *ResourceInfo) {
+	ri.pinnedLimit, ri.pinnedUntil, ri.annotatedLimit = 0, 0, 0
+	m.controlled(&Control{Time: m.host.Now(), Pod: pi.PodName, Namespace: pi.Namespace, Action: ControlUnpin, Resource: ri.name})
+}
+
+// Unpin gives the limit of a resource back to the policy.
+func (m *Monitor) Unpin(namespace, podName string, rn ResourceName) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pi, err := m.runningPod(namespace, podName)
+	if err != nil {
+		return err
+	}
+	ri, ok := pi.RIs[rn]
+	if !ok {
+		return fmt.Errorf("%s has no %s resource", podName, rn)
+	}
+	m.unpin(pi, ri)
+	return nil
+}
+
+func (m *Monitor) SetTokenReservation(namespace, podName string, tokenReservation float64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pi, err := m.runningPod(namespace, podName)
+	if err != nil {
+		return err
+	}
+	if tokenReservation < 0 {
+		return fmt.Errorf("token reservation should not be negative, not %v", tokenReservation)
+	}
+	klog.V(4).Info(pi.Key(), "'s TokenReservation is changed from ", pi.TokenReservation, " to ", tokenReservation)
+	pi.TokenReservation = tokenReservation
+	m.controlled(&Control{Time: m.host.Now(), Pod: pi.PodName, Namespace: pi.Namespace, Action: ControlReservation,
+		TokenReservation: tokenReservation})
+	return nil
+}
+
+// SetPaused pauses or resumes autoscaling of a pod, or of every pod when
+// podName is empty. Paused pods keep their limits, and their usages are
+// still monitored.
+func (m *Monitor) SetPaused(namespace, podName string, paused bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := &Control{Time: m.host.Now(), Action: ControlResume}
+	if paused {
+		c.Action = ControlPause
+	}
+	if podName == "" {
+		m.paused = paused
+		klog.V(4).Info("Autoscaling paused : ", paused)
+		m.controlled(c)
+		return nil
+	}
+	pi, err := m.runningPod(namespace, podName)
+	if err != nil {
+		return err
+	}
+	pi.paused = paused
+	klog.V(4).Info(pi.Key(), "'s autoscaling paused : ", paused)
+	c.Pod, c.Namespace = pi.PodName, pi.Namespace
+	m.controlled(c)
+	return nil
+}
+
+// SetDecisionTracing turns logging every decision of the policy on or off.
+func (m *Monitor) SetDecisionTracing(on bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.decisionTracing = on
+	klog.Info("Decision tracing is ", map[bool]string{true: "on", false: "off"}[on])
+}
+
+// DecisionTracing tells whether every decision is logged. Call it in Do.
+func (m *Monitor) DecisionTracing() bool { return m.decisionTracing }
+
+// traceDecision logs the inputs of the policy for pi and the limits it proposed,
+// before they are clamped or pinned by planNextLimit.
+func (m *Monitor) traceDecision(pi *PodInfo) {
+	msg := fmt.Sprintf("Decision of %s by %s %v (%s) : tokenQueue %.1f, tokenReservation %.1f, availableToken %.1f",
+		pi.PodName, m.policy.Name(), m.policy.Params(), pi.branch, pi.TokenQueue, pi.TokenReservation, pi.availableToken)
+	for _, rn := range pi.RNs {
+		ri := pi.RIs[rn]
+		msg += fmt.Sprintf(" | %s usage %.1f, avgUsage %.1f, weight %.2f, limit %.1f -> %.1f",
+			rn, ri.usage, ri.avgUsage, ri.dynamicWeight, ri.limit, ri.nextLimit)
+		if ri.pinnedUntil != 0 {
+			msg += fmt.Sprintf(" (pinned to %.1f)", ri.pinnedLimit)
+		}
+	}
+	klog.Info(msg)
+}
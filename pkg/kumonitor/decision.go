@@ -0,0 +1,125 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kumonitor
+
+// Branches of the policies, telling how a proposed limit was computed.
+const (
+	BranchTokenEnough  = "tokenEnough"  // getNextLimit : the tokens cover usage plus the weighted share
+	BranchTokenLimited = "tokenLimited" // getNextLimit : the limits are solved under the token condition
+	BranchStatic       = "static"       // StaticPolicy : even split of the token reservation
+	BranchManual       = "manual"       // PinLimit from the control API
+	BranchAnnotation   = "annotation"   // kuscale/cpu_limit or kuscale/gpu_limit of the pod
+)
+
+// Outcomes of a decision.
+const (
+	OutcomeApplied = "applied" // the proposed limit was written
+	OutcomeClamped = "clamped" // the proposed limit was raised to the minimum limit
+	OutcomePinned  = "pinned"  // the proposed limit was suppressed by a pinned limit
+	OutcomeFailed  = "failed"  // writing the limit failed
+)
+
+// Decision is a limit change of a resource of a pod, with the inputs the
+// policy decided it from.
+type Decision struct {
+	Time      int64
+	Pod       string
+	Namespace string
+	Resource  ResourceName
+	Policy    string
+	Branch    string
+	Outcome   string
+	Err       error
+
+	OldLimit      float64
+	ProposedLimit float64 // by the policy, before the minimum and the pin
+	NewLimit      float64
+	PinnedUntil   int64 // of a limit pinned by the decision, in ns
+
+	Usage            float64
+	AvgUsage         float64
+	DynamicWeight    float64
+	Price            float64
+	Period           float64 // seconds the limit is for, 0 for a manual one
+	AvailableToken   float64
+	TokenQueue       float64
+	TokenReservation float64
+}
+
+// DecisionObserver is an Observer which is also told every decision on a
+// limit, whether it changes the limit or not. It is called with the monitor
+// locked, like Observer.
+type DecisionObserver interface {
+	Observer
+	Decided(d *Decision)
+}
+
+// Changed tells whether the limit changed, or a change was suppressed.
+func (d *Decision) Changed() bool {
+	return d.NewLimit != d.OldLimit || d.ProposedLimit != d.OldLimit
+}
+
+func newDecision(now int64, pi *PodInfo, ri *ResourceInfo, policy string, period float64) Decision {
+	return Decision{
+		Time:             now,
+		Pod:              pi.PodName,
+		Namespace:        pi.Namespace,
+		Resource:         ri.name,
+		Policy:           policy,
+		Branch:           pi.branch,
+		OldLimit:         ri.limit,
+		ProposedLimit:    ri.nextLimit,
+		Usage:            ri.usage,
+		AvgUsage:         ri.avgUsage,
+		DynamicWeight:    ri.dynamicWeight,
+		Price:            ri.price,
+		Period:           period,
+		AvailableToken:   pi.availableToken,
+		TokenQueue:       pi.TokenQueue,
+		TokenReservation: pi.TokenReservation,
+	}
+}
+
+// decided tells the decision observers about d.
+func (m *Monitor) decided(d *Decision) {
+	for _, o := range m.observers {
+		if do, ok := o.(DecisionObserver); ok {
+			do.Decided(d)
+		}
+	}
+}
+
+/*
+Func Name : EnoughReservation()
+Objective : 1) Return the least token reservation with which getNextLimit gives every resource
+			   its usage plus its weighted share, for the decisions of a pod at a tick
+			2) Return false when no reservation is enough with these weights
+*/
+func EnoughReservation(ds []Decision) (float64, bool) {
+	// availableToken = reservation * period, and the limits should spend no more :
+	// reservation * (1 - period * sum(price^2 / (2 * weight))) >= sum(price * usage)
+	spent, share := 0., 0.
+	for _, d := range ds {
+		if d.DynamicWeight <= 0 || d.Period <= 0 {
+			return 0, false
+		}
+		spent += d.Price * d.Usage
+		share += d.Period * d.Price * d.Price / (2 * d.DynamicWeight)
+	}
+	if share >= 1 {
+		return 0, false
+	}
+	return spent / (1 - share), true
+}
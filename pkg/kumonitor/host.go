@@ -0,0 +1,299 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kumonitor
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kuclock"
+	"github.com/sslab-konkuk/KuScale/pkg/kufs"
+	"github.com/sslab-konkuk/KuScale/pkg/kugpu"
+	"k8s.io/klog"
+)
+
+// Host is where the monitor reads accumulated usage from and writes limits to.
+// The default host talks to cgroup and the ku-gpu-layer module through a
+// kufs.FS, and the simulator replaces it with workload models.
+type Host interface {
+	// Now returns the current time in nanoseconds.
+	Now() int64
+	// ReadUsage returns the accumulated usage of ri in nanoseconds. An error
+	// matching kufs.ErrNotExist means that the pod has gone away.
+	ReadUsage(ri *ResourceInfo) (acctUsage uint64, err error)
+	// WriteLimit applies limit to ri.
+	WriteLimit(ri *ResourceInfo, limit float64) error
+}
+
+// LimitReader is a Host which can read the limit of ri as it is now, so that
+// the limit set before KuScale can be restored on shutdown.
+type LimitReader interface {
+	ReadLimit(ri *ResourceInfo) (float64, error)
+}
+
+// BatchWriter is a Host which applies the limits of some resources of every
+// pod in one transaction, so that what enforces them never sees half a tick.
+type BatchWriter interface {
+	// Batched tells whether the limits of rn go through WriteBatch.
+	Batched(rn ResourceName) bool
+	// WriteBatch writes every limit with its guaranteed request on at most
+	// workers goroutines, and makes them effective at once. The writes which
+	// can't be validated are left out with their error in errs. If one of the
+	// others fails, those already written are rolled back and err is returned.
+	WriteBatch(batch []BatchWrite, workers int) (errs []error, err error)
+}
+
+// BatchWrite is a limit of a BatchWriter, and the share of it guaranteed to ri.
+type BatchWrite struct {
+	RI      *ResourceInfo
+	Limit   float64
+	Request float64
+}
+
+// RuntimeUpdater applies the CPU limit of a container through the update API
+// of its runtime, which then knows it and keeps it across updates.
+type RuntimeUpdater interface {
+	Name() string
+	// UpdateCPU sets the CFS period and quota in us of container, -1 being no quota.
+	UpdateCPU(ctx context.Context, container string, period, quota int64) error
+}
+
+// Unlimited is the limit of a resource without any quota.
+const Unlimited = -1.
+
+// cfsPeriod is the CFS period in us of the CPU limits, where 100 percent is a quota of 100ms.
+const cfsPeriod = 100000
+
+// runtimeTimeout bounds a CPU limit update through the runtime, and the
+// cgroup files are written for runtimeBackoff after it failed.
+const (
+	runtimeTimeout = 2 * time.Second
+	runtimeBackoff = 30 * time.Second
+)
+
+var defaultHost Host = NewFSHost(&kufs.OSFS{}, kufs.DefaultRoots)
+
+type fsHost struct {
+	kuclock.Clock
+	fs      kufs.FS
+	roots   kufs.Roots
+	gpu     *kugpu.Module
+	runtime RuntimeUpdater // writes the CPU limits of the containers, if not nil
+	retryAt int64          // atomic, when the runtime is tried again after it failed
+}
+
+// NewFSHost returns the host which reads and writes cgroup and ku-gpu-layer
+// files in fs. With a kufs.MemFS, the monitor runs without root.
+func NewFSHost(fs kufs.FS, roots kufs.Roots) Host {
+	return NewFSHostWithClock(fs, roots, kuclock.Real)
+}
+
+// NewFSHostWithClock is NewFSHost telling the time of clock, which also ticks
+// the monitor of the host.
+func NewFSHostWithClock(fs kufs.FS, roots kufs.Roots, clock kuclock.Clock) Host {
+	return &fsHost{Clock: clock, fs: fs, roots: roots, gpu: kugpu.New(fs, roots.GPU)}
+}
+
+func (h *fsHost) ReadUsage(ri *ResourceInfo) (uint64, error) {
+	switch ri.name {
+	case "CPU":
+		return ReadCPUStat(h.fs, ri.path)
+	case "GPU":
+		return GetFileUint(h.fs, ri.usagePath)
+	}
+	return 0, fmt.Errorf("unknown resource %s", ri.name)
+}
+
+// ReadLimit reads cpu.cfs_quota_us and gpu_limit, where -1 and 0 are no quota.
+func (h *fsHost) ReadLimit(ri *ResourceInfo) (float64, error) {
+	switch ri.name {
+	case "CPU":
+		quota, err := GetFileParamUint(h.fs, ri.path, "/cpu.cfs_quota_us")
+		if err != nil {
+			return 0, err
+		}
+		if quota == 0 {
+			return Unlimited, nil
+		}
+		return float64(quota) / 1000, nil
+	case "GPU":
+		id, err := h.gpu.IDOf(ri.path)
+		if err != nil {
+			return 0, err
+		}
+		quota, err := h.gpu.Limit(id)
+		if err != nil {
+			return 0, err
+		}
+		if quota == 0 {
+			return Unlimited, nil
+		}
+		return float64(quota) / 10, nil
+	}
+	return 0, fmt.Errorf("unknown resource %s", ri.name)
+}
+
+// Enforced is what ReadLimit returns once limit is written, in whole percent.
+func (h *fsHost) Enforced(ri *ResourceInfo, limit float64) float64 {
+	switch ri.name {
+	case "CPU":
+		if limit < 0 {
+			return Unlimited
+		}
+		return float64(uint64(limit))
+	case "GPU":
+		return float64(gpuQuota(limit)) / 10
+	}
+	return limit
+}
+
+func (h *fsHost) WriteLimit(ri *ResourceInfo, limit float64) error {
+	switch ri.name {
+	case "CPU":
+		if h.runtime != nil && ri.container != "" && h.Now() >= atomic.LoadInt64(&h.retryAt) {
+			err := h.writeRuntimeCPU(ri, limit)
+			if err == nil {
+				return nil
+			}
+			atomic.StoreInt64(&h.retryAt, h.Now()+int64(runtimeBackoff))
+			klog.Warning("Couldn't set the CPU limit of ", ri.container, " through ", h.runtime.Name(),
+				", writing the cgroup files for ", runtimeBackoff, " : ", err)
+		}
+		if limit < 0 {
+			return h.fs.WriteFile(kufs.Join(ri.path, "/cpu.cfs_quota_us"), []byte("-1"))
+		}
+		return setFileUint(h.fs, uint64(limit)*1000, ri.path, "/cpu.cfs_quota_us")
+	case "GPU":
+		// A batch of one, rolled back if resource_conf can't be reloaded
+		errs, err := h.WriteBatch([]BatchWrite{{RI: ri, Limit: limit, Request: limit}}, 1)
+		if err != nil {
+			return err
+		}
+		return errs[0]
+	}
+	return fmt.Errorf("unknown resource %s", ri.name)
+}
+
+func (h *fsHost) writeRuntimeCPU(ri *ResourceInfo, limit float64) error {
+	quota := int64(-1)
+	if limit >= 0 {
+		quota = int64(uint64(limit) * 1000)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), runtimeTimeout)
+	defer cancel()
+	return h.runtime.UpdateCPU(ctx, ri.container, cfsPeriod, quota)
+}
+
+// gpuQuota is limit in the unit of gpu_limit. A vGPU can't get more than the
+// whole GPU.
+func gpuQuota(limit float64) uint64 {
+	quota := uint64(limit) * 10
+	if limit < 0 || quota > kugpu.MaxQuota {
+		quota = kugpu.MaxQuota
+	}
+	return quota
+}
+
+func (h *fsHost) Batched(rn ResourceName) bool { return rn == "GPU" }
+
+// gpuQuotas are the gpu_limit and gpu_request of an ID.
+type gpuQuotas struct {
+	id             kugpu.ID
+	limit, request uint64
+}
+
+func (h *fsHost) writeQuotas(q gpuQuotas) error {
+	if err := h.gpu.SetLimit(q.id, q.limit); err != nil {
+		return err
+	}
+	return h.gpu.SetRequest(q.id, q.request)
+}
+
+/*
+Func Name : (h *fsHost) WriteBatch()
+Objective : 1) Check the IDs of the GPU limits, and read their quotas to roll back to
+			2) Write every gpu_limit and gpu_request, then reload resource_conf once,
+			   or write the read quotas back when a write or the reload fails
+*/
+func (h *fsHost) WriteBatch(batch []BatchWrite, workers int) ([]error, error) {
+	errs := make([]error, len(batch))
+	news := make([]gpuQuotas, len(batch))
+	olds := make([]gpuQuotas, len(batch))
+	parallel(workers, len(batch), func(i int) {
+		b := batch[i]
+		if b.RI.name != "GPU" {
+			errs[i] = fmt.Errorf("%s limits are not batched", b.RI.name)
+			return
+		}
+		id, err := h.gpu.IDOf(b.RI.path)
+		if err != nil {
+			errs[i] = err
+			return
+		}
+		old := gpuQuotas{id: id}
+		if old.limit, err = h.gpu.Limit(id); err != nil {
+			errs[i] = err
+			return
+		}
+		if old.request, err = h.gpu.Request(id); err != nil {
+			errs[i] = err
+			return
+		}
+		olds[i] = old
+		news[i] = gpuQuotas{id: id, limit: gpuQuota(b.Limit), request: gpuQuota(b.Request)}
+		if news[i].request > news[i].limit {
+			news[i].request = news[i].limit
+		}
+	})
+
+	var valid []int
+	for i := range batch {
+		if errs[i] == nil {
+			valid = append(valid, i)
+		}
+	}
+	if len(valid) == 0 {
+		return errs, nil
+	}
+	// Rolled back even if only gpu_limit was written
+	rollback := func() {
+		parallel(workers, len(valid), func(j int) {
+			i := valid[j]
+			if err := h.writeQuotas(olds[i]); err != nil {
+				klog.Error("Couldn't roll the GPU quotas of ", batch[i].RI.path, " back : ", err)
+			}
+		})
+	}
+	failed := make([]error, len(valid))
+	parallel(workers, len(valid), func(j int) {
+		i := valid[j]
+		if err := h.writeQuotas(news[i]); err != nil {
+			failed[j] = fmt.Errorf("writing the GPU quotas of %s : %w", batch[i].RI.path, err)
+		}
+	})
+	for _, err := range failed {
+		if err != nil {
+			rollback()
+			return errs, err
+		}
+	}
+	if err := h.gpu.ReloadResourceConf(); err != nil {
+		rollback()
+		return errs, fmt.Errorf("reloading resource_conf : %w", err)
+	}
+	return errs, nil
+}
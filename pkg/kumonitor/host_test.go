@@ -0,0 +1,167 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kumonitor
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kufs"
+)
+
+// reloadFS is a MemFS which counts the reloads of resource_conf, and fails
+// them while fail is set.
+type reloadFS struct {
+	*kufs.MemFS
+	mu      sync.Mutex
+	reloads int
+	fail    bool
+}
+
+func newReloadFS() *reloadFS { return &reloadFS{MemFS: newTestFS()} }
+
+func (fs *reloadFS) WriteFile(path string, data []byte) error {
+	if path == kufs.Join(testRoots.GPU, "gemini/resource_conf") {
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+		if fs.fail {
+			return errors.New("gemini is gone")
+		}
+		fs.reloads++
+	}
+	return fs.MemFS.WriteFile(path, data)
+}
+
+func (fs *reloadFS) setFail(fail bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.fail = fail
+}
+
+func (fs *reloadFS) Reloads() int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.reloads
+}
+
+// checkQuotas checks gpu_limit and gpu_request of pi.
+func checkQuotas(t *testing.T, fs kufs.FS, pi *PodInfo, limit, request string) {
+	t.Helper()
+	if got := readFile(t, fs, pi.GPU().path+"/gpu_limit"); got != limit {
+		t.Errorf("%s gpu_limit = %s, want %s", pi.PodName, got, limit)
+	}
+	if got := readFile(t, fs, pi.GPU().path+"/gpu_request"); got != request {
+		t.Errorf("%s gpu_request = %s, want %s", pi.PodName, got, request)
+	}
+}
+
+func TestWriteBatchRollsBackWhenReloadFails(t *testing.T) {
+	fs := newReloadFS()
+	pods := []*PodInfo{newTestPod(t, fs.MemFS, 0, 100), newTestPod(t, fs.MemFS, 1, 100)}
+	fs.SetFile(pods[0].GPU().path+"/gpu_limit", []byte("300"))
+	fs.SetFile(pods[0].GPU().path+"/gpu_request", []byte("200"))
+	fs.SetFile(pods[1].GPU().path+"/gpu_limit", []byte("500"))
+	fs.SetFile(pods[1].GPU().path+"/gpu_request", []byte("500"))
+	bw := NewFSHost(fs, testRoots).(BatchWriter)
+	batch := []BatchWrite{{RI: pods[0].GPU(), Limit: 60, Request: 60}, {RI: pods[1].GPU(), Limit: 80, Request: 40}}
+
+	fs.setFail(true)
+	if _, err := bw.WriteBatch(batch, 2); err == nil {
+		t.Fatal("WriteBatch succeeded without reloading resource_conf")
+	}
+	checkQuotas(t, fs, pods[0], "300", "200")
+	checkQuotas(t, fs, pods[1], "500", "500")
+
+	fs.setFail(false)
+	errs, err := bw.WriteBatch(batch, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("write %d : %v", i, err)
+		}
+	}
+	checkQuotas(t, fs, pods[0], "600", "600")
+	checkQuotas(t, fs, pods[1], "800", "400")
+	if fs.Reloads() != 1 {
+		t.Errorf("%d reloads of resource_conf, want 1", fs.Reloads())
+	}
+}
+
+func TestWriteBatchLeavesOutInvalidWrites(t *testing.T) {
+	fs := newReloadFS()
+	pi := newTestPod(t, fs.MemFS, 0, 100)
+	gone := newTestPod(t, fs.MemFS, 1, 100)
+	fs.RemoveAll(gone.GPU().path)
+	bw := NewFSHost(fs, testRoots).(BatchWriter)
+
+	errs, err := bw.WriteBatch([]BatchWrite{{RI: pi.GPU(), Limit: 50, Request: 50}, {RI: gone.GPU(), Limit: 50, Request: 50}}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs[0] != nil || !kufs.IsNotExist(errs[1]) {
+		t.Errorf("errs = %v, want only the ID which is gone not to exist", errs)
+	}
+	checkQuotas(t, fs, pi, "500", "500")
+}
+
+func TestSingleGPULimitsReloadOnce(t *testing.T) {
+	fs := newReloadFS()
+	m := newTestMonitor(fs, false)
+	var pods []*PodInfo
+	for id := 0; id < 3; id++ {
+		pi := newTestPod(t, fs.MemFS, id, 100)
+		m.AddPod(pi)
+		pods = append(pods, pi)
+	}
+	if fs.Reloads() != 3 {
+		t.Errorf("%d reloads adding 3 pods, want 3", fs.Reloads())
+	}
+
+	if err := m.PinLimit("", "pod1", "GPU", 40, m.Now()+int64(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	checkQuotas(t, fs, pods[1], "400", "400")
+	if fs.Reloads() != 4 {
+		t.Errorf("%d reloads after a pin, want 4", fs.Reloads())
+	}
+
+	fs.setFail(true)
+	if err := m.SetLimit("", "pod2", "GPU", 70, "test"); err == nil {
+		t.Error("SetLimit succeeded without reloading resource_conf")
+	}
+	checkQuotas(t, fs, pods[2], "100", "100")
+	if limit := pods[2].GPU().Limit(); limit != initLimit {
+		t.Errorf("pod2's GPU limit = %v after a failed reload, want %v", limit, initLimit)
+	}
+
+	fs.setFail(false)
+	restored, err := m.RestoreLimits(map[ResourceName]float64{"CPU": 100, "GPU": 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored != 6 {
+		t.Errorf("%d limits restored, want 6", restored)
+	}
+	if fs.Reloads() != 5 {
+		t.Errorf("%d reloads after restoring, want 5", fs.Reloads())
+	}
+	for _, pi := range pods {
+		checkQuotas(t, fs, pi, "1000", "1000")
+	}
+}
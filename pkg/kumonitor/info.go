@@ -15,8 +15,9 @@
 package kumonitor
 
 import (
-	"time"
+	"sync"
 
+	"github.com/sslab-konkuk/KuScale/pkg/kufs"
 	"k8s.io/klog"
 )
 
@@ -25,6 +26,25 @@ const miliGPU = 10
 
 // const miliRX = 80000 // miliNetworkBits = 10KB
 
+var (
+	pricesMu sync.Mutex
+	prices   = map[ResourceName]float64{"CPU": 1, "GPU": 3}
+)
+
+// SetPrice sets the price of the resource for the pods made from now on.
+// The running pods keep their price.
+func SetPrice(name ResourceName, price float64) {
+	pricesMu.Lock()
+	defer pricesMu.Unlock()
+	prices[name] = price
+}
+
+func Price(name ResourceName) float64 {
+	pricesMu.Lock()
+	defer pricesMu.Unlock()
+	return prices[name]
+}
+
 type ResourceName string
 type AcctUsageAndTime struct {
 	timeStamp uint64
@@ -35,11 +55,14 @@ type ResourceInfo struct {
 	name      ResourceName
 	path      string
 	usagePath string
+	container string // ID in the runtime, whose update API may write the limit
 	miliScale int
 	price     float64
+	host      Host
 
 	/* Limit */
-	initLimit float64
+	initLimit float64 // set before KuScale, restored on shutdown
+	hasInit   bool
 	limit     float64
 	nextLimit float64
 
@@ -48,35 +71,66 @@ type ResourceInfo struct {
 	usage            float64
 	avgUsage         float64 // Weighted Average : (7*ri.avgUsage + ri.usage) / 8
 	dynamicWeight    float64 // Dynamic Weight for this resource 	: price / {avgUsage / sum of avgUsage}
+	consumedToken    float64 // price * limit * elapsed time, since the pod started
+
+	/* Manual Override */
+	pinnedLimit    float64
+	pinnedUntil    int64   // 0 when not pinned
+	annotatedLimit float64 // pinned by the annotation of the pod, 0 if not
+
+	writes *writeTracker // of the monitor, watched by the watchdog
+
+	/* Reconciliation */
+	enforcedLimit float64 // read back at the last reconciliation
+	drifted       bool    // enforcedLimit isn't limit
+	resizedLimit  float64 // last resized in place through Kubernetes, 0 if never
+	overLimit     int     // reconciliations in a row with the usage over the limit
+	broken        bool    // the usage stays over the limit
 }
 
 func (ri *ResourceInfo) Init(name ResourceName, scale int, price float64) {
 
 	ri.name, ri.miliScale, ri.price = name, scale, price
 	ri.limit, ri.usage, ri.avgUsage, ri.avgUsage = 0, 0, 0, 0
-	ri.acctUsageAndTime = append(ri.acctUsageAndTime, AcctUsageAndTime{timeStamp: uint64(time.Now().UnixNano()), acctUsage: 0})
+	ri.host = defaultHost
+	ri.acctUsageAndTime = append(ri.acctUsageAndTime, AcctUsageAndTime{timeStamp: uint64(ri.host.Now()), acctUsage: 0})
 }
 
+func (ri *ResourceInfo) Name() ResourceName { return ri.name }
+func (ri *ResourceInfo) Path() string       { return ri.path }
+
 func (ri *ResourceInfo) Limit() float64         { return ri.limit }
 func (ri *ResourceInfo) Usage() float64         { return ri.usage }
 func (ri *ResourceInfo) AvgUsage() float64      { return ri.avgUsage }
 func (ri *ResourceInfo) DynamicWeight() float64 { return ri.dynamicWeight }
 func (ri *ResourceInfo) Price() float64         { return ri.price }
+func (ri *ResourceInfo) NextLimit() float64     { return ri.nextLimit }
+func (ri *ResourceInfo) ConsumedToken() float64 { return ri.consumedToken }
+
+// Enforced returns the limit in effect read back at the last reconciliation,
+// whether it drifted from Limit, and whether the usage stays over the limit.
+func (ri *ResourceInfo) Enforced() (limit float64, drifted, broken bool) {
+	return ri.enforcedLimit, ri.drifted, ri.broken
+}
+
+// AcctUsage returns the last accumulated usage read from the host.
+func (ri *ResourceInfo) AcctUsage() uint64 {
+	return ri.acctUsageAndTime[len(ri.acctUsageAndTime)-1].acctUsage
+}
 
-func (ri *ResourceInfo) SetLimit(limit float64) {
+func (ri *ResourceInfo) SetLimit(limit float64) error {
 	// klog.V(5).Info("Set ", ri.name, ": ", limit)
-	switch ri.name {
-	case "CPU":
-		setFileUint(uint64(limit)*1000, ri.path, "/cpu.cfs_quota_us")
-		ri.limit = limit
-		return
-	case "GPU":
-		setFileUint(uint64(limit)*10, ri.path, "/gpu_limit")
-		setFileUint(uint64(limit)*10, ri.path, "/gpu_request")
-		UpdateGemini()
-		ri.limit = limit
-		return
+	if ri.writes != nil {
+		ri.writes.begin(ri, ri.host.Now())
+		defer ri.writes.end(ri)
+	}
+	err := ri.host.WriteLimit(ri, limit)
+	if err != nil {
+		klog.Info("Couldn't set ", ri.name, " limit to ", limit, " : ", err)
+		return err
 	}
+	ri.limit = limit
+	return nil
 }
 
 /*
@@ -88,23 +142,19 @@ Func Name : (ri *ResourceInfo) updateUsage() bool
 */
 func (ri *ResourceInfo) updateUsage() bool {
 
-	timeStamp := uint64(time.Now().UnixNano())
-	var acctUsage uint64
-	var completed bool
-	switch ri.name {
-	case "CPU":
-		acctUsage, completed = ReadCPUStat(ri.path)
-		if completed {
-			return true
-		}
-	case "GPU":
-		acctUsage, completed = GetFileUint(ri.usagePath)
-		if completed {
-			return true
-		}
+	timeStamp := uint64(ri.host.Now())
+	acctUsage, err := ri.host.ReadUsage(ri)
+	if kufs.IsNotExist(err) {
+		return true
+	} else if err != nil {
+		klog.V(2).Info("Couldn't read ", ri.name, " usage : ", err)
+		return false
 	}
-	
+
 	prev := ri.acctUsageAndTime[len(ri.acctUsageAndTime)-1]
+	if timeStamp <= prev.timeStamp {
+		return false
+	}
 
 	ri.usage = float64(acctUsage-prev.acctUsage) * 100. / float64(timeStamp-prev.timeStamp)
 	ri.avgUsage = (7*ri.avgUsage + ri.usage) / 8
@@ -125,24 +175,72 @@ const (
 // Pod Info are managed by KuScale
 type PodInfo struct {
 	PodName   string
+	Namespace string
+	Container string
 	ID        string
 	dockerID  string
 	imageName string
 
+	/* From the pod informer, empty without it */
+	UID         string
+	Owner       string // Kind/Name of the controller of the pod
+	QOSClass    string
+	Annotations map[string]string
+
 	status         PodStatus
 	reservedToken  uint64
 	totalToken     float64
 	expectedToken  float64
 	availableToken float64
 
-	lastUpdatedTime  int64
-	lastElaspedTime  float64
+	lastUpdatedTime  int64   // of the tokens, by the host's monotonic clock
+	lastElaspedTime  float64 // seconds since the tokens were updated before
 	TokenQueue       float64
 	TokenReservation float64
-	UpdatedCount     int64 // Update Count from KuScale
+	UpdatedCount     int64  // Update Count from KuScale
+	paused           bool   // autoscaling is paused for this pod
+	branch           string // how the policy computed the last next limits
+	failSafe         bool   // on fail-safe limits for stale usage readings
 
 	RNs []ResourceName
 	RIs map[ResourceName]*ResourceInfo
+
+	host Host
+}
+
+// SetHost makes every resource of the pod read usage from and write limits to
+// host, starting the usage accounting over at host's current time.
+func (pi *PodInfo) SetHost(host Host) {
+	pi.host = host
+	for _, ri := range pi.RIs {
+		ri.host = host
+		ri.acctUsageAndTime = []AcctUsageAndTime{{timeStamp: uint64(host.Now()), acctUsage: 0}}
+	}
+}
+
+// PodKey is the key of a pod in RunningPodMap and CompletedPodMap, its
+// namespace/name, or its name when it has no namespace.
+func PodKey(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "/" + name
+}
+
+func (pi *PodInfo) Key() string { return PodKey(pi.Namespace, pi.PodName) }
+
+func (pi *PodInfo) Status() PodStatus       { return pi.status }
+func (pi *PodInfo) Paused() bool            { return pi.paused }
+func (pi *PodInfo) FailSafe() bool          { return pi.failSafe }
+func (pi *PodInfo) AvailableToken() float64 { return pi.availableToken }
+
+// ConsumedToken is the sum of the tokens consumed by the resources of the pod.
+func (pi *PodInfo) ConsumedToken() float64 {
+	consumed := 0.
+	for _, ri := range pi.RIs {
+		consumed += ri.consumedToken
+	}
+	return consumed
 }
 
 func (pi *PodInfo) CPU() *ResourceInfo {
@@ -158,6 +256,7 @@ func NewPodInfo(podName string, RNs []ResourceName) *PodInfo {
 	podInfo := PodInfo{
 		PodName: podName,
 		status:  PodInitializing,
+		host:    defaultHost,
 	}
 
 	podInfo.RNs = RNs
@@ -166,9 +265,9 @@ func NewPodInfo(podName string, RNs []ResourceName) *PodInfo {
 		ri := ResourceInfo{name: name}
 		switch name {
 		case "CPU":
-			ri.Init(name, miliCPU, 1)
+			ri.Init(name, miliCPU, Price(name))
 		case "GPU":
-			ri.Init(name, miliGPU, 3)
+			ri.Init(name, miliGPU, Price(name))
 		}
 		podInfo.RIs[name] = &ri
 	}
@@ -177,6 +276,16 @@ func NewPodInfo(podName string, RNs []ResourceName) *PodInfo {
 	return &podInfo
 }
 
+// NewPodInfoWithPaths makes a pod with the cgroup directory of its container
+// and its ku-gpu-layer ID directory.
+func NewPodInfoWithPaths(podName, cpuPath, gpuPath string) *PodInfo {
+	podInfo := NewPodInfo(podName, []ResourceName{"CPU", "GPU"})
+	podInfo.CPU().path, podInfo.GPU().path = cpuPath, gpuPath
+	podInfo.CPU().usagePath = cpuPath + "/cpuacct.usage"
+	podInfo.GPU().usagePath = gpuPath + "/total_runtime"
+	return podInfo
+}
+
 /*
 Func Name : (pi *PodInfo) UpdatePodUsage()
 	Objective :
@@ -209,6 +318,7 @@ func (pi *PodInfo) UpdateTokenQueue() {
 	for _, ri := range pi.RIs {
 		limit, price := ri.Limit(), ri.Price()
 		TokenQueue = TokenQueue - price*limit*pi.lastElaspedTime
+		ri.consumedToken += price * limit * pi.lastElaspedTime
 	}
 	if TokenQueue < 0 {
 		TokenQueue = 0
@@ -261,23 +371,58 @@ func (pi *PodInfo) UpdateDynamicWeight(staticV float64) {
 
 }
 
-func (pi *PodInfo) SetInitLimit() {
+// readInitLimits keeps the limits set before KuScale, when host can read them.
+func (pi *PodInfo) readInitLimits() {
+	reader, ok := pi.host.(LimitReader)
+	if !ok {
+		return
+	}
 	for _, ri := range pi.RIs {
-		ri.SetLimit(10)
+		limit, err := reader.ReadLimit(ri)
+		if err != nil {
+			klog.V(4).Info("Couldn't read ", pi.PodName, "'s ", ri.name, " limit before KuScale : ", err)
+			continue
+		}
+		ri.initLimit, ri.hasInit = limit, true
+	}
+}
+
+// safeLimit is the limit set before KuScale, or else safe[name], or else no limit.
+func (ri *ResourceInfo) safeLimit(safe map[ResourceName]float64) float64 {
+	if ri.hasInit {
+		return ri.initLimit
+	}
+	if limit, ok := safe[ri.name]; ok && limit > 0 {
+		return limit
 	}
+	return Unlimited
 }
 
-func (pi *PodInfo) setNextLimit() {
+/*
+Func Name : (pi *PodInfo) planNextLimit()
+Objective : 1) Raise the next limits to the minimum, or replace them by the pinned limits
+			2) Return the writes of the next limits, with their decisions to complete by writeLimits
+*/
+func (pi *PodInfo) planNextLimit(now int64, policy string, period float64) []*limitWrite {
 
-	for _, ri := range pi.RIs {
+	writes := make([]*limitWrite, 0, len(pi.RNs))
+	for _, rn := range pi.RNs {
+		ri := pi.RIs[rn]
+		w := &limitWrite{ri: ri, d: newDecision(now, pi, ri, policy, period)}
+		w.d.Outcome = OutcomeApplied
 		if ri.nextLimit < 10 {
 			ri.nextLimit = 10
+			w.d.Outcome = OutcomeClamped
+		}
+		if ri.pinnedUntil != 0 {
+			ri.nextLimit = ri.pinnedLimit
+			w.d.Outcome = OutcomePinned
 		}
-		ri.SetLimit(ri.nextLimit)
+		writes = append(writes, w)
 	}
 	pi.UpdatedCount = pi.UpdatedCount + 1
-	pi.lastUpdatedTime = time.Now().UnixNano()
 	klog.V(4).Info(pi.PodName, "'s limits are set to : ", int64(pi.CPU().nextLimit), int64(pi.GPU().nextLimit))
+	return writes
 }
 
 func (pi *PodInfo) getNextLimit(remainedTimePerSecond float64) {
@@ -293,6 +438,7 @@ func (pi *PodInfo) getNextLimit(remainedTimePerSecond float64) {
 	tokenCondition := pi.availableToken - (cpuRI.price*cpuNextLimit+gpuRI.price*gpuNextLimit)*remainedTimePerSecond
 
 	if tokenCondition >= 0 {
+		pi.branch = BranchTokenEnough
 		klog.V(10).Info(pi.PodName, "'s Next Reseravation :", int64(cpuNextLimit), " , ", int64(gpuNextLimit), " Token Enough : tokenCondition : ", int64(tokenCondition))
 		pi.CPU().nextLimit = cpuNextLimit
 		pi.GPU().nextLimit = gpuNextLimit
@@ -306,8 +452,9 @@ func (pi *PodInfo) getNextLimit(remainedTimePerSecond float64) {
 	gpuNextLimit = gpuRI.usage + gpuRI.price*cpuRI.dynamicWeight*up/below
 	tokenCondition = pi.availableToken - (cpuRI.price*cpuNextLimit+gpuRI.price*gpuNextLimit)*remainedTimePerSecond
 
-	klog.V(10).Info(pi.PodName, "'s Next Reseravation :", int64(cpuNextLimit), " , ", int64(gpuNextLimit), " Token Enough : tokenCondition : ", int64(tokenCondition))
+	klog.V(10).Info(pi.PodName, "'s Next Reseravation :", int64(cpuNextLimit), " , ", int64(gpuNextLimit), " Token Limited : tokenCondition : ", int64(tokenCondition))
 
+	pi.branch = BranchTokenLimited
 	pi.CPU().nextLimit = cpuNextLimit
 	pi.GPU().nextLimit = gpuNextLimit
 	return
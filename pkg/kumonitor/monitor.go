@@ -15,74 +15,228 @@ package kumonitor
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
+	"github.com/sslab-konkuk/KuScale/pkg/kuclock"
+	"github.com/sslab-konkuk/KuScale/pkg/kufs"
+	"github.com/sslab-konkuk/KuScale/pkg/kuhealth"
 	"github.com/sslab-konkuk/KuScale/pkg/kuprofiler"
 	"k8s.io/klog"
 )
 
 type Configuraion struct {
-	monitoringPeriod int64
+	monitoringPeriod time.Duration
 	windowSize       int64
 	nodeName         string
 	monitoringMode   bool
 }
 
-type PodInfoMap map[string]*PodInfo
+type PodInfoMap map[string]*PodInfo // by PodKey
 type PodIDtoNameMap map[string]string
 
 type Monitor struct {
-	config  Configuraion
-	staticV float64
-	ctx     context.Context
-	cli     *client.Client
+	mu        sync.Mutex // RunningPodMap, CompletedPodMap and the pods in them
+	config    Configuraion
+	policy    Policy
+	ctx       context.Context
+	cliMu     sync.Mutex
+	cli       *client.Client
+	health    *kuhealth.Component
+	stopCh    chan string
+	host      Host
+	clock     kuclock.Clock // ticks Run, the host's if it is a clock
+	roots     kufs.Roots
+	observers []Observer
 
 	RunningPodMap   PodInfoMap
 	CompletedPodMap PodInfoMap
 	podIDtoNameMap  PodIDtoNameMap
+	podMeta         map[string]PodMeta // from the pod informer, by PodKey
 
 	lastExpiredTime int64 // Last Expired Time form Monitor Timer
 	lastUpdatedTime int64 // Last Updated Time from KuScale
+	lastTickTime    int64 // End of the last MonitorAndAutoScale, read by the health checks
+	tickStart       int64 // Start of the current MonitorAndAutoScale, for the observers
+
+	workers         int  // pods read and limits written at once
+	paused          bool // autoscaling is paused for every pod
+	decisionTracing bool // log every decision of the policy
+	stopped         bool // the limits are restored, nothing is written anymore
+
+	capacity map[ResourceName]float64 // in percent, which the batched requests are scaled down to
+
+	watchdog    *Watchdog
+	reconciler  *Reconciler
+	tickRunning int64        // Start of the MonitorAndAutoScale in progress, 0 between ticks
+	writes      writeTracker // limit write in progress
+	published   atomic.Value // *publishedTick, for the watchdog
+
+	done chan struct{} // closed when Run returns
 }
 
 func NewMonitor(
-	monitoringPeriod, windowSize int64,
+	monitoringPeriod time.Duration,
+	windowSize int64,
+	nodeName string,
+	monitoringMode bool,
+	staticV float64,
+	fs kufs.FS,
+	roots kufs.Roots) *Monitor {
+
+	monitor := NewMonitorWithHost(monitoringPeriod, windowSize, nodeName, monitoringMode, staticV, NewFSHost(fs, roots))
+	monitor.roots = roots
+
+	monitor.ctx = context.Background()
+	monitor.health = kuhealth.Register("monitor")
+	if _, err := monitor.dockerClient(); err != nil {
+		// Connected again when a new pod comes
+		monitor.health.Degraded(err)
+	} else {
+		monitor.health.Healthy()
+	}
+	return monitor
+}
+
+/*
+Func Name : (m *Monitor) SetRuntime()
+Objective : 1) Write the CPU limits of the containers through the update API of the runtime
+			2) Fall back to the cgroup files when the runtime fails
+*/
+func (m *Monitor) SetRuntime(updater RuntimeUpdater) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.host.(*fsHost)
+	if !ok {
+		return fmt.Errorf("the host of the monitor doesn't write cgroup limits")
+	}
+	h.runtime = updater
+	return nil
+}
+
+// dockerClient returns the docker client, connecting it if needed.
+func (m *Monitor) dockerClient() (*client.Client, error) {
+	m.cliMu.Lock()
+	defer m.cliMu.Unlock()
+	if m.cli != nil {
+		return m.cli, nil
+	}
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+	m.cli = cli
+	return cli, nil
+}
+
+/*
+Func Name : NewMonitorWithHost()
+Objective : 1) Make a monitor which reads usage from and writes limits to host
+			2) Pods are not discovered from docker, so they should be given by AddPod()
+*/
+func NewMonitorWithHost(
+	monitoringPeriod time.Duration,
+	windowSize int64,
 	nodeName string,
 	monitoringMode bool,
-	staticV float64) *Monitor {
+	staticV float64,
+	host Host) *Monitor {
 
 	klog.V(4).Info("Creating New Monitor")
 	config := Configuraion{monitoringPeriod, windowSize, nodeName, monitoringMode}
 	klog.V(4).Info("Configuration ", config)
-	monitor := &Monitor{config: config,
+	clock, ok := host.(kuclock.Clock)
+	if !ok {
+		clock = kuclock.Real
+	}
+	return &Monitor{config: config,
+		clock:           clock,
 		RunningPodMap:   make(PodInfoMap),
 		CompletedPodMap: make(PodInfoMap),
 		podIDtoNameMap:  make(PodIDtoNameMap),
-		staticV:         staticV}
+		podMeta:         make(map[string]PodMeta),
+		policy:          &KuScalePolicy{StaticV: staticV},
+		host:            host,
+		roots:           kufs.DefaultRoots,
+		workers:         DefaultWorkers,
+		done:            make(chan struct{})}
+}
 
-	var err error
-	monitor.ctx = context.Background()
-	monitor.cli, err = client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+func (m *Monitor) Policy() Policy        { return m.policy }
+func (m *Monitor) Period() time.Duration { return m.config.monitoringPeriod }
+func (m *Monitor) NodeName() string      { return m.config.nodeName }
+func (m *Monitor) MonitoringMode() bool  { return m.config.monitoringMode }
+func (m *Monitor) Now() int64            { return m.host.Now() }
+func (m *Monitor) LastTick() int64       { return atomic.LoadInt64(&m.lastTickTime) }
+
+// TickStart is when the current tick started. Call it from the observers.
+func (m *Monitor) TickStart() int64 { return m.tickStart }
+
+// SetPolicy replaces the policy from the next tick.
+func (m *Monitor) SetPolicy(policy Policy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	klog.V(4).Info("Policy is set to ", policy.Name(), " ", policy.Params())
+	m.policy = policy
+}
+
+// CheckDocker pings docker, and fails while finding new containers fails.
+func (m *Monitor) CheckDocker() error {
+	cli, err := m.dockerClient()
 	if err != nil {
-		panic(err)
+		return err
 	}
-	return monitor
+	ctx, cancel := context.WithTimeout(m.ctx, 2*time.Second)
+	defer cancel()
+	if _, err := cli.Ping(ctx); err != nil {
+		return err
+	}
+	if m.health != nil {
+		return m.health.Check()
+	}
+	return nil
 }
 
+// CheckTick fails when the control loop hasn't run for several periods.
+func (m *Monitor) CheckTick() error {
+	lastTick := m.LastTick()
+	if lastTick == 0 {
+		return fmt.Errorf("monitor is not running")
+	}
+	age := time.Duration(m.host.Now() - lastTick)
+	if age > 5*m.config.monitoringPeriod {
+		return fmt.Errorf("last tick was %s ago, the period is %s", age.Round(time.Millisecond), m.config.monitoringPeriod)
+	}
+	return nil
+}
+
+// The container of a new pod is looked for with containerStartBackoff, until
+// containerStartTimeout, which leaves time to pull its image.
+var (
+	containerStartBackoff = kuhealth.Backoff{Initial: 200 * time.Millisecond, Max: 5 * time.Second, Factor: 2, Jitter: 0.2}
+	containerStartTimeout = 10 * time.Minute
+
+	errNotStarted = errors.New("container is not started yet")
+)
+
 /*
 Func Name : waitContainerStart()
 Objective : 1) Wait for new container with vgpuId
-			2) Get the information about the new container such as podName, cpuPath, gpuPath
+			2) Make the pod info of the new container from its paths and kubernetes labels
+			3) Retry with backoff while docker fails or the container isn't started, up to containerStartTimeout
 */
-func (m *Monitor) waitContainerStart(vgpuId string) (string, string, string, string) {
+func (m *Monitor) waitContainerStart(vgpuId string) (*PodInfo, string, error) {
 
 	var containers []types.Container
-	var err error
+	var data types.ContainerJSON
 
 	filter := "annotation.kuauto.vgpu=" + vgpuId
 	filters := filters.NewArgs()
@@ -90,40 +244,52 @@ func (m *Monitor) waitContainerStart(vgpuId string) (string, string, string, str
 
 	klog.V(10).Info("waitContainerStart vgpuId : ", vgpuId)
 
-	for {
-		containers, err = m.cli.ContainerList(m.ctx, types.ContainerListOptions{Filters: filters})
+	start := time.Now()
+	find := func() error {
+		cli, err := m.dockerClient()
 		if err != nil {
-			panic(err) // TODO: erorr handling
+			return err
 		}
-		if len(containers) != 0 {
-			break
+		containers, err = cli.ContainerList(m.ctx, types.ContainerListOptions{Filters: filters})
+		if err != nil {
+			return err
+		}
+		if len(containers) == 0 {
+			return kuhealth.NotYet(errNotStarted)
 		}
-	}
-
-	klog.V(5).Info("Found the new container with vgpu ", vgpuId)
-	data, err := m.cli.ContainerInspect(m.ctx, containers[0].ID)
 
+		klog.V(5).Info("Found the new container with vgpu ", vgpuId)
+		data, err = cli.ContainerInspect(m.ctx, containers[0].ID)
+		return err
+	}
+	err := kuhealth.Retry(m.stopCh, containerStartBackoff, m.health, func() error {
+		err := find()
+		if err != nil && time.Since(start) > containerStartTimeout {
+			return kuhealth.Permanent(fmt.Errorf("gave up after %s : %w", containerStartTimeout, err))
+		}
+		return err
+	})
 	if err != nil {
-		panic(err)
+		return nil, "", err
 	}
 
-	var podName, cpuPath, gpuPath, dockerId string
+	var cpuPath, gpuPath, dockerId string
 
-	for label, value := range data.Config.Labels {
-		if label == "io.kubernetes.pod.name" {
-			podName = value
-			break
-		}
-	}
+	labels := data.Config.Labels
+	podName := labels["io.kubernetes.pod.name"]
 
 	// cpuPath = "/home/cgroup/cpu/kubepods.slice/kubepods-besteffort.slice/" + data.HostConfig.CgroupParent + "/docker-" + containers[0].ID + ".scope"
-	cpuPath = "/home/cgroup/kubepods.slice/kubepods-besteffort.slice/" + data.HostConfig.CgroupParent + "/docker-" + containers[0].ID + ".scope"
-	gpuPath = "/sys/kernel/gpu/IDs/" + vgpuId
+	cpuPath = m.roots.Cgroup + "/kubepods.slice/kubepods-besteffort.slice/" + data.HostConfig.CgroupParent + "/docker-" + containers[0].ID + ".scope"
+	gpuPath = m.roots.GPU + "/IDs/" + vgpuId
 	dockerId = containers[0].ID[:12]
 
 	klog.V(5).Info("Cgroup Path:", cpuPath, ",  gpuPath : ", gpuPath)
 
-	return podName, cpuPath, gpuPath, dockerId
+	podInfo := NewPodInfoWithPaths(podName, cpuPath, gpuPath)
+	podInfo.Namespace = labels["io.kubernetes.pod.namespace"]
+	podInfo.Container = labels["io.kubernetes.container.name"]
+	podInfo.CPU().container = containers[0].ID
+	return podInfo, dockerId, nil
 }
 
 /*
@@ -152,30 +318,93 @@ func (m *Monitor) UpdateNewPod(vgpuNToken string) {
 	tokenRes, _ := strconv.ParseFloat(data[1], 64)
 	vgpuId := data[0]
 
-	podName, cpuPath, gpuPath, dockerId := m.waitContainerStart(vgpuId)
+	podInfo, dockerId, err := m.waitContainerStart(vgpuId)
+	if err != nil {
+		klog.Error("Couldn't find the container with vgpu ", vgpuId, " : ", err)
+		return
+	}
 
 	// Prepare The Pod Info Structure
-	podInfo := NewPodInfo(podName, []ResourceName{"CPU", "GPU"})
 	podInfo.dockerID = dockerId
 	podInfo.TokenReservation = tokenRes
 	podInfo.TokenQueue = 0
 
-	podInfo.RIs["CPU"].path, podInfo.RIs["GPU"].path = cpuPath, gpuPath
-	podInfo.CPU().usagePath = podInfo.CPU().path + "/cpuacct.usage"
-	podInfo.GPU().usagePath = podInfo.GPU().path + "/total_runtime"
+	m.AddPod(podInfo)
+}
+
+/*
+Func Name : AddPod()
+Objective : 1) Attach the pod to the monitor's host
+			2) Set the initial limits and start monitoring the pod
+*/
+func (m *Monitor) AddPod(podInfo *PodInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped {
+		klog.V(4).Info("Monitor is stopped, not adding ", podInfo.PodName)
+		return
+	}
+	podInfo.SetHost(m.host)
+	podInfo.lastUpdatedTime = m.host.Now()
+	for _, ri := range podInfo.RIs {
+		ri.writes = &m.writes
+	}
 
-	if !m.config.monitoringMode  {
-		podInfo.SetInitLimit()
+	if !m.config.monitoringMode {
+		podInfo.readInitLimits()
+		m.setInitLimits(podInfo)
 	}
 	podInfo.UpdatePodUsage()
 	podInfo.UpdatePodUsage()
 
-	klog.V(5).Info("Ready and Start", podName)
-	m.RunningPodMap[podName] = podInfo
+	klog.V(5).Info("Ready and Start", podInfo.PodName)
+	m.RunningPodMap[podInfo.Key()] = podInfo
+	if meta, ok := m.podMeta[podInfo.Key()]; ok {
+		m.applyPodMeta(podInfo, meta)
+	}
+	for _, o := range m.observers {
+		o.PodAdded(m.host.Now(), podInfo)
+	}
+	m.publish()
+}
+
+// completePod stops monitoring pi.
+func (m *Monitor) completePod(pi *PodInfo) {
+	pi.status = PodCompleted
+	delete(m.RunningPodMap, pi.Key())
+	m.CompletedPodMap[pi.Key()] = pi
+	if m.watchdog != nil {
+		m.watchdog.forget(pi)
+	}
+	for _, o := range m.observers {
+		o.PodCompleted(m.host.Now(), pi)
+	}
+}
+
+// publishedTick is what the watchdog, which can't take m.mu, knows of the
+// last tick.
+type publishedTick struct {
+	pods     []*PodInfo
+	capacity map[ResourceName]float64
+	workers  int
+}
+
+// publish keeps the running pods, the capacity and the workers for the watchdog.
+func (m *Monitor) publish() {
+	pods := make([]*PodInfo, 0, len(m.RunningPodMap))
+	for _, pi := range m.RunningPodMap {
+		pods = append(pods, pi)
+	}
+	capacity := make(map[ResourceName]float64, len(m.capacity))
+	for rn, c := range m.capacity {
+		capacity[rn] = c
+	}
+	m.published.Store(&publishedTick{pods: pods, capacity: capacity, workers: m.workers})
 }
 
 /*
 Func Name : MonitorPod(pi *PodInfo)
+
 	Objective :
 	1) Monitoring the pods in RunningPodMap
 	2) Check and Remove Completed Pods
@@ -184,44 +413,103 @@ func updatePod(pi *PodInfo) {
 	if pi.status == PodInitializing {
 		pi.status = PodRunning
 	}
-	pi.lastElaspedTime = float64(time.Now().UnixNano()-pi.lastUpdatedTime) / 1000000000.
+	// Measured for every pod, so that a late tick or one of ebpfCh accounts
+	// the tokens for the time the limits were really in place
+	now := pi.host.Now()
+	pi.lastElaspedTime = float64(now-pi.lastUpdatedTime) / 1e9
+	pi.lastUpdatedTime = now
 	pi.UpdatePodUsage()
 	pi.UpdateTokenQueue()
 }
 
 /*
 Func Name : MonitorAndAutoScale()
+
 	Objective :
-	1) Monitoring the pods in RunningPodMap
+	1) Monitoring the pods in RunningPodMap, on the workers
 	2) Check and Remove Completed Pods
+	3) Decide the next limits, and write them on the workers before the end of the period
 */
 func (m *Monitor) MonitorAndAutoScale() {
 	startTime := kuprofiler.StartTime()
 	defer kuprofiler.Record("MonitorAndAutoScale", startTime)
+	atomic.StoreInt64(&m.tickRunning, m.host.Now())
+	defer atomic.StoreInt64(&m.tickRunning, 0)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped {
+		return
+	}
+	m.tickStart = m.host.Now()
+	defer m.publish()
 
 	/* Return If there is no pods in RunningPodMap */
 	if len(m.RunningPodMap) == 0 {
+		atomic.StoreInt64(&m.lastTickTime, m.host.Now())
 		return
 	}
-	/* Monitor and Update Pod */
+	/* Read : usages and token queues of every pod, on the workers */
+	readTime := kuprofiler.StartTime()
+	pods := make([]*PodInfo, 0, len(m.RunningPodMap))
 	for _, pi := range m.RunningPodMap {
-		updatePod(pi)
+		pods = append(pods, pi)
+	}
+	m.parallel(len(pods), func(i int) { updatePod(pods[i]) })
+	kuprofiler.Record("TickRead", readTime)
+	for _, pi := range pods {
 		if pi.status == PodCompleted {
-			delete(m.RunningPodMap, pi.PodName)
-			m.CompletedPodMap[pi.PodName] = pi
+			m.completePod(pi)
 		} else {
-			m.RunningPodMap[pi.PodName] = pi
+			m.RunningPodMap[pi.Key()] = pi
 		}
 	}
 
-	if !m.config.monitoringMode  {
-		/* Get Next Limit in Simple conditions */
+	if !m.config.monitoringMode {
+		if m.watchdog != nil {
+			m.watchdog.recordFailSafe(m.host.Now())
+		}
+
+		/* Verify : the limits in effect against those of the last tick */
+		if m.reconciler != nil {
+			verifyTime := kuprofiler.StartTime()
+			m.reconciler.reconcile(m.host.Now())
+			kuprofiler.Record("TickVerify", verifyTime)
+		}
+
+		/* Decide : next limits of every pod by the policy */
+		decideTime := kuprofiler.StartTime()
+		now := m.host.Now()
+		period := m.config.monitoringPeriod.Seconds()
+		var writes []*limitWrite
 		for _, pi := range m.RunningPodMap {
-			pi.UpdateDynamicWeight(m.staticV)
-			pi.getNextLimit(float64(m.config.monitoringPeriod))
-			pi.setNextLimit()
-			m.RunningPodMap[pi.PodName] = pi
+			pi.expirePins(now)
+			if m.paused || pi.paused {
+				continue
+			}
+			if m.watchdog != nil {
+				if failSafe, failSafeWrites := m.watchdog.failSafe(now, pi); failSafe {
+					writes = append(writes, failSafeWrites...)
+					continue
+				}
+			}
+			pi.branch = ""
+			m.policy.NextLimits(pi, period)
+			if m.decisionTracing {
+				m.traceDecision(pi)
+			}
+			writes = append(writes, pi.planNextLimit(now, m.policy.Name(), period)...)
 		}
+		kuprofiler.Record("TickDecide", decideTime)
+
+		/* Write : every next limit before the end of the period, on the workers */
+		writeTime := kuprofiler.StartTime()
+		m.writeLimits(writes, m.tickStart+int64(m.config.monitoringPeriod))
+		kuprofiler.Record("TickWrite", writeTime)
+	}
+	atomic.StoreInt64(&m.lastTickTime, m.host.Now())
+
+	for _, o := range m.observers {
+		o.Ticked(m.host.Now(), m)
 	}
 
 	// matrixInfo, matrix := makeMatrix(m.RunningPodMap)
@@ -238,11 +526,18 @@ func (m *Monitor) MonitorAndAutoScale() {
 func (m *Monitor) Run(stopCh, ebpfCh, newPodCh chan string) {
 
 	klog.V(4).Info("Starting Monitor")
-	timerCh := time.Tick(time.Second * time.Duration(m.config.monitoringPeriod))
+	defer close(m.done)
+	m.stopCh = stopCh
+	atomic.StoreInt64(&m.lastTickTime, m.host.Now())
+	ticker := m.clock.NewTicker(m.config.monitoringPeriod)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-stopCh:
 			klog.V(4).Info("Shutting monitor down")
+			if m.health != nil {
+				m.health.Stopped()
+			}
 			return
 		case vgpuNToken := <-newPodCh:
 			klog.V(10).Info("Get New PodCh : ", vgpuNToken)
@@ -251,9 +546,71 @@ func (m *Monitor) Run(stopCh, ebpfCh, newPodCh chan string) {
 			klog.V(10).Info("MonitorAndAutoScale By EBPF")
 			m.MonitorAndAutoScale()
 			kuprofiler.RecordEnd("SchedulingLatency")
-		case <-timerCh:
+		case <-ticker.C():
 			klog.V(10).Info("MonitorAndAutoScale By Timer")
 			m.MonitorAndAutoScale()
 		}
 	}
 }
+
+// initLimit is the limit of a pod until the policy decides its limits.
+const initLimit = 10
+
+// setInitLimits sets the initial limits of pi, its GPU limit in a transaction.
+func (m *Monitor) setInitLimits(pi *PodInfo) {
+	var ris []*ResourceInfo
+	var limits []float64
+	for _, ri := range pi.RIs {
+		ris, limits = append(ris, ri), append(limits, initLimit)
+	}
+	for i, err := range m.setLimits(ris, limits) {
+		if err != nil {
+			klog.Info("Couldn't set ", pi.PodName, "'s initial ", ris[i].name, " limit : ", err)
+		}
+	}
+}
+
+// Done is closed when Run returns, after the tick in progress.
+func (m *Monitor) Done() <-chan struct{} { return m.done }
+
+/*
+Func Name : RestoreLimits()
+Objective : 1) Stop the monitor from writing any limit from now on
+			2) Write back the limits set before KuScale, or safe[name] when they are unknown
+*/
+func (m *Monitor) RestoreLimits(safe map[ResourceName]float64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stopped = true
+	if m.config.monitoringMode {
+		return 0, nil
+	}
+
+	var pis []*PodInfo
+	var ris []*ResourceInfo
+	var limits []float64
+	for _, pi := range m.RunningPodMap {
+		for _, rn := range pi.RNs {
+			pis, ris, limits = append(pis, pi), append(ris, pi.RIs[rn]), append(limits, pi.RIs[rn].safeLimit(safe))
+		}
+	}
+
+	restored := 0
+	var errs []string
+	for i, err := range m.setLimits(ris, limits) {
+		pi, ri := pis[i], ris[i]
+		if kufs.IsNotExist(err) {
+			// The pod has gone away meanwhile
+			continue
+		} else if err != nil {
+			errs = append(errs, fmt.Sprintf("%s's %s : %v", pi.PodName, ri.name, err))
+			continue
+		}
+		klog.V(4).Info("Restored ", pi.PodName, "'s ", ri.name, " limit to ", limits[i])
+		restored++
+	}
+	if len(errs) > 0 {
+		return restored, fmt.Errorf("couldn't restore %s", strings.Join(errs, ", "))
+	}
+	return restored, nil
+}
@@ -0,0 +1,290 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kumonitor
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kuclock"
+	"github.com/sslab-konkuk/KuScale/pkg/kufs"
+	"github.com/sslab-konkuk/KuScale/pkg/kuprofiler"
+)
+
+var testRoots = kufs.Roots{Cgroup: "/cgroup", GPU: "/gpu", Proc: "/proc"}
+
+// newTestFS lays out the ku-gpu-layer module on a MemFS, which makes the
+// files of an ID when it is written to configs/init, as the module does.
+func newTestFS() *kufs.MemFS {
+	fs := kufs.NewMemFS()
+	for name, data := range map[string]string{
+		"configs/init": "", "configs/destroy": "", "configs/totalIDs": "0", "gemini/resource_conf": "",
+	} {
+		fs.SetFile(kufs.Join(testRoots.GPU, name), []byte(data))
+	}
+	fs.OnWrite = func(path string, data []byte) {
+		if path != kufs.Join(testRoots.GPU, "configs/init") {
+			return
+		}
+		for _, name := range []string{"total_runtime", "gpu_limit", "gpu_request"} {
+			fs.SetFile(kufs.Join(testRoots.GPU, "IDs", string(data), name), []byte("0"))
+		}
+	}
+	return fs
+}
+
+// newTestPod makes the cgroup and the vGPU ID of a pod on fs.
+func newTestPod(t testing.TB, fs *kufs.MemFS, id int, reservation float64) *PodInfo {
+	t.Helper()
+	cpuPath := kufs.Join(testRoots.Cgroup, "cpu/kubepods", fmt.Sprint("pod", id))
+	fs.SetFile(cpuPath+"/cpu.stat", []byte("usage_usec 0\n"))
+	fs.SetFile(cpuPath+"/cpu.cfs_quota_us", []byte("-1"))
+	if err := fs.WriteFile(kufs.Join(testRoots.GPU, "configs/init"), []byte(fmt.Sprint(id))); err != nil {
+		t.Fatal(err)
+	}
+	pi := NewPodInfoWithPaths(fmt.Sprint("pod", id), cpuPath, kufs.Join(testRoots.GPU, "IDs", fmt.Sprint(id)))
+	pi.TokenReservation = reservation
+	return pi
+}
+
+// use adds usage to the accounted usages of pi, in ns of CPU and GPU time.
+func use(t testing.TB, fs *kufs.MemFS, pi *PodInfo, cpu, gpu uint64) {
+	t.Helper()
+	cpuAcct, err := ReadCPUStat(fs, pi.CPU().path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.SetFile(pi.CPU().path+"/cpu.stat", []byte(fmt.Sprintf("usage_usec %d\n", (cpuAcct+cpu)/1000)))
+	gpuAcct, err := kufs.ReadUint(fs, pi.GPU().usagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.SetFile(pi.GPU().usagePath, []byte(fmt.Sprint(gpuAcct+gpu)))
+}
+
+func readFile(t testing.TB, fs kufs.FS, path string) string {
+	t.Helper()
+	data, err := fs.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// checkLimitFiles checks that the limits of pi are those written to fs.
+func checkLimitFiles(t *testing.T, fs kufs.FS, pi *PodInfo) {
+	t.Helper()
+	cpu := pi.CPU().Limit()
+	if got, want := readFile(t, fs, pi.CPU().path+"/cpu.cfs_quota_us"), fmt.Sprint(uint64(cpu)*1000); got != want {
+		t.Errorf("%s cpu.cfs_quota_us = %s, want %s for the limit %v", pi.PodName, got, want, cpu)
+	}
+	gpu := pi.GPU().Limit()
+	if got, want := readFile(t, fs, pi.GPU().path+"/gpu_limit"), fmt.Sprint(gpuQuota(gpu)); got != want {
+		t.Errorf("%s gpu_limit = %s, want %s for the limit %v", pi.PodName, got, want, gpu)
+	}
+}
+
+// testStart is when the fake clock of a test monitor starts.
+const testStart = int64(1e18)
+
+// newTestMonitor makes a monitor of fs ticking every second of a fake clock.
+func newTestMonitor(fs kufs.FS, monitoringMode bool) *Monitor {
+	kuprofiler.NewLatencyInfo(false)
+	m := NewMonitorWithHost(time.Second, 5, "node", monitoringMode, 0,
+		NewFSHostWithClock(fs, testRoots, kuclock.NewFake(testStart)))
+	m.roots = testRoots
+	return m
+}
+
+// advance moves the fake clock of m forward by d.
+func advance(m *Monitor, d time.Duration) { m.host.(*fsHost).Clock.(*kuclock.Fake).Advance(d) }
+
+// tick runs a tick of m once its period passed.
+func tick(m *Monitor) {
+	advance(m, m.Period())
+	m.MonitorAndAutoScale()
+}
+
+func TestAddPodWritesInitialLimits(t *testing.T) {
+	fs := newTestFS()
+	m := newTestMonitor(fs, false)
+	pi := newTestPod(t, fs, 0, 100)
+	m.AddPod(pi)
+
+	if _, ok := m.RunningPodMap[pi.Key()]; !ok {
+		t.Fatalf("%s isn't running", pi.PodName)
+	}
+	for _, ri := range pi.RIs {
+		if ri.Limit() != 10 {
+			t.Errorf("%s limit = %v, want 10", ri.name, ri.Limit())
+		}
+		if !ri.hasInit || ri.initLimit != Unlimited {
+			t.Errorf("%s limit before KuScale = %v (%v), want no limit", ri.name, ri.initLimit, ri.hasInit)
+		}
+	}
+	checkLimitFiles(t, fs, pi)
+	if got := readFile(t, fs, pi.GPU().path+"/gpu_request"); got != "100" {
+		t.Errorf("gpu_request = %s, want 100", got)
+	}
+}
+
+func TestMonitorAndAutoScaleWritesLimits(t *testing.T) {
+	fs := newTestFS()
+	m := newTestMonitor(fs, false)
+	var pods []*PodInfo
+	for id := 0; id < 3; id++ {
+		pi := newTestPod(t, fs, id, 300)
+		m.AddPod(pi)
+		pods = append(pods, pi)
+	}
+
+	for i := 0; i < 5; i++ {
+		for i, pi := range pods {
+			use(t, fs, pi, uint64(i+1)*5e8, uint64(i+1)*2e8)
+		}
+		tick(m)
+	}
+
+	if m.LastTick() == 0 {
+		t.Error("the last tick isn't recorded")
+	}
+	for _, pi := range pods {
+		if pi.Status() != PodRunning {
+			t.Errorf("%s is %v, want running", pi.PodName, pi.Status())
+		}
+		if pi.UpdatedCount != 5 {
+			t.Errorf("%s was decided %d times, want 5", pi.PodName, pi.UpdatedCount)
+		}
+		if pi.CPU().Usage() <= 0 || pi.GPU().Usage() <= 0 {
+			t.Errorf("%s usages = %v, %v, want them measured", pi.PodName, pi.CPU().Usage(), pi.GPU().Usage())
+		}
+		if pi.CPU().Limit() == 10 && pi.GPU().Limit() == 10 {
+			t.Errorf("%s limits are still the initial ones", pi.PodName)
+		}
+		checkLimitFiles(t, fs, pi)
+	}
+}
+
+func TestMonitorAndAutoScaleScalesGPURequestsToCapacity(t *testing.T) {
+	fs := newTestFS()
+	m := newTestMonitor(fs, false)
+	m.SetCapacity(map[ResourceName]float64{"GPU": 100})
+	var pods []*PodInfo
+	for id := 0; id < 4; id++ {
+		pi := newTestPod(t, fs, id, 1000)
+		m.AddPod(pi)
+		pods = append(pods, pi)
+	}
+	for i := 0; i < 3; i++ {
+		for _, pi := range pods {
+			use(t, fs, pi, 5e8, 8e8)
+		}
+		tick(m)
+	}
+
+	limits, requests := uint64(0), uint64(0)
+	for _, pi := range pods {
+		checkLimitFiles(t, fs, pi)
+		limit, _ := kufs.ReadUint(fs, pi.GPU().path+"/gpu_limit")
+		request, _ := kufs.ReadUint(fs, pi.GPU().path+"/gpu_request")
+		if request > limit {
+			t.Errorf("%s gpu_request %d is over its gpu_limit %d", pi.PodName, request, limit)
+		}
+		limits, requests = limits+limit, requests+request
+	}
+	if limits <= 1000 {
+		t.Fatalf("the GPU limits add up to %d, the test needs them over the capacity", limits)
+	}
+	if requests > 1000 {
+		t.Errorf("the GPU requests add up to %d, over the capacity 1000", requests)
+	}
+}
+
+func TestMonitorAndAutoScaleCompletesPods(t *testing.T) {
+	fs := newTestFS()
+	m := newTestMonitor(fs, false)
+	gone, stays := newTestPod(t, fs, 0, 100), newTestPod(t, fs, 1, 100)
+	m.AddPod(gone)
+	m.AddPod(stays)
+
+	fs.RemoveAll(gone.CPU().path)
+	m.MonitorAndAutoScale()
+
+	if _, ok := m.RunningPodMap[gone.Key()]; ok {
+		t.Errorf("%s is still running", gone.PodName)
+	}
+	if _, ok := m.CompletedPodMap[gone.Key()]; !ok || gone.Status() != PodCompleted {
+		t.Errorf("%s isn't completed", gone.PodName)
+	}
+	if _, ok := m.RunningPodMap[stays.Key()]; !ok {
+		t.Errorf("%s isn't running", stays.PodName)
+	}
+}
+
+func TestMonitoringModeWritesNoLimits(t *testing.T) {
+	fs := newTestFS()
+	m := newTestMonitor(fs, true)
+	pi := newTestPod(t, fs, 0, 300)
+	m.AddPod(pi)
+	for i := 0; i < 3; i++ {
+		use(t, fs, pi, 5e8, 2e8)
+		tick(m)
+	}
+
+	if pi.CPU().Usage() <= 0 {
+		t.Error("the CPU usage isn't measured")
+	}
+	if got := readFile(t, fs, pi.CPU().path+"/cpu.cfs_quota_us"); got != "-1" {
+		t.Errorf("cpu.cfs_quota_us = %s, want -1 as it was", got)
+	}
+	if got := readFile(t, fs, pi.GPU().path+"/gpu_limit"); got != "0" {
+		t.Errorf("gpu_limit = %s, want 0 as it was", got)
+	}
+	if err := m.PinLimit("", pi.PodName, "CPU", 200, m.Now()+int64(time.Hour)); err == nil {
+		t.Error("a limit was pinned in monitoring mode")
+	}
+}
+
+// BenchmarkMonitorAndAutoScale measures the latency of a tick with hundreds of
+// pods, each using some CPU and GPU, on a MemFS.
+func BenchmarkMonitorAndAutoScale(b *testing.B) {
+	for _, n := range []int{100, 300, 1000} {
+		b.Run(fmt.Sprint("pods=", n), func(b *testing.B) {
+			fs := newTestFS()
+			m := newTestMonitor(fs, false)
+			var pods []*PodInfo
+			for id := 0; id < n; id++ {
+				pi := newTestPod(b, fs, id, 100)
+				m.AddPod(pi)
+				pods = append(pods, pi)
+			}
+			tick(m)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				for j, pi := range pods {
+					use(b, fs, pi, uint64(j%10+1)*1e8, uint64(j%10+1)*1e7)
+				}
+				advance(m, m.Period())
+				b.StartTimer()
+				m.MonitorAndAutoScale()
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*n), "ns/pod")
+		})
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kumonitor
+
+// Observer is told what the monitor does. Observers are called from the
+// monitor loop with the monitor locked, so they should not block nor call Do.
+type Observer interface {
+	PodAdded(now int64, pi *PodInfo)
+	PodCompleted(now int64, pi *PodInfo)
+	// Ticked is called after the usages and limits of the running pods are updated.
+	Ticked(now int64, m *Monitor)
+}
+
+func (m *Monitor) AddObserver(o Observer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.observers = append(m.observers, o)
+}
+
+// Actions of a Control.
+const (
+	ControlUnpin       = "unpin"       // a pinned limit is given back to the policy
+	ControlPause       = "pause"       // autoscaling is paused
+	ControlResume      = "resume"      // autoscaling is resumed
+	ControlReservation = "reservation" // the token reservation is changed
+	ControlRecovered   = "recovered"   // the usage readings of a pod on fail-safe limits came back
+)
+
+// Control is a change of a pod, or of every pod, which is not a limit, by
+// the control API, the annotations of the pod or the watchdog.
+type Control struct {
+	Time             int64
+	Pod              string // empty for every pod
+	Namespace        string
+	Action           string
+	Resource         ResourceName // of ControlUnpin
+	TokenReservation float64      // of ControlReservation
+}
+
+// ControlObserver is an Observer which is also told every Control. It is
+// called with the monitor locked, like Observer.
+type ControlObserver interface {
+	Observer
+	Controlled(c *Control)
+}
+
+// controlled tells the control observers about c.
+func (m *Monitor) controlled(c *Control) {
+	for _, o := range m.observers {
+		if co, ok := o.(ControlObserver); ok {
+			co.Controlled(c)
+		}
+	}
+}
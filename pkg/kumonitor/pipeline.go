@@ -0,0 +1,272 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kumonitor
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"k8s.io/klog"
+)
+
+// DefaultWorkers is how many pods are read, and limits written, at once.
+const DefaultWorkers = 8
+
+// ErrTickDeadline is the error of a limit which wasn't written because the
+// tick ran out of its period. The policy decides it again at the next tick.
+var ErrTickDeadline = errors.New("tick deadline passed before the limit was written")
+
+// limitWrite is a next limit of the decide stage, written by the write stage.
+type limitWrite struct {
+	ri *ResourceInfo
+	d  Decision
+}
+
+// SetWorkers sets how many pods are read, and limits written, at once.
+func (m *Monitor) SetWorkers(workers int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if workers < 1 {
+		workers = 1
+	}
+	m.workers = workers
+}
+
+// parallel runs f(0) to f(n-1) on at most m.workers goroutines.
+func (m *Monitor) parallel(n int, f func(i int)) { parallel(m.workers, n, f) }
+
+// parallel runs f(0) to f(n-1) on at most workers goroutines.
+func parallel(workers, n int, f func(i int)) {
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			f(i)
+		}
+		return
+	}
+
+	next := int64(-1)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := int(atomic.AddInt64(&next, 1)); i < n; i = int(atomic.AddInt64(&next, 1)) {
+				f(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// SetCapacity sets the capacity of the node in percent, which the guaranteed
+// requests of the batched limits are scaled down to.
+func (m *Monitor) SetCapacity(capacity map[ResourceName]float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.capacity = capacity
+}
+
+/*
+Func Name : (m *Monitor) writeLimits()
+Objective : 1) Write the next limits of the host's batched resources in a transaction per resource
+			2) Write the others on the workers, skipping those left when deadline passes
+			3) Tell the observers about every decision, one after another
+*/
+func (m *Monitor) writeLimits(writes []*limitWrite, deadline int64) {
+	var single []*limitWrite
+	batches := make(map[ResourceName][]*limitWrite)
+	bw, batching := m.host.(BatchWriter)
+	for _, w := range writes {
+		if batching && bw.Batched(w.ri.name) {
+			batches[w.ri.name] = append(batches[w.ri.name], w)
+		} else {
+			single = append(single, w)
+		}
+	}
+	for rn, batch := range batches {
+		m.writeBatch(bw, rn, batch, deadline)
+	}
+
+	skipped := int64(0)
+	m.parallel(len(single), func(i int) {
+		w := single[i]
+		if m.host.Now() > deadline {
+			w.d.Err = ErrTickDeadline
+			atomic.AddInt64(&skipped, 1)
+		} else {
+			w.d.Err = w.ri.SetLimit(w.ri.nextLimit)
+		}
+		if w.d.Err != nil {
+			w.d.Outcome = OutcomeFailed
+		}
+		w.d.NewLimit = w.ri.limit
+	})
+	if skipped > 0 {
+		klog.Warning(skipped, " of ", len(single), " limits weren't written before the tick deadline")
+	}
+	for _, w := range writes {
+		m.decided(&w.d)
+	}
+}
+
+/*
+Func Name : (m *Monitor) writeBatch()
+Objective : 1) Write the next limits of rn in one transaction by commitBatch, unless deadline passed
+			2) Keep them only if it is committed
+*/
+func (m *Monitor) writeBatch(bw BatchWriter, rn ResourceName, batch []*limitWrite, deadline int64) {
+	fail := func(err error) {
+		for _, w := range batch {
+			w.d.Err, w.d.Outcome, w.d.NewLimit = err, OutcomeFailed, w.ri.limit
+		}
+	}
+	if m.host.Now() > deadline {
+		klog.Warning("The ", len(batch), " ", rn, " limits weren't written before the tick deadline")
+		fail(ErrTickDeadline)
+		return
+	}
+
+	ris, limits := make([]*ResourceInfo, len(batch)), make([]float64, len(batch))
+	for i, w := range batch {
+		ris[i], limits[i] = w.ri, w.ri.nextLimit
+	}
+	errs, err := m.commitBatch(bw, rn, ris, limits)
+	if err != nil {
+		klog.Error("Couldn't write the ", len(batch), " ", rn, " limits, rolled back : ", err)
+		fail(err)
+		return
+	}
+	for i, w := range batch {
+		if w.d.Err = errs[i]; w.d.Err != nil {
+			klog.Info("Couldn't set ", w.ri.name, " limit to ", w.ri.nextLimit, " : ", w.d.Err)
+			w.d.Outcome = OutcomeFailed
+		}
+		w.d.NewLimit = w.ri.limit
+	}
+}
+
+/*
+Func Name : (m *Monitor) commitBatch()
+Objective : 1) Guarantee each limit of ris its share of the capacity of rn, with the limits of the other pods
+			2) Write them in one transaction, and set those written as the limits of ris
+*/
+func (m *Monitor) commitBatch(bw BatchWriter, rn ResourceName, ris []*ResourceInfo, limits []float64) ([]error, error) {
+	// The limits of the pods out of the batch, paused or on fail-safe limits, stay
+	inBatch := make(map[*ResourceInfo]bool, len(ris))
+	writes := make([]BatchWrite, len(ris))
+	for i, ri := range ris {
+		inBatch[ri] = true
+		writes[i] = BatchWrite{RI: ri, Limit: limits[i]}
+	}
+	others := 0.
+	for _, pi := range m.RunningPodMap {
+		if ri, ok := pi.RIs[rn]; ok && !inBatch[ri] {
+			others += requested(ri.limit)
+		}
+	}
+	guarantee(writes, others, m.capacity[rn])
+
+	for _, ri := range ris {
+		if ri.writes != nil {
+			ri.writes.begin(ri, m.host.Now())
+		}
+	}
+	errs, err := bw.WriteBatch(writes, m.workers)
+	for _, ri := range ris {
+		if ri.writes != nil {
+			ri.writes.end(ri)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, ri := range ris {
+		if errs[i] == nil {
+			ri.limit = limits[i]
+		}
+	}
+	return errs, nil
+}
+
+/*
+Func Name : (m *Monitor) setLimits()
+Objective : 1) Set limits[i] as the limit of ris[i], the host's batched resources in a transaction per resource
+			2) Return the error of every limit
+*/
+func (m *Monitor) setLimits(ris []*ResourceInfo, limits []float64) []error {
+	errs := make([]error, len(ris))
+	batches := make(map[ResourceName][]int)
+	bw, batching := m.host.(BatchWriter)
+	for i, ri := range ris {
+		if batching && bw.Batched(ri.name) {
+			batches[ri.name] = append(batches[ri.name], i)
+		} else {
+			errs[i] = ri.SetLimit(limits[i])
+		}
+	}
+	for rn, batch := range batches {
+		bris, blimits := make([]*ResourceInfo, len(batch)), make([]float64, len(batch))
+		for j, i := range batch {
+			bris[j], blimits[j] = ris[i], limits[i]
+		}
+		berrs, err := m.commitBatch(bw, rn, bris, blimits)
+		for j, i := range batch {
+			if err != nil {
+				errs[i] = err
+			} else {
+				errs[i] = berrs[j]
+			}
+		}
+		if err != nil {
+			klog.Error("Couldn't write the ", len(batch), " ", rn, " limits, rolled back : ", err)
+		}
+	}
+	return errs
+}
+
+// setLimit sets limit as the limit of ri, as setLimits does.
+func (m *Monitor) setLimit(ri *ResourceInfo, limit float64) error {
+	return m.setLimits([]*ResourceInfo{ri}, []float64{limit})[0]
+}
+
+// guarantee sets the requests of batch to their limits, scaled down with
+// those the others requested to capacity when they are over it.
+func guarantee(batch []BatchWrite, others, capacity float64) {
+	total := others
+	for _, b := range batch {
+		total += requested(b.Limit)
+	}
+	scale := 1.
+	if capacity > 0 && total > capacity {
+		scale = capacity / total
+		klog.V(4).Info("The limits add up to ", total, " over the capacity ", capacity, ", guaranteeing ", scale, " of them")
+	}
+	for i := range batch {
+		batch[i].Request = requested(batch[i].Limit) * scale
+	}
+}
+
+// requested is the share of the capacity a limit asks for, the whole resource
+// of a unit when it is unlimited.
+func requested(limit float64) float64 {
+	if limit < 0 {
+		return 100
+	}
+	return limit
+}
@@ -0,0 +1,138 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kumonitor
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+// Annotations of a pod pinning its limits, in percent, while they are set.
+const (
+	AnnotationCPULimit = "kuscale/cpu_limit"
+	AnnotationGPULimit = "kuscale/gpu_limit"
+)
+
+var limitAnnotations = map[ResourceName]string{"CPU": AnnotationCPULimit, "GPU": AnnotationGPULimit}
+
+// pinnedForever is the pinnedUntil of the limits pinned by annotations.
+const pinnedForever = math.MaxInt64
+
+// PodMeta is what the Kubernetes API tells about a pod on the node.
+type PodMeta struct {
+	Namespace   string
+	Name        string
+	UID         string
+	Owner       string // Kind/Name of the controller of the pod, empty if none
+	QOSClass    string
+	Annotations map[string]string
+}
+
+/*
+Func Name : SetPodMeta()
+Objective : 1) Keep what the Kubernetes API tells about a pod, for when it starts
+			2) Apply it to the pod if it is running, pinning the limits of its annotations
+*/
+func (m *Monitor) SetPodMeta(meta PodMeta) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := PodKey(meta.Namespace, meta.Name)
+	m.podMeta[key] = meta
+	if pi, ok := m.RunningPodMap[key]; ok {
+		m.applyPodMeta(pi, meta)
+	}
+}
+
+// DeletePod stops monitoring a pod the Kubernetes API deleted, without
+// waiting for its cgroup files to go away. A pod of the same name but of
+// another uid, recreated since, is left alone.
+func (m *Monitor) DeletePod(namespace, name, uid string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := PodKey(namespace, name)
+	if meta, ok := m.podMeta[key]; ok && sameUID(meta.UID, uid) {
+		delete(m.podMeta, key)
+	}
+	if pi, ok := m.RunningPodMap[key]; ok {
+		if !sameUID(pi.UID, uid) {
+			klog.V(4).Info(key, " of uid ", uid, " is deleted, but ", pi.UID, " is running")
+			return
+		}
+		klog.V(4).Info(key, " is deleted")
+		m.completePod(pi)
+		m.publish()
+	}
+}
+
+// sameUID tells whether two uids are of the same pod, or one is unknown.
+func sameUID(a, b string) bool { return a == "" || b == "" || a == b }
+
+// applyPodMeta sets the metadata of pi, and pins or unpins its limits as
+// the annotations were set, changed or removed. Call it with m.mu held.
+func (m *Monitor) applyPodMeta(pi *PodInfo, meta PodMeta) {
+	pi.UID, pi.Owner, pi.QOSClass, pi.Annotations = meta.UID, meta.Owner, meta.QOSClass, meta.Annotations
+	if m.config.monitoringMode || m.stopped {
+		return
+	}
+	for rn, ri := range pi.RIs {
+		annotation := limitAnnotations[rn]
+		value, ok := meta.Annotations[annotation]
+		if !ok {
+			if ri.annotatedLimit != 0 {
+				klog.V(4).Info(pi.PodName, "'s ", rn, " limit is not pinned by ", annotation, " anymore")
+				m.unpin(pi, ri)
+			}
+			continue
+		}
+		limit, err := m.parseLimit(rn, value)
+		if err != nil {
+			klog.Error("Ignoring ", annotation, " of ", pi.PodName, " : ", err)
+			continue
+		}
+		if limit == ri.annotatedLimit {
+			continue
+		}
+		if err := m.pinLimit(pi, ri, limit, pinnedForever, BranchAnnotation); err != nil {
+			klog.Error("Couldn't pin ", pi.PodName, "'s ", rn, " limit to ", limit, " of ", annotation, " : ", err)
+			continue
+		}
+		ri.annotatedLimit = limit
+	}
+}
+
+// parseLimit parses the limit of an annotation, which should be positive and
+// fit in the capacity of the node, and in one GPU.
+func (m *Monitor) parseLimit(rn ResourceName, value string) (float64, error) {
+	limit, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return 0, err
+	}
+	max := m.capacity[rn]
+	if rn == "GPU" && (max == 0 || max > 100) {
+		max = 100
+	}
+	if limit <= 0 {
+		return 0, fmt.Errorf("%s limit %v should be positive", rn, limit)
+	} else if max > 0 && limit > max {
+		return 0, fmt.Errorf("%s limit %v is over %v", rn, limit, max)
+	}
+	return limit, nil
+}
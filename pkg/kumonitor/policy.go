@@ -0,0 +1,65 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kumonitor
+
+import "fmt"
+
+// Policy decides the next limits of a pod. It fills nextLimit of every
+// resource, and the monitor applies them afterwards.
+type Policy interface {
+	Name() string
+	// Params returns the parameters which NewPolicy needs to make the same policy.
+	Params() map[string]float64
+	NextLimits(pi *PodInfo, remainedTimePerSecond float64)
+}
+
+// KuScalePolicy spends the token reservation where the pod needs it, with
+// the dynamic weights from the average usages or the static weight staticV.
+type KuScalePolicy struct {
+	StaticV float64
+}
+
+func (p *KuScalePolicy) Name() string               { return "kuscale" }
+func (p *KuScalePolicy) Params() map[string]float64 { return map[string]float64{"staticV": p.StaticV} }
+
+func (p *KuScalePolicy) NextLimits(pi *PodInfo, remainedTimePerSecond float64) {
+	pi.UpdateDynamicWeight(p.StaticV)
+	pi.getNextLimit(remainedTimePerSecond)
+}
+
+// StaticPolicy splits the token reservation evenly over the resources and
+// never moves it, which is the baseline without autoscaling.
+type StaticPolicy struct{}
+
+func (p *StaticPolicy) Name() string               { return "static" }
+func (p *StaticPolicy) Params() map[string]float64 { return nil }
+
+func (p *StaticPolicy) NextLimits(pi *PodInfo, remainedTimePerSecond float64) {
+	pi.branch = BranchStatic
+	for _, ri := range pi.RIs {
+		ri.nextLimit = pi.TokenReservation / (float64(len(pi.RIs)) * ri.price)
+	}
+}
+
+// NewPolicy returns the policy called name with params.
+func NewPolicy(name string, params map[string]float64) (Policy, error) {
+	switch name {
+	case "", "kuscale":
+		return &KuScalePolicy{StaticV: params["staticV"]}, nil
+	case "static":
+		return &StaticPolicy{}, nil
+	}
+	return nil, fmt.Errorf("unknown policy %q", name)
+}
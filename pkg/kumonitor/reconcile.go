@@ -0,0 +1,231 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kumonitor
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// Actions of the reconciler on a drifted limit.
+const (
+	ReconcileReapply = "reapply" // write the limit of KuScale again
+	ReconcileReport  = "report"  // only log and count it, the next tick writes it again
+)
+
+// BranchReapply is the branch of the limits the reconciler writes again.
+const BranchReapply = "reapply"
+
+// Events of the reconciler, counted by Reconciler.Events.
+const (
+	EventDrifted   = "drifted"    // a limit in effect isn't the one KuScale wrote
+	EventReapplied = "reapplied"  // a drifted limit was written again
+	EventBroken    = "broken"     // the usage of a resource stays over its limit
+	EventReadError = "read_error" // a limit in effect couldn't be read
+)
+
+// LimitVerifier is a LimitReader which tells what ReadLimit returns once
+// limit is written, as the files round it.
+type LimitVerifier interface {
+	LimitReader
+	Enforced(ri *ResourceInfo, limit float64) float64
+}
+
+// Reconciler reads back the limits in effect, writes again those which
+// drifted from the limits of KuScale, and flags the resources whose usage
+// stays over their limit, as their enforcement is broken.
+type Reconciler struct {
+	m         *Monitor
+	verifier  LimitVerifier
+	interval  int64 // ns
+	action    string
+	tolerance float64 // percent the usage may go over the limit, for the jitter of the readings
+	breaches  int     // checks in a row over the limit before the enforcement is broken
+	last      int64
+
+	eventsMu sync.Mutex
+	events   map[string]uint64
+}
+
+/*
+Func Name : NewReconciler()
+Objective : 1) Reconcile the limits of m every interval, with action on the drifted ones
+			2) Flag the enforcement of a resource broken when its usage is over its limit
+			   plus tolerance breaches checks in a row
+*/
+func NewReconciler(m *Monitor, interval time.Duration, action string, tolerance float64, breaches int) (*Reconciler, error) {
+	if action != ReconcileReapply && action != ReconcileReport {
+		return nil, fmt.Errorf("unknown reconcile action %q, should be %s or %s", action, ReconcileReapply, ReconcileReport)
+	}
+	verifier, ok := m.host.(LimitVerifier)
+	if !ok {
+		return nil, fmt.Errorf("the host of the monitor can't read the limits back")
+	}
+	r := &Reconciler{
+		m:         m,
+		verifier:  verifier,
+		interval:  int64(interval),
+		action:    action,
+		tolerance: tolerance,
+		breaches:  breaches,
+		events:    make(map[string]uint64),
+	}
+	m.mu.Lock()
+	m.reconciler = r
+	m.mu.Unlock()
+	return r, nil
+}
+
+// Events returns how many times every event happened.
+func (r *Reconciler) Events() map[string]uint64 {
+	r.eventsMu.Lock()
+	defer r.eventsMu.Unlock()
+	events := map[string]uint64{EventDrifted: 0, EventReapplied: 0, EventBroken: 0, EventReadError: 0}
+	for e, n := range r.events {
+		events[e] = n
+	}
+	return events
+}
+
+func (r *Reconciler) event(e string) {
+	r.eventsMu.Lock()
+	defer r.eventsMu.Unlock()
+	r.events[e]++
+}
+
+// enforcedRead is a limit in effect read back, or why it couldn't be.
+type enforcedRead struct {
+	pi    *PodInfo
+	ri    *ResourceInfo
+	limit float64
+	err   error
+}
+
+/*
+Func Name : (r *Reconciler) reconcile()
+Objective : 1) Read the limits in effect of the running pods on the workers, once an interval
+			2) Act on those which drifted, and check the usage of the others against them.
+			   Call it in a tick, before the limits are decided.
+*/
+func (r *Reconciler) reconcile(now int64) {
+	if now-r.last < r.interval {
+		return
+	}
+	r.last = now
+
+	var reads []*enforcedRead
+	for _, pi := range r.m.RunningPodMap {
+		for _, rn := range pi.RNs {
+			// Never written by KuScale yet
+			if ri := pi.RIs[rn]; ri.limit != 0 {
+				reads = append(reads, &enforcedRead{pi: pi, ri: ri})
+			}
+		}
+	}
+	r.m.parallel(len(reads), func(i int) {
+		reads[i].limit, reads[i].err = r.verifier.ReadLimit(reads[i].ri)
+	})
+
+	var reapply []*enforcedRead
+	for _, read := range reads {
+		pi, ri := read.pi, read.ri
+		if read.err != nil {
+			klog.V(4).Info("Couldn't read ", pi.PodName, "'s ", ri.name, " limit in effect : ", read.err)
+			r.event(EventReadError)
+			continue
+		}
+		ri.enforcedLimit = read.limit
+		want := r.verifier.Enforced(ri, ri.limit)
+		ri.drifted = math.Abs(read.limit-want) > 0.01
+		if ri.drifted && ri.resizedLimit != 0 && math.Abs(read.limit-ri.resizedLimit) <= 0.01 {
+			// Written by the kubelet, which would write it again over a reapply
+			klog.V(4).Info(pi.PodName, "'s ", ri.name, " limit in effect is ", read.limit, " as resized through Kubernetes")
+			ri.limit, ri.drifted = read.limit, false
+		}
+		if ri.drifted {
+			r.event(EventDrifted)
+			klog.Warning(pi.PodName, "'s ", ri.name, " limit in effect is ", read.limit, " instead of ", want)
+			if r.action == ReconcileReapply {
+				reapply = append(reapply, read)
+			}
+			continue
+		}
+		r.checkUsage(pi, ri)
+	}
+	r.reapply(reapply)
+}
+
+// reapply writes the limits of KuScale again over the drifted ones, those of
+// the batched resources in a transaction per resource rather than one per pod,
+// and tells the decision observers.
+func (r *Reconciler) reapply(reads []*enforcedRead) {
+	if len(reads) == 0 {
+		return
+	}
+	now := r.m.host.Now()
+	ris, limits := make([]*ResourceInfo, len(reads)), make([]float64, len(reads))
+	for i, read := range reads {
+		ris[i], limits[i] = read.ri, read.ri.limit
+	}
+	for i, err := range r.m.setLimits(ris, limits) {
+		ri := ris[i]
+		d := newDecision(now, reads[i].pi, ri, "reconciler", 0)
+		d.Branch, d.ProposedLimit, d.Outcome, d.NewLimit, d.Err = BranchReapply, limits[i], OutcomeApplied, ri.limit, err
+		if err != nil {
+			d.Outcome = OutcomeFailed
+		} else {
+			ri.enforcedLimit, ri.drifted = r.verifier.Enforced(ri, ri.limit), false
+			r.event(EventReapplied)
+		}
+		r.m.decided(&d)
+	}
+}
+
+// Resized tells the reconciler that the limit of rn of a pod was resized in
+// place through Kubernetes, so that the limit the kubelet then writes is not
+// taken as a drift but as the limit of the pod, until the next tick writes
+// its own.
+func (m *Monitor) Resized(namespace, podName string, rn ResourceName, limit float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if pi, ok := m.RunningPodMap[PodKey(namespace, podName)]; ok {
+		if ri, ok := pi.RIs[rn]; ok {
+			ri.resizedLimit = limit
+		}
+	}
+}
+
+// checkUsage flags the enforcement of ri broken when its usage of the last
+// tick, which ran under ri.limit, stays over it.
+func (r *Reconciler) checkUsage(pi *PodInfo, ri *ResourceInfo) {
+	if ri.limit < 0 || ri.usage <= ri.limit+r.tolerance {
+		if ri.broken {
+			klog.Info(pi.PodName, "'s ", ri.name, " usage is back under its limit")
+		}
+		ri.overLimit, ri.broken = 0, false
+		return
+	}
+	ri.overLimit++
+	if ri.overLimit >= r.breaches && !ri.broken {
+		ri.broken = true
+		r.event(EventBroken)
+		klog.Error(pi.PodName, "'s ", ri.name, " usage ", ri.usage, " stays over its limit ", ri.limit,
+			", its enforcement is broken")
+	}
+}
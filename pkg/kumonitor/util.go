@@ -15,16 +15,11 @@
 package kumonitor
 
 import (
-	"fmt"
-	"io/ioutil"
-	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
-	"time"
 
-	"golang.org/x/sys/unix"
-	"k8s.io/klog"
+	"github.com/sslab-konkuk/KuScale/pkg/kuclock"
+	"github.com/sslab-konkuk/KuScale/pkg/kufs"
 )
 
 func postive(x float64) float64 {
@@ -36,88 +31,66 @@ func postive(x float64) float64 {
 
 // stat -fc %T /sys/fs/cgroup/
 
-func ReadCPUMax(cpuPath string) (uint64) {
+func ReadCPUMax(fs kufs.FS, cpuPath string) (uint64, error) {
 	var quota uint64
-	contents, err := ioutil.ReadFile(filepath.Join(cpuPath, "/cpu.max"))
+	contents, err := fs.ReadFile(kufs.Join(cpuPath, "/cpu.max"))
 	if err != nil {
-		klog.V(2).Infof("couldn't ReadCPUMax: %s", err)
-		return 0
+		return 0, err
+	}
+	values := strings.Fields(string(contents))
+	if len(values) == 0 {
+		return 0, &kufs.Error{Op: "parse", Path: kufs.Join(cpuPath, "/cpu.max"), Kind: kufs.ErrInvalid}
 	}
-	values := strings.Split(string(contents), " ")
 	if values[0] == "max" {
 		quota = 600000
 	} else {
-		quota = parseUint(values[0], 10, 64)
+		quota, err = kufs.ParseUint(values[0])
+		if err != nil {
+			return 0, &kufs.Error{Op: "parse", Path: kufs.Join(cpuPath, "/cpu.max"), Kind: kufs.ErrInvalid, Err: err}
+		}
 	}
 	// period, _ := strconv.ParseUint(values[1], 10, 64)
-	return quota
+	return quota, nil
 }
 
-func WriteCPUMax(cpuPath string, quota uint64) {
+func WriteCPUMax(fs kufs.FS, cpuPath string, quota uint64) error {
 	contents := strconv.FormatUint(quota, 10) + " 100000"
-	err := ioutil.WriteFile(filepath.Join(cpuPath, "/cpu.max"), []byte(contents), os.FileMode(0777))
-	if err != nil {
-		klog.Infof("%s %d %s", err, quota, cpuPath)
-	}
+	return fs.WriteFile(kufs.Join(cpuPath, "/cpu.max"), []byte(contents))
 }
 
-func ReadCPUStat(cpuPath string) (uint64, bool){
-	contents, err := ioutil.ReadFile(filepath.Join(cpuPath, "/cpu.stat"))
+func ReadCPUStat(fs kufs.FS, cpuPath string) (uint64, error) {
+	path := kufs.Join(cpuPath, "/cpu.stat")
+	contents, err := fs.ReadFile(path)
 	if err != nil {
-		klog.V(2).Infof("couldn't ReadCPUStat: %s", err)
-		return 0, true
+		return 0, err
 	}
 	lines := strings.Split(string(contents), "\n")
 	values := strings.Split(lines[0], " ")
-	if values[0] == "usage_usec" {
-		usage := parseUint(values[1], 10, 64)
-		return usage * 1000, false
-	} else {
-		klog.V(2).Infof("couldn't ReadCPUStat:")
-		return 0, true
+	if values[0] != "usage_usec" || len(values) < 2 {
+		return 0, &kufs.Error{Op: "parse", Path: path, Kind: kufs.ErrInvalid}
 	}
-}
-
-func setFileUint(value uint64, path, file string) {
-	err := ioutil.WriteFile(filepath.Join(path, file), []byte(strconv.FormatUint(uint64(value), 10)), os.FileMode(0777))
+	usage, err := kufs.ParseUint(values[1])
 	if err != nil {
-		klog.Infof("%s %d %s %s", err, value, path, file)
+		return 0, &kufs.Error{Op: "parse", Path: path, Kind: kufs.ErrInvalid, Err: err}
 	}
+	return usage * 1000, nil
 }
 
-func parseUint(s string, base, bitSize int) uint64 {
-	value, err := strconv.ParseUint(s, base, bitSize)
-	if err != nil {
-		intValue, intErr := strconv.ParseInt(s, base, bitSize)
-		if intErr == nil && intValue < 0 {
-			return 0
-		} else if intErr != nil && intErr.(*strconv.NumError).Err == strconv.ErrRange && intValue < 0 {
-			return 0
-		}
-		return value
-	}
-	return value
+func setFileUint(fs kufs.FS, value uint64, path, file string) error {
+	return kufs.WriteUint(fs, kufs.Join(path, file), value)
 }
 
-func GetFileParamUint(Path, File string) uint64 {
-	contents, err := ioutil.ReadFile(filepath.Join(Path, File))
-	if err != nil {
-		klog.V(2).Infof("couldn't GetFileParamUint: %s", err)
-		return 0
-	}
-	return parseUint(strings.TrimSpace(string(contents)), 10, 64)
+func GetFileParamUint(fs kufs.FS, Path, File string) (uint64, error) {
+	return kufs.ReadUint(fs, kufs.Join(Path, File))
 }
 
-func PathExists(path string) bool {
-	if _, err := os.Stat(path); err != nil {
-		return false
-	}
-	return true
+func PathExists(fs kufs.FS, path string) bool {
+	return fs.Exists(path)
 }
 
-func CheckPodPath(pi *PodInfo) bool {
+func CheckPodPath(fs kufs.FS, pi *PodInfo) bool {
 	for _, ri := range pi.RIs {
-		if !PathExists(ri.path) {
+		if !PathExists(fs, ri.path) {
 			return false
 		}
 	}
@@ -139,38 +112,22 @@ func CalAvg(array []uint64, windowSize int) float64 {
 	return float64(avg)
 }
 
-func GetMtime() (uint64, error) {
-	var ts unix.Timespec
-
-	err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts)
-	if err != nil {
-		return 0, fmt.Errorf("Unable get time: %s", err)
-	}
-
-	return uint64(unix.TimespecToNsec(ts)), nil
-}
-
-func GetFileUint(path string) (uint64, bool) {
-	contents, err := ioutil.ReadFile(path)
-	if err != nil {
-		klog.V(2).Infof("couldn't GetFileParamUint: %s", err)
-		return 0, true
-	}
-	return parseUint(strings.TrimSpace(string(contents)), 10, 64), false
+func GetFileUint(fs kufs.FS, path string) (uint64, error) {
+	return kufs.ReadUint(fs, path)
 }
 
 /* Get AcctUsage Functions From Cgroup or GPU Virt */
-func GetCpuAcctUsage(cpuPath string) (uint64, uint64) {
-	now := uint64(time.Now().UnixNano())
-	// now, _ := GetMtime()
-	return GetFileParamUint(cpuPath, "/cpuacct.usage"), now
+func GetCpuAcctUsage(fs kufs.FS, clock kuclock.Clock, cpuPath string) (uint64, uint64, error) {
+	now := uint64(clock.Now())
+	usage, err := GetFileParamUint(fs, cpuPath, "/cpuacct.usage")
+	return usage, now, err
 	// return ReadCPUStat(cpuPath)*1000, now
 }
 
-func GetGpuAcctUsage(gpuPath string) (uint64, uint64) {
-	now := uint64(time.Now().UnixNano())
-	// now, _ := GetMtime()
-	return GetFileParamUint(gpuPath, "/total_runtime"), now
+func GetGpuAcctUsage(fs kufs.FS, clock kuclock.Clock, gpuPath string) (uint64, uint64, error) {
+	now := uint64(clock.Now())
+	usage, err := GetFileParamUint(fs, gpuPath, "/total_runtime")
+	return usage, now, err
 }
 
 // func GetRxAcctUsage(pi *PodInfo) (uint64) {
@@ -190,9 +147,6 @@ func GetGpuAcctUsage(gpuPath string) (uint64, uint64) {
 
 // /* Set Limit Functions */
 
-func UpdateGemini() {
-	setFileUint(0, "/sys/kernel/gpu/gemini", "/resource_conf")
-}
 
 // func SetCpuLimit(pi *PodInfo, nextCpu float64) {
 // 	if nextCpu > 1000 || nextCpu < 0 {
@@ -0,0 +1,373 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kumonitor
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kuclock"
+	"github.com/sslab-konkuk/KuScale/pkg/kuhealth"
+	"k8s.io/klog"
+)
+
+// BranchFailSafe is the branch of the fail-safe limits of the watchdog.
+const BranchFailSafe = "failSafe"
+
+// Transitions of the watchdog, counted by Watchdog.Transitions.
+const (
+	TransitionEngaged      = "engaged"       // the control loop stalled, every pod is on fail-safe limits
+	TransitionRecovered    = "recovered"     // the control loop ticks again
+	TransitionPodFailSafe  = "pod_failsafe"  // the usage readings of a pod are stale
+	TransitionPodRecovered = "pod_recovered" // the usage readings of a pod came back
+)
+
+// writeTracker tells which limits are being written since when, so that the
+// watchdog can tell a hung write without the monitor lock, and which fail-safe
+// limits the watchdog wrote since the last tick.
+type writeTracker struct {
+	mu       sync.Mutex
+	inFlight map[*ResourceInfo]int64 // start in ns
+	failSafe map[*ResourceInfo]failSafeWrite
+}
+
+// failSafeWrite is a fail-safe limit written without the monitor lock, which
+// the next tick records as the limit of ri.
+type failSafeWrite struct {
+	pi    *PodInfo
+	limit float64
+}
+
+func (t *writeTracker) begin(ri *ResourceInfo, now int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.inFlight == nil {
+		t.inFlight = make(map[*ResourceInfo]int64)
+	}
+	t.inFlight[ri] = now
+}
+
+// end is called once a limit of ri is written, which is newer than the
+// fail-safe limit written before, if any.
+func (t *writeTracker) end(ri *ResourceInfo) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.inFlight, ri)
+	delete(t.failSafe, ri)
+}
+
+func (t *writeTracker) failSafeWritten(pi *PodInfo, ri *ResourceInfo, limit float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.failSafe == nil {
+		t.failSafe = make(map[*ResourceInfo]failSafeWrite)
+	}
+	t.failSafe[ri] = failSafeWrite{pi: pi, limit: limit}
+}
+
+// takeFailSafe returns the fail-safe limits written since it was last called.
+func (t *writeTracker) takeFailSafe() map[*ResourceInfo]failSafeWrite {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	written := t.failSafe
+	t.failSafe = nil
+	return written
+}
+
+// oldest returns the write in progress for the longest, if any.
+func (t *writeTracker) oldest() (string, int64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var what string
+	var start int64
+	for ri, s := range t.inFlight {
+		if start == 0 || s < start {
+			what, start = string(ri.name)+" limit at "+ri.path, s
+		}
+	}
+	return what, start, start != 0
+}
+
+// Watchdog moves the pods to fail-safe limits when the control loop stops
+// ticking or a limit write hangs, and a pod whose usage readings are stale,
+// and gives them back to the policy once it recovers.
+type Watchdog struct {
+	m      *Monitor
+	grace  int64 // ns
+	safe   map[ResourceName]float64
+	health *kuhealth.Component
+
+	engaged       int32 // atomic, the control loop is stalled
+	failSafePods  int64 // atomic, pods on fail-safe limits for stale readings
+	transitionsMu sync.Mutex
+	transitions   map[string]uint64
+}
+
+/*
+Func Name : NewWatchdog()
+Objective : 1) Watch m, putting the pods on the limits set before KuScale after grace, or on the safe limits when they are unknown
+			2) Report the watchdog as a health component
+*/
+func NewWatchdog(m *Monitor, grace time.Duration, safe map[ResourceName]float64) *Watchdog {
+	w := &Watchdog{
+		m:           m,
+		grace:       int64(grace),
+		safe:        safe,
+		health:      kuhealth.Register("watchdog"),
+		transitions: make(map[string]uint64),
+	}
+	m.mu.Lock()
+	m.watchdog = w
+	m.mu.Unlock()
+	return w
+}
+
+// Engaged tells whether the control loop is stalled and the pods are on
+// fail-safe limits. It doesn't need the monitor lock.
+func (w *Watchdog) Engaged() bool { return atomic.LoadInt32(&w.engaged) == 1 }
+
+// FailSafePods is the number of pods on fail-safe limits for stale readings.
+func (w *Watchdog) FailSafePods() int { return int(atomic.LoadInt64(&w.failSafePods)) }
+
+// Transitions returns how many times the watchdog went through every transition.
+func (w *Watchdog) Transitions() map[string]uint64 {
+	w.transitionsMu.Lock()
+	defer w.transitionsMu.Unlock()
+	transitions := map[string]uint64{
+		TransitionEngaged: 0, TransitionRecovered: 0, TransitionPodFailSafe: 0, TransitionPodRecovered: 0,
+	}
+	for t, n := range w.transitions {
+		transitions[t] = n
+	}
+	return transitions
+}
+
+func (w *Watchdog) transition(t string) {
+	w.transitionsMu.Lock()
+	defer w.transitionsMu.Unlock()
+	w.transitions[t]++
+}
+
+// stall returns why the control loop is stalled, or nil.
+func (w *Watchdog) stall(now int64) error {
+	m := w.m
+	if what, start, ok := m.writes.oldest(); ok && now-start > w.grace {
+		return fmt.Errorf("writing %s is stuck for %s", what, time.Duration(now-start).Round(time.Millisecond))
+	}
+	if start := atomic.LoadInt64(&m.tickRunning); start != 0 && now-start > w.grace {
+		return fmt.Errorf("tick is stuck for %s", time.Duration(now-start).Round(time.Millisecond))
+	}
+	if last := m.LastTick(); last != 0 && now-last > int64(m.config.monitoringPeriod)+w.grace {
+		return fmt.Errorf("last tick was %s ago", time.Duration(now-last).Round(time.Millisecond))
+	}
+	return nil
+}
+
+/*
+Func Name : (w *Watchdog) Run()
+Objective : 1) Check the control loop every second until stopCh is closed
+			2) Engage when it stalls longer than the grace, and recover when it ticks again
+*/
+func (w *Watchdog) Run(stopCh chan string) {
+	klog.V(4).Info("Starting Watchdog")
+	ticker := w.m.clock.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			w.health.Stopped()
+			return
+		case <-ticker.C():
+			w.check()
+		}
+	}
+}
+
+func (w *Watchdog) check() {
+	err := w.stall(w.m.host.Now())
+	switch {
+	case err != nil && !w.Engaged():
+		atomic.StoreInt32(&w.engaged, 1)
+		w.transition(TransitionEngaged)
+		klog.Error("Control loop stalled, moving every pod to fail-safe limits : ", err)
+		w.failSafeAll()
+	case err == nil && w.Engaged():
+		atomic.StoreInt32(&w.engaged, 0)
+		w.transition(TransitionRecovered)
+		klog.Info("Control loop recovered, the policy sets the limits again")
+	}
+
+	if err != nil {
+		w.health.Degraded(err)
+	} else if n := w.FailSafePods(); n > 0 {
+		w.health.Degraded(fmt.Errorf("%d pods on fail-safe limits for stale usage readings", n))
+	} else {
+		w.health.Healthy()
+	}
+}
+
+/*
+Func Name : (w *Watchdog) failSafeAll()
+Objective : 1) Write the fail-safe limits of the pods of the last tick without the monitor lock, which the stalled loop may hold
+			2) Write those of the host's batched resources in one transaction, guaranteeing each its share of the capacity
+			3) Leave a write which hangs too behind, and keep those written for the next tick to record
+*/
+func (w *Watchdog) failSafeAll() {
+	last, _ := w.m.published.Load().(*publishedTick)
+	if last == nil {
+		return
+	}
+	var single []BatchWrite
+	batches := make(map[ResourceName][]BatchWrite)
+	owners := make(map[*ResourceInfo]*PodInfo)
+	bw, batching := w.m.host.(BatchWriter)
+	for _, pi := range last.pods {
+		for _, rn := range pi.RNs {
+			ri := pi.RIs[rn]
+			owners[ri] = pi
+			b := BatchWrite{RI: ri, Limit: ri.safeLimit(w.safe)}
+			if batching && bw.Batched(rn) {
+				batches[rn] = append(batches[rn], b)
+			} else {
+				single = append(single, b)
+			}
+		}
+	}
+
+	for _, b := range single {
+		b := b
+		err, done := awaitWrite(w.m.clock, func() error { return w.m.host.WriteLimit(b.RI, b.Limit) })
+		if !done {
+			klog.Error("Setting ", owners[b.RI].PodName, "'s ", b.RI.name, " fail-safe limit hangs")
+		} else if err != nil {
+			klog.Error("Couldn't set ", owners[b.RI].PodName, "'s ", b.RI.name, " fail-safe limit : ", err)
+		} else {
+			w.m.writes.failSafeWritten(owners[b.RI], b.RI, b.Limit)
+		}
+	}
+	for rn, batch := range batches {
+		guarantee(batch, 0, last.capacity[rn])
+		var errs []error
+		err, done := awaitWrite(w.m.clock, func() (err error) {
+			errs, err = bw.WriteBatch(batch, last.workers)
+			return err
+		})
+		if !done {
+			klog.Error("Setting the ", len(batch), " ", rn, " fail-safe limits hangs")
+			continue
+		} else if err != nil {
+			klog.Error("Couldn't set the ", len(batch), " ", rn, " fail-safe limits, rolled back : ", err)
+			continue
+		}
+		for i, b := range batch {
+			if errs[i] != nil {
+				klog.Error("Couldn't set ", owners[b.RI].PodName, "'s ", rn, " fail-safe limit : ", errs[i])
+			} else {
+				w.m.writes.failSafeWritten(owners[b.RI], b.RI, b.Limit)
+			}
+		}
+	}
+}
+
+// awaitWrite runs write for a second of clock at most, and tells whether it returned.
+func awaitWrite(clock kuclock.Clock, write func() error) (error, bool) {
+	result := make(chan error, 1)
+	go func() { result <- write() }()
+	timeout := clock.NewTicker(time.Second)
+	defer timeout.Stop()
+	select {
+	case err := <-result:
+		return err, true
+	case <-timeout.C():
+		return nil, false
+	}
+}
+
+// recordFailSafe sets the fail-safe limits failSafeAll wrote as the limits of
+// the pods still running, and tells the observers. Call it in a tick.
+func (w *Watchdog) recordFailSafe(now int64) {
+	for ri, written := range w.m.writes.takeFailSafe() {
+		pi := written.pi
+		if pi.status == PodCompleted {
+			continue
+		}
+		d := newDecision(now, pi, ri, "watchdog", 0)
+		d.Branch, d.ProposedLimit, d.Outcome = BranchFailSafe, written.limit, OutcomeApplied
+		ri.limit = written.limit
+		d.NewLimit = ri.limit
+		w.m.decided(&d)
+	}
+}
+
+// staleReadings tells whether a resource of pi hasn't been read for the grace.
+func (w *Watchdog) staleReadings(now int64, pi *PodInfo) bool {
+	for _, ri := range pi.RIs {
+		last := ri.acctUsageAndTime[len(ri.acctUsageAndTime)-1].timeStamp
+		if now-int64(last) > w.grace {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Func Name : (w *Watchdog) failSafe()
+Objective : 1) Tell whether the usage readings of pi are stale, and return the writes of its fail-safe limits but its pinned ones when they just went stale
+			2) Give pi back to the policy once they come back. Call it in a tick.
+*/
+func (w *Watchdog) failSafe(now int64, pi *PodInfo) (bool, []*limitWrite) {
+	stale := w.staleReadings(now, pi)
+	if stale == pi.failSafe {
+		return stale, nil
+	}
+	pi.failSafe = stale
+	if !stale {
+		atomic.AddInt64(&w.failSafePods, -1)
+		w.transition(TransitionPodRecovered)
+		klog.Info(pi.PodName, "'s usage readings came back, the policy sets its limits again")
+		w.m.controlled(&Control{Time: now, Pod: pi.PodName, Namespace: pi.Namespace, Action: ControlRecovered})
+		return false, nil
+	}
+
+	atomic.AddInt64(&w.failSafePods, 1)
+	w.transition(TransitionPodFailSafe)
+	klog.Warning(pi.PodName, "'s usage readings are stale, moving it to fail-safe limits")
+	var writes []*limitWrite
+	for _, rn := range pi.RNs {
+		ri := pi.RIs[rn]
+		d := newDecision(now, pi, ri, "watchdog", 0)
+		d.Branch, d.ProposedLimit, d.Outcome = BranchFailSafe, ri.safeLimit(w.safe), OutcomeApplied
+		if _, _, pinned := ri.Pinned(); pinned {
+			// A pin is the operator's call, it stays
+			d.Outcome, d.NewLimit = OutcomePinned, ri.limit
+			w.m.decided(&d)
+			continue
+		}
+		// Written with the limits of the other pods, in the write stage
+		ri.nextLimit = d.ProposedLimit
+		writes = append(writes, &limitWrite{ri: ri, d: d})
+	}
+	return true, writes
+}
+
+// forget stops counting pi, which is completed, as on fail-safe limits.
+func (w *Watchdog) forget(pi *PodInfo) {
+	if pi.failSafe {
+		pi.failSafe = false
+		atomic.AddInt64(&w.failSafePods, -1)
+	}
+}
@@ -2,6 +2,7 @@ package kuprofiler
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"time"
 
@@ -99,13 +100,18 @@ func RecordEnd(funcName string) {
 }
 
 func Summary() {
-	if !li.enableFlag {
+	Fprint(os.Stdout)
+}
+
+// Fprint writes the histogram and the average latency of every function to w.
+func Fprint(w io.Writer) {
+	if li == nil || !li.enableFlag {
 		return
 	}
 	for name, data := range li.latencyData {
-		fmt.Fprintf(os.Stdout, "\n Hitogram Func [%s] \n", name)
+		fmt.Fprintf(w, "\n Hitogram Func [%s] \n", name)
 		histdata := hist.Hist(10, data.duration)
-		_ = hist.Fprintf(os.Stdout, histdata, hist.Linear(50), func(v float64) string {
+		_ = hist.Fprintf(w, histdata, hist.Linear(50), func(v float64) string {
 			return time.Duration(v).String()
 		})
 
@@ -113,7 +119,7 @@ func Summary() {
 		for _, duration := range data.duration {
 			sum += duration
 		}
-		fmt.Fprintln(os.Stdout, name, "Average :", time.Duration(sum/float64(len(data.duration))).String())
+		fmt.Fprintln(w, name, "Average :", time.Duration(sum/float64(len(data.duration))).String())
 		// fmt.Fprintln(os.Stdout, data)
 	}
 }
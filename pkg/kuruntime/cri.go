@@ -0,0 +1,156 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kuruntime
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Versions of the CRI runtime service, the newest first.
+var criServices = []string{"runtime.v1.RuntimeService", "runtime.v1alpha2.RuntimeService"}
+
+// CRI updates the containers through the runtime service of a CRI socket.
+// Only UpdateContainerResources is called, so its request is encoded here
+// rather than depending on k8s.io/cri-api.
+type CRI struct {
+	socket string
+
+	mu      sync.Mutex
+	conn    *grpc.ClientConn
+	service string // the version which answered, tried first
+}
+
+func NewCRI(socket string) *CRI { return &CRI{socket: socket} }
+
+func (c *CRI) Name() string { return WriterCRI }
+
+// dial returns the connection to the socket, connecting it if needed.
+func (c *CRI) dial(ctx context.Context) (*grpc.ClientConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		return c.conn, nil
+	}
+	conn, err := grpc.DialContext(ctx, c.socket, grpc.WithInsecure(), grpc.WithBlock(),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", addr)
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s : %w", c.socket, err)
+	}
+	c.conn = conn
+	return conn, nil
+}
+
+func (c *CRI) services() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.service == "" {
+		return criServices
+	}
+	return []string{c.service}
+}
+
+/*
+Func Name : (c *CRI) UpdateCPU()
+Objective : 1) Call UpdateContainerResources with the CFS period and quota of id
+			2) Try the older version of the runtime service when the newer is unimplemented
+*/
+func (c *CRI) UpdateCPU(ctx context.Context, id string, period, quota int64) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	request := updateContainerResourcesRequest(id, period, quota)
+	for _, service := range c.services() {
+		var response []byte
+		err = conn.Invoke(ctx, "/"+service+"/UpdateContainerResources", request, &response, grpc.ForceCodec(rawCodec{}))
+		if status.Code(err) == codes.Unimplemented {
+			continue
+		}
+		if err == nil {
+			c.mu.Lock()
+			c.service = service
+			c.mu.Unlock()
+		}
+		return err
+	}
+	return err
+}
+
+// Close closes the connection to the socket.
+func (c *CRI) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// updateContainerResourcesRequest encodes
+//
+//	UpdateContainerResourcesRequest{container_id: id, linux: LinuxContainerResources{cpu_period, cpu_quota}}
+//
+// which is the same in runtime.v1 and runtime.v1alpha2. The other resources
+// are left at zero, which the runtimes don't change.
+func updateContainerResourcesRequest(id string, period, quota int64) []byte {
+	var linux []byte
+	linux = protowire.AppendTag(linux, 1, protowire.VarintType) // cpu_period
+	linux = protowire.AppendVarint(linux, uint64(period))
+	linux = protowire.AppendTag(linux, 2, protowire.VarintType) // cpu_quota
+	linux = protowire.AppendVarint(linux, uint64(quota))
+
+	var request []byte
+	request = protowire.AppendTag(request, 1, protowire.BytesType) // container_id
+	request = protowire.AppendString(request, id)
+	request = protowire.AppendTag(request, 2, protowire.BytesType) // linux
+	request = protowire.AppendBytes(request, linux)
+	return request
+}
+
+// rawCodec passes the encoded messages through, as the proto codec.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("rawCodec can't marshal %T", v)
+	}
+	return b, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("rawCodec can't unmarshal into %T", v)
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string { return "proto" }
@@ -0,0 +1,88 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kuruntime applies the CPU limits of KuScale through the update API
+// of the container runtime, so that the runtime and kubelet know them.
+package kuruntime
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// Writers of the CPU limits of a node.
+const (
+	WriterCgroup = "cgroup" // cpu.cfs_quota_us of the container, unknown to the runtime
+	WriterDocker = "docker" // ContainerUpdate of the docker engine
+	WriterCRI    = "cri"    // UpdateContainerResources of the CRI runtime service
+)
+
+// Updater sets the CFS period and quota in us of a container, -1 being no quota.
+type Updater interface {
+	Name() string
+	UpdateCPU(ctx context.Context, container string, period, quota int64) error
+}
+
+// New returns the Updater of writer, or nil for the cgroup writer.
+func New(writer, criSocket string) (Updater, error) {
+	switch writer {
+	case WriterCgroup:
+		return nil, nil
+	case WriterDocker:
+		return NewDocker(), nil
+	case WriterCRI:
+		return NewCRI(criSocket), nil
+	}
+	return nil, fmt.Errorf("unknown limit writer %q, should be %s, %s or %s", writer, WriterCgroup, WriterDocker, WriterCRI)
+}
+
+// Docker updates the containers through the docker engine API.
+type Docker struct {
+	mu  sync.Mutex
+	cli *client.Client
+}
+
+func NewDocker() *Docker { return &Docker{} }
+
+func (d *Docker) Name() string { return WriterDocker }
+
+// client returns the docker client, connecting it if needed.
+func (d *Docker) client() (*client.Client, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cli != nil {
+		return d.cli, nil
+	}
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+	d.cli = cli
+	return cli, nil
+}
+
+func (d *Docker) UpdateCPU(ctx context.Context, id string, period, quota int64) error {
+	cli, err := d.client()
+	if err != nil {
+		return err
+	}
+	_, err = cli.ContainerUpdate(ctx, id, container.UpdateConfig{
+		Resources: container.Resources{CPUPeriod: period, CPUQuota: quota},
+	})
+	return err
+}
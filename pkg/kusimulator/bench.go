@@ -0,0 +1,204 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kusimulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kuclock"
+	"github.com/sslab-konkuk/KuScale/pkg/kufs"
+	"github.com/sslab-konkuk/KuScale/pkg/kumonitor"
+)
+
+// BenchConfig is a benchmark of the control loop on the files of the host,
+// with synthetic pods on an in-memory cgroup and ku-gpu-layer module.
+type BenchConfig struct {
+	Pods    int
+	Ticks   int
+	Workers int
+	Period  float64 // seconds
+	StaticV float64
+	// IOLatency is added to every file read and write, like the syscalls on
+	// cgroup and sysfs files take on a busy node.
+	IOLatency time.Duration
+}
+
+type BenchReport struct {
+	Pods      int     `json:"pods"`
+	Workers   int     `json:"workers"`
+	Ticks     int     `json:"ticks"`
+	Period    float64 `json:"period"`    // seconds
+	IOLatency float64 `json:"ioLatency"` // seconds
+
+	// Tick latencies in seconds
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+	// Overruns are the ticks longer than the period.
+	Overruns int `json:"overruns"`
+
+	ReadsPerTick  float64 `json:"readsPerTick"`
+	WritesPerTick float64 `json:"writesPerTick"`
+}
+
+// slowFS counts the file operations and delays them by latency.
+type slowFS struct {
+	kufs.FS
+	latency       time.Duration
+	reads, writes int64
+}
+
+func (fs *slowFS) ReadFile(path string) ([]byte, error) {
+	atomic.AddInt64(&fs.reads, 1)
+	time.Sleep(fs.latency)
+	return fs.FS.ReadFile(path)
+}
+
+func (fs *slowFS) WriteFile(path string, data []byte) error {
+	atomic.AddInt64(&fs.writes, 1)
+	time.Sleep(fs.latency)
+	return fs.FS.WriteFile(path, data)
+}
+
+type benchPod struct {
+	cpuPath, gpuPath     string
+	cpuDemand, gpuDemand float64 // percent
+	cpuUsage, gpuUsage   float64 // ns
+}
+
+/*
+Func Name : RunBench()
+Objective : 1) Lay out config.Pods pods with changing demands on an in-memory host
+			2) Time config.Ticks ticks of the real control loop on it
+*/
+func RunBench(config BenchConfig) *BenchReport {
+	mem := kufs.NewMemFS()
+	roots := kufs.Roots{Cgroup: "/cgroup", GPU: "/gpu", Proc: "/proc"}
+	for name, contents := range map[string]string{
+		"configs/init": "", "configs/destroy": "", "gemini/resource_conf": "",
+		"configs/totalIDs": strconv.Itoa(config.Pods),
+	} {
+		mem.SetFile(kufs.Join(roots.GPU, name), []byte(contents))
+	}
+
+	fs := &slowFS{FS: mem}
+	clock := kuclock.NewFake(0)
+	period := time.Duration(config.Period * float64(time.Second))
+	m := kumonitor.NewMonitorWithHost(period, 15, "bench", false, config.StaticV,
+		kumonitor.NewFSHostWithClock(fs, roots, clock))
+	m.SetWorkers(config.Workers)
+
+	random := rand.New(rand.NewSource(1))
+	pods := make([]*benchPod, config.Pods)
+	for i := range pods {
+		bp := &benchPod{
+			cpuPath:   kufs.Join(roots.Cgroup, "kubepods", "pod"+strconv.Itoa(i)),
+			gpuPath:   kufs.Join(roots.GPU, "IDs", strconv.Itoa(i)),
+			cpuDemand: 10 + random.Float64()*190,
+			gpuDemand: 5 + random.Float64()*60,
+		}
+		mem.SetFile(kufs.Join(bp.cpuPath, "cpu.stat"), []byte("usage_usec 0\n"))
+		mem.SetFile(kufs.Join(bp.cpuPath, "cpu.cfs_quota_us"), []byte("-1"))
+		for _, name := range []string{"total_runtime", "gpu_limit", "gpu_request"} {
+			mem.SetFile(kufs.Join(bp.gpuPath, name), []byte("0"))
+		}
+		pi := kumonitor.NewPodInfoWithPaths("bench-"+strconv.Itoa(i), bp.cpuPath, bp.gpuPath)
+		pi.TokenReservation = 100 + random.Float64()*200
+		m.AddPod(pi)
+		pods[i] = bp
+	}
+	fs.latency = config.IOLatency
+	atomic.StoreInt64(&fs.reads, 0)
+	atomic.StoreInt64(&fs.writes, 0)
+
+	latencies := make([]float64, 0, config.Ticks)
+	sum := 0.
+	for t := 0; t < config.Ticks; t++ {
+		for _, bp := range pods {
+			bp.cpuDemand = clamp(bp.cpuDemand+random.NormFloat64()*10, 5, 400)
+			bp.gpuDemand = clamp(bp.gpuDemand+random.NormFloat64()*5, 1, 100)
+			bp.cpuUsage += bp.cpuDemand / 100 * config.Period * 1e9
+			bp.gpuUsage += bp.gpuDemand / 100 * config.Period * 1e9
+			mem.SetFile(kufs.Join(bp.cpuPath, "cpu.stat"), []byte(fmt.Sprintf("usage_usec %d\n", uint64(bp.cpuUsage/1000))))
+			mem.SetFile(kufs.Join(bp.gpuPath, "total_runtime"), []byte(strconv.FormatUint(uint64(bp.gpuUsage), 10)))
+		}
+		clock.Advance(period)
+
+		start := time.Now()
+		m.MonitorAndAutoScale()
+		latency := time.Since(start).Seconds()
+		latencies = append(latencies, latency)
+		sum += latency
+	}
+
+	r := &BenchReport{
+		Pods:      config.Pods,
+		Workers:   config.Workers,
+		Ticks:     config.Ticks,
+		Period:    config.Period,
+		IOLatency: config.IOLatency.Seconds(),
+	}
+	if len(latencies) == 0 {
+		return r
+	}
+	sort.Float64s(latencies)
+	at := func(q float64) float64 { return latencies[int(q*float64(len(latencies)-1))] }
+	r.Mean, r.P50, r.P90, r.P99, r.Max = sum/float64(len(latencies)), at(0.5), at(0.9), at(0.99), latencies[len(latencies)-1]
+	for _, latency := range latencies {
+		if latency > config.Period {
+			r.Overruns++
+		}
+	}
+	r.ReadsPerTick = float64(atomic.LoadInt64(&fs.reads)) / float64(config.Ticks)
+	r.WritesPerTick = float64(atomic.LoadInt64(&fs.writes)) / float64(config.Ticks)
+	return r
+}
+
+func clamp(x, min, max float64) float64 {
+	if x < min {
+		return min
+	} else if x > max {
+		return max
+	}
+	return x
+}
+
+func WriteBenchJSON(w io.Writer, reports []*BenchReport) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(reports)
+}
+
+func WriteBenchTable(w io.Writer, reports []*BenchReport) error {
+	ms := func(s float64) string { return fmt.Sprintf("%.2fms", s*1000) }
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "PODS\tWORKERS\tIO LATENCY\tPERIOD\tMEAN\tP50\tP90\tP99\tMAX\tOVERRUNS\tREADS/TICK\tWRITES/TICK\n")
+	for _, r := range reports {
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%gs\t%s\t%s\t%s\t%s\t%s\t%d/%d\t%.0f\t%.0f\n",
+			r.Pods, r.Workers, ms(r.IOLatency), r.Period, ms(r.Mean), ms(r.P50), ms(r.P90), ms(r.P99), ms(r.Max),
+			r.Overruns, r.Ticks, r.ReadsPerTick, r.WritesPerTick)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,123 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kusimulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+type ResourceReport struct {
+	AvgDemand   float64 `json:"avgDemand"`
+	AvgAchieved float64 `json:"avgAchieved"`
+	AvgLimit    float64 `json:"avgLimit"`
+	// Throughput is the achieved work over the demanded work in percent.
+	Throughput float64 `json:"throughput"`
+	// Throttled is the fraction of the lifetime in percent where the
+	// achieved usage was below the demand.
+	Throttled   float64 `json:"throttled"`
+	TokensSpent float64 `json:"tokensSpent"`
+}
+
+type PodReport struct {
+	Name             string                    `json:"name"`
+	Lifetime         float64                   `json:"lifetime"` // seconds
+	TokenReservation float64                   `json:"tokenReservation"`
+	AvgTokenQueue    float64                   `json:"avgTokenQueue"`
+	MinTokenQueue    float64                   `json:"minTokenQueue"`
+	TokensSpent      float64                   `json:"tokensSpent"`
+	UpdatedCount     int64                     `json:"updatedCount"`
+	Resources        map[string]ResourceReport `json:"resources"`
+}
+
+type Report struct {
+	Duration float64     `json:"duration"`
+	Pods     []PodReport `json:"pods"`
+}
+
+func percent(a, b float64) float64 {
+	if b == 0 {
+		return 100
+	}
+	return a * 100 / b
+}
+
+func (s *Simulator) report() *Report {
+	r := &Report{Duration: s.seconds()}
+	for _, sp := range s.pods {
+		if sp.pi == nil {
+			continue
+		}
+		end := s.seconds()
+		if sp.ended {
+			end = sp.workload.End
+		}
+		lifetime := end - sp.workload.Start
+
+		pr := PodReport{
+			Name:             sp.workload.Name,
+			Lifetime:         lifetime,
+			TokenReservation: sp.workload.TokenReservation,
+			UpdatedCount:     sp.pi.UpdatedCount,
+			Resources:        make(map[string]ResourceReport),
+		}
+		if sp.ticks > 0 {
+			pr.AvgTokenQueue = sp.sumTokenQueue / float64(sp.ticks)
+			pr.MinTokenQueue = sp.minTokenQueue
+		}
+		for rn, sr := range sp.res {
+			rr := ResourceReport{
+				Throughput:  percent(sr.sumAchieved, sr.sumDemand),
+				TokensSpent: sr.spent,
+			}
+			if lifetime > 0 {
+				rr.AvgDemand = sr.sumDemand / lifetime
+				rr.AvgAchieved = sr.sumAchieved / lifetime
+				rr.AvgLimit = sr.sumLimit / lifetime
+				rr.Throttled = sr.throttled * 100 / lifetime
+			}
+			pr.TokensSpent += sr.spent
+			pr.Resources[string(rn)] = rr
+		}
+		r.Pods = append(r.Pods, pr)
+	}
+	return r
+}
+
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func (r *Report) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "POD\tRESOURCE\tDEMAND\tACHIEVED\tLIMIT\tTHROUGHPUT%%\tTHROTTLED%%\tTOKENS\n")
+	for _, pr := range r.Pods {
+		for _, rn := range []string{"CPU", "GPU"} {
+			rr := pr.Resources[rn]
+			fmt.Fprintf(tw, "%s\t%s\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\t%.0f\n",
+				pr.Name, rn, rr.AvgDemand, rr.AvgAchieved, rr.AvgLimit, rr.Throughput, rr.Throttled, rr.TokensSpent)
+		}
+	}
+	fmt.Fprintf(tw, "\nPOD\tLIFETIME\tRESERVATION\tAVG QUEUE\tMIN QUEUE\tTOKENS SPENT\tUPDATES\n")
+	for _, pr := range r.Pods {
+		fmt.Fprintf(tw, "%s\t%.0fs\t%.0f\t%.1f\t%.1f\t%.0f\t%d\n",
+			pr.Name, pr.Lifetime, pr.TokenReservation, pr.AvgTokenQueue, pr.MinTokenQueue, pr.TokensSpent, pr.UpdatedCount)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,258 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kusimulator
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kuclock"
+	"github.com/sslab-konkuk/KuScale/pkg/kufs"
+	"github.com/sslab-konkuk/KuScale/pkg/kumonitor"
+	"k8s.io/klog"
+)
+
+type Config struct {
+	MonitoringPeriod float64 // seconds
+	WindowSize       int64
+	MonitoringMode   bool
+	StaticV          float64
+
+	// Speedup is how many times faster than real time the simulation runs.
+	// 0 runs the simulation as fast as possible.
+	Speedup float64
+	// Substeps is the number of integration steps per monitoring period.
+	Substeps int
+}
+
+type simResource struct {
+	name  kumonitor.ResourceName
+	curve Curve
+	acct  float64 // ns of accumulated usage
+	limit float64
+
+	demand, achieved float64 // of the current substep
+
+	sumDemand, sumAchieved, sumLimit float64 // percent * seconds
+	throttled, spent                 float64 // seconds, tokens
+}
+
+type simPod struct {
+	workload Workload
+	pi       *kumonitor.PodInfo
+	ended    bool
+
+	res map[kumonitor.ResourceName]*simResource
+
+	ticks                        int
+	sumTokenQueue, minTokenQueue float64
+}
+
+// simHost implements kumonitor.Host on top of the workload models.
+// Achieved usage is min(demand, limit), shared out when the node is full.
+type simHost struct {
+	*kuclock.Fake
+	resources map[*kumonitor.ResourceInfo]*simResource
+	ended     map[*kumonitor.ResourceInfo]bool
+}
+
+func (h *simHost) ReadUsage(ri *kumonitor.ResourceInfo) (uint64, error) {
+	sr, ok := h.resources[ri]
+	if !ok || h.ended[ri] {
+		return 0, kufs.ErrNotExist
+	}
+	return uint64(sr.acct), nil
+}
+
+func (h *simHost) WriteLimit(ri *kumonitor.ResourceInfo, limit float64) error {
+	sr, ok := h.resources[ri]
+	if !ok || h.ended[ri] {
+		return kufs.ErrNotExist
+	}
+	sr.limit = limit
+	return nil
+}
+
+type Simulator struct {
+	config   Config
+	scenario *Scenario
+	host     *simHost
+	monitor  *kumonitor.Monitor
+	pods     []*simPod
+}
+
+// NewSimulator returns a simulator of scenario, or an error if the period,
+// the duration or the speedup can't make the simulated time advance.
+func NewSimulator(scenario *Scenario, config Config) (*Simulator, error) {
+	if config.MonitoringPeriod <= 0 {
+		return nil, fmt.Errorf("monitoring period %g should be positive", config.MonitoringPeriod)
+	}
+	if scenario.Duration <= 0 {
+		return nil, fmt.Errorf("duration %g should be positive", scenario.Duration)
+	}
+	if config.Speedup < 0 {
+		return nil, fmt.Errorf("speedup %g should not be negative", config.Speedup)
+	}
+	if config.Substeps <= 0 {
+		config.Substeps = 10
+	}
+	host := &simHost{
+		Fake:      kuclock.NewFake(0),
+		resources: make(map[*kumonitor.ResourceInfo]*simResource),
+		ended:     make(map[*kumonitor.ResourceInfo]bool),
+	}
+	s := &Simulator{
+		config:   config,
+		scenario: scenario,
+		host:     host,
+		monitor: kumonitor.NewMonitorWithHost(time.Duration(config.MonitoringPeriod*float64(time.Second)), config.WindowSize,
+			"simulator", config.MonitoringMode, config.StaticV, host),
+	}
+	for _, w := range scenario.Workloads {
+		s.pods = append(s.pods, &simPod{workload: w})
+	}
+	sort.SliceStable(s.pods, func(i, j int) bool { return s.pods[i].workload.Start < s.pods[j].workload.Start })
+	return s, nil
+}
+
+// Monitor returns the monitor which is driven by the simulator.
+func (s *Simulator) Monitor() *kumonitor.Monitor { return s.monitor }
+
+func (s *Simulator) seconds() float64 { return float64(s.host.Now()) / 1e9 }
+
+func (s *Simulator) startPods() {
+	for _, sp := range s.pods {
+		if sp.pi != nil || sp.workload.Start > s.seconds() {
+			continue
+		}
+		pi := kumonitor.NewPodInfo(sp.workload.Name, []kumonitor.ResourceName{"CPU", "GPU"})
+		pi.TokenReservation = sp.workload.TokenReservation
+		sp.pi = pi
+		sp.minTokenQueue = math.Inf(1)
+		sp.res = map[kumonitor.ResourceName]*simResource{
+			"CPU": {name: "CPU", curve: sp.workload.CPU},
+			"GPU": {name: "GPU", curve: sp.workload.GPU},
+		}
+		for rn, ri := range pi.RIs {
+			s.host.resources[ri] = sp.res[rn]
+		}
+		klog.V(5).Info("Simulator starts ", sp.workload.Name, " at ", s.seconds())
+		s.monitor.AddPod(pi)
+	}
+}
+
+func (s *Simulator) endPods() {
+	for _, sp := range s.pods {
+		if sp.pi == nil || sp.ended || sp.workload.End == 0 || sp.workload.End > s.seconds() {
+			continue
+		}
+		sp.ended = true
+		for _, ri := range sp.pi.RIs {
+			s.host.ended[ri] = true
+		}
+		klog.V(5).Info("Simulator ends ", sp.workload.Name, " at ", s.seconds())
+	}
+}
+
+func (s *Simulator) running() []*simPod {
+	var pods []*simPod
+	for _, sp := range s.pods {
+		if sp.pi != nil && !sp.ended {
+			pods = append(pods, sp)
+		}
+	}
+	return pods
+}
+
+// step advances the simulated time by dt seconds.
+func (s *Simulator) step(dt float64) {
+	pods := s.running()
+	capacity := map[kumonitor.ResourceName]float64{"CPU": s.scenario.CPUCapacity, "GPU": s.scenario.GPUCapacity}
+	total := make(map[kumonitor.ResourceName]float64)
+
+	for _, sp := range pods {
+		t := s.seconds() - sp.workload.Start
+		for rn, sr := range sp.res {
+			sr.demand = sr.curve.Demand(t)
+			sr.achieved = sr.demand
+			if sr.limit > 0 && sr.achieved > sr.limit {
+				sr.achieved = sr.limit
+			}
+			total[rn] += sr.achieved
+		}
+	}
+
+	for _, sp := range pods {
+		for rn, sr := range sp.res {
+			if capacity[rn] > 0 && total[rn] > capacity[rn] {
+				sr.achieved = sr.achieved * capacity[rn] / total[rn]
+			}
+			sr.acct += sr.achieved / 100. * dt * 1e9
+			sr.sumDemand += sr.demand * dt
+			sr.sumAchieved += sr.achieved * dt
+			sr.sumLimit += sr.limit * dt
+			if sr.achieved < sr.demand {
+				sr.throttled += dt
+			}
+			sr.spent += sp.pi.RIs[rn].Price() * sr.limit * dt
+		}
+	}
+	s.host.Advance(time.Duration(dt * 1e9))
+}
+
+func (s *Simulator) recordTick() {
+	for _, sp := range s.running() {
+		sp.ticks++
+		sp.sumTokenQueue += sp.pi.TokenQueue
+		if sp.pi.TokenQueue < sp.minTokenQueue {
+			sp.minTokenQueue = sp.pi.TokenQueue
+		}
+	}
+}
+
+/*
+Func Name : Run()
+Objective : 1) Start and end the workloads on time
+			2) Integrate the achieved usage between monitoring ticks
+			3) Run the real monitor control loop on every tick
+*/
+func (s *Simulator) Run() *Report {
+	period := s.config.MonitoringPeriod
+	dt := period / float64(s.config.Substeps)
+
+	klog.V(4).Info("Starting Simulator for ", s.scenario.Duration, " seconds")
+	for s.seconds() < s.scenario.Duration {
+		tickStart := time.Now()
+
+		s.startPods()
+		for i := 0; i < s.config.Substeps; i++ {
+			s.step(dt)
+		}
+		s.endPods()
+		s.monitor.MonitorAndAutoScale()
+		s.recordTick()
+
+		if s.config.Speedup > 0 {
+			wait := time.Duration(period/s.config.Speedup*1e9) - time.Since(tickStart)
+			if wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+	}
+	klog.V(4).Info("Finished Simulator")
+	return s.report()
+}
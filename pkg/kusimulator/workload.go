@@ -0,0 +1,170 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kusimulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+)
+
+// Curve is a demand curve of a resource. Demand is in the same unit as the
+// limits of KuScale, so 100 means one core for CPU or the whole GPU.
+type Curve struct {
+	// Kind is one of "constant", "step", "sine" and "burst".
+	Kind string `json:"kind"`
+
+	// constant, sine and burst
+	Base      float64 `json:"base,omitempty"`
+	Amplitude float64 `json:"amplitude,omitempty"`
+	Period    float64 `json:"period,omitempty"` // seconds
+	Duty      float64 `json:"duty,omitempty"`   // burst : fraction of period at Base+Amplitude
+
+	// step : Values[i] is the demand from Times[i] seconds
+	Times  []float64 `json:"times,omitempty"`
+	Values []float64 `json:"values,omitempty"`
+}
+
+// Demand returns the demand at t seconds after the workload has started.
+func (c Curve) Demand(t float64) float64 {
+	var d float64
+	switch c.Kind {
+	case "", "constant":
+		d = c.Base
+	case "step":
+		for i := range c.Times {
+			if t >= c.Times[i] {
+				d = c.Values[i]
+			}
+		}
+	case "sine":
+		d = c.Base + c.Amplitude*math.Sin(2*math.Pi*t/c.Period)
+	case "burst":
+		d = c.Base
+		if math.Mod(t, c.Period) < c.Duty*c.Period {
+			d += c.Amplitude
+		}
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+func (c Curve) validate() error {
+	switch c.Kind {
+	case "", "constant":
+	case "step":
+		if len(c.Times) != len(c.Values) || len(c.Times) == 0 {
+			return fmt.Errorf("step curve needs the same number of times and values")
+		}
+	case "sine", "burst":
+		if c.Period <= 0 {
+			return fmt.Errorf("%s curve needs a positive period", c.Kind)
+		}
+	default:
+		return fmt.Errorf("unknown curve kind %q", c.Kind)
+	}
+	return nil
+}
+
+// Workload is a simulated pod.
+type Workload struct {
+	Name             string  `json:"name"`
+	TokenReservation float64 `json:"tokenReservation"`
+	Start            float64 `json:"start"` // seconds from the beginning of the simulation
+	End              float64 `json:"end"`   // 0 means until the end of the simulation
+	CPU              Curve   `json:"cpu"`
+	GPU              Curve   `json:"gpu"`
+}
+
+// Scenario is a set of workloads running on a simulated node.
+type Scenario struct {
+	Duration float64 `json:"duration"` // seconds
+	// Node capacity, 0 means unlimited.
+	CPUCapacity float64    `json:"cpuCapacity"`
+	GPUCapacity float64    `json:"gpuCapacity"`
+	Workloads   []Workload `json:"workloads"`
+}
+
+func (s *Scenario) Validate() error {
+	if s.Duration <= 0 {
+		return fmt.Errorf("duration should be positive")
+	}
+	names := make(map[string]bool)
+	for _, w := range s.Workloads {
+		if w.Name == "" {
+			return fmt.Errorf("workload without a name")
+		}
+		if names[w.Name] {
+			return fmt.Errorf("duplicated workload %s", w.Name)
+		}
+		names[w.Name] = true
+		if w.End != 0 && w.End <= w.Start {
+			return fmt.Errorf("workload %s ends before it starts", w.Name)
+		}
+		if err := w.CPU.validate(); err != nil {
+			return fmt.Errorf("workload %s cpu: %s", w.Name, err)
+		}
+		if err := w.GPU.validate(); err != nil {
+			return fmt.Errorf("workload %s gpu: %s", w.Name, err)
+		}
+	}
+	return nil
+}
+
+// LoadScenario reads a JSON scenario file.
+func LoadScenario(path string) (*Scenario, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &Scenario{}
+	if err := json.Unmarshal(contents, s); err != nil {
+		return nil, fmt.Errorf("couldn't parse %s: %s", path, err)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// DefaultScenario has an inference pod with bursty GPU demand, a training
+// pod with a constant demand and a CPU heavy pod which arrives later.
+func DefaultScenario() *Scenario {
+	return &Scenario{
+		Duration:    300,
+		CPUCapacity: 800,
+		GPUCapacity: 100,
+		Workloads: []Workload{
+			{
+				Name: "inference", TokenReservation: 200,
+				CPU: Curve{Kind: "constant", Base: 50},
+				GPU: Curve{Kind: "burst", Base: 5, Amplitude: 60, Period: 20, Duty: 0.3},
+			},
+			{
+				Name: "training", TokenReservation: 300,
+				CPU: Curve{Kind: "constant", Base: 100},
+				GPU: Curve{Kind: "constant", Base: 80},
+			},
+			{
+				Name: "preprocess", TokenReservation: 150, Start: 60, End: 240,
+				CPU: Curve{Kind: "sine", Base: 150, Amplitude: 100, Period: 60},
+				GPU: Curve{Kind: "constant", Base: 0},
+			},
+		},
+	}
+}
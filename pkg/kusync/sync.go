@@ -0,0 +1,350 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kusync mirrors the limits decided by KuScale into the pods of the
+// Kubernetes API, so that kubectl and the other autoscalers see what is
+// enforced on the node.
+package kusync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/klog"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kumonitor"
+)
+
+// Modes of the Syncer.
+const (
+	ModeAuto        = "auto"        // resize the CPU limit in place when the cluster can, and annotate
+	ModeAnnotations = "annotations" // only annotate
+)
+
+// Annotations of the limits in percent, -1 being no limit.
+const (
+	AnnotationCPULimit = "kuscale.com/cpu-limit"
+	AnnotationGPULimit = "kuscale.com/gpu-limit"
+)
+
+// Results of the sync of a pod, counted by Syncer.Results.
+const (
+	ResultResized   = "resized"   // the CPU limit of the container was resized in place
+	ResultAnnotated = "annotated" // only the annotations were written
+	ResultFailed    = "failed"
+	ResultThrottled = "throttled" // left to the next round by the rate limit
+)
+
+// How the cluster resizes pods in place.
+const (
+	resizeUnknown     = ""
+	resizeSubresource = "subresource" // pods/resize, Kubernetes 1.33 and later
+	resizeSpec        = "spec"        // patch of the pod spec, InPlacePodVerticalScaling before 1.33
+	resizeNone        = "none"
+)
+
+// resyncRounds is how many rounds an unchanged pod waits to be synced again.
+const resyncRounds = 10
+
+type podKey struct{ namespace, name string }
+
+type podLimits struct {
+	container string
+	cpu, gpu  float64 // percent
+}
+
+type syncedLimits struct {
+	podLimits
+	round int
+}
+
+// Syncer is a kumonitor.Observer which keeps the limits of the last tick, and
+// writes them to the pods every interval, when they changed by minChange
+// percent or were last written resyncRounds ago, at most qps pods a second.
+type Syncer struct {
+	client    kubernetes.Interface
+	monitor   *kumonitor.Monitor
+	mode      string
+	interval  time.Duration
+	minChange float64
+	limiter   flowcontrol.RateLimiter
+
+	mu      sync.Mutex
+	desired map[podKey]podLimits // of the last tick
+	results map[string]uint64
+
+	// Used by Run only
+	synced map[podKey]syncedLimits
+	round  int
+	resize string
+}
+
+// NewSyncer makes a Syncer of the limits of monitor, which it tells about the
+// CPU limits it resizes in place.
+func NewSyncer(client kubernetes.Interface, monitor *kumonitor.Monitor, mode string, interval time.Duration, minChange, qps float64, burst int) (*Syncer, error) {
+	if mode != ModeAuto && mode != ModeAnnotations {
+		return nil, fmt.Errorf("unknown pod sync mode %q, should be %s or %s", mode, ModeAuto, ModeAnnotations)
+	}
+	s := &Syncer{
+		client:    client,
+		monitor:   monitor,
+		mode:      mode,
+		interval:  interval,
+		minChange: minChange,
+		limiter:   flowcontrol.NewTokenBucketRateLimiter(float32(qps), burst),
+		desired:   make(map[podKey]podLimits),
+		results:   make(map[string]uint64),
+		synced:    make(map[podKey]syncedLimits),
+	}
+	if mode == ModeAnnotations {
+		s.resize = resizeNone
+	}
+	return s, nil
+}
+
+func (s *Syncer) PodAdded(now int64, pi *kumonitor.PodInfo) {}
+
+func (s *Syncer) PodCompleted(now int64, pi *kumonitor.PodInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.desired, podKey{pi.Namespace, pi.PodName})
+}
+
+// Ticked keeps the limits of the running pods known to Kubernetes.
+func (s *Syncer) Ticked(now int64, m *kumonitor.Monitor) {
+	desired := make(map[podKey]podLimits, len(m.RunningPodMap))
+	for _, pi := range m.RunningPodMap {
+		if pi.Namespace == "" {
+			continue
+		}
+		desired[podKey{pi.Namespace, pi.PodName}] = podLimits{
+			container: pi.Container,
+			cpu:       pi.CPU().Limit(),
+			gpu:       pi.GPU().Limit(),
+		}
+	}
+	s.mu.Lock()
+	s.desired = desired
+	s.mu.Unlock()
+}
+
+// Results returns how many pod syncs ended with every result.
+func (s *Syncer) Results() map[string]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	results := map[string]uint64{ResultResized: 0, ResultAnnotated: 0, ResultFailed: 0, ResultThrottled: 0}
+	for r, n := range s.results {
+		results[r] = n
+	}
+	return results
+}
+
+func (s *Syncer) result(r string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results[r]++
+}
+
+// Run syncs the pods every interval until stopCh is closed.
+func (s *Syncer) Run(stopCh chan string) {
+	klog.V(4).Info("Starting Pod Syncer")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			s.Sync(ctx)
+		}
+	}
+}
+
+/*
+Func Name : (s *Syncer) Sync()
+Objective :
+1) Write the limits of the last tick to the pods which changed enough or weren't written for resyncRounds
+2) Leave the others to the next round once the rate limit is reached
+3) Forget the pods which are gone
+*/
+func (s *Syncer) Sync(ctx context.Context) {
+	s.mu.Lock()
+	desired := s.desired
+	s.mu.Unlock()
+	s.round++
+
+	for key := range s.synced {
+		if _, ok := desired[key]; !ok {
+			delete(s.synced, key)
+		}
+	}
+	throttled := false
+	for key, want := range desired {
+		last, ok := s.synced[key]
+		if ok && !s.changed(last.podLimits, want) && s.round-last.round < resyncRounds {
+			continue
+		}
+		if throttled || !s.limiter.TryAccept() {
+			throttled = true
+			s.result(ResultThrottled)
+			continue
+		}
+		result, err := s.syncPod(ctx, key, want)
+		s.result(result)
+		if err != nil {
+			klog.V(2).Info("Couldn't sync the limits of ", key.namespace, "/", key.name, " : ", err)
+			continue
+		}
+		s.synced[key] = syncedLimits{podLimits: want, round: s.round}
+	}
+}
+
+// changed tells whether a limit moved by minChange percent of the synced one.
+func (s *Syncer) changed(synced, want podLimits) bool {
+	moved := func(from, to float64) bool {
+		if (from < 0) != (to < 0) {
+			return true
+		}
+		return math.Abs(to-from) > math.Abs(from)*s.minChange/100
+	}
+	return synced.container != want.container || moved(synced.cpu, want.cpu) || moved(synced.gpu, want.gpu)
+}
+
+// syncPod resizes the CPU limit of the container when the cluster can, and
+// annotates the pod with both limits.
+func (s *Syncer) syncPod(ctx context.Context, key podKey, want podLimits) (string, error) {
+	result := ResultAnnotated
+	if want.cpu >= 0 && want.container != "" {
+		resized, err := s.resizePod(ctx, key, want)
+		if err != nil {
+			klog.V(4).Info("Couldn't resize ", key.namespace, "/", key.name, " in place, annotating it : ", err)
+		} else if resized {
+			result = ResultResized
+		}
+	}
+	if err := s.annotate(ctx, key, want); err != nil {
+		return ResultFailed, err
+	}
+	return result, nil
+}
+
+/*
+Func Name : (s *Syncer) resizePod()
+Objective :
+1) Patch the CPU limit of the container through pods/resize, or the pod spec
+2) Tell the monitor the resized limit, which the kubelet writes over that of KuScale
+3) Stop resizing once the cluster turns out to forbid changing the resources
+*/
+func (s *Syncer) resizePod(ctx context.Context, key podKey, want podLimits) (bool, error) {
+	if s.resize == resizeUnknown {
+		s.resize = s.discoverResize()
+	}
+	if s.resize == resizeNone {
+		return false, nil
+	}
+
+	milli := milliCPU(want.cpu)
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"containers": []interface{}{map[string]interface{}{
+				"name":      want.container,
+				"resources": map[string]interface{}{"limits": map[string]string{"cpu": strconv.FormatInt(milli, 10) + "m"}},
+			}},
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	var subresources []string
+	if s.resize == resizeSubresource {
+		subresources = []string{"resize"}
+	}
+	_, err = s.client.CoreV1().Pods(key.namespace).Patch(ctx, key.name, types.StrategicMergePatchType, patch,
+		metav1.PatchOptions{FieldManager: "kuscale"}, subresources...)
+	if s.resize == resizeSpec && apierrors.IsInvalid(err) && strings.Contains(err.Error(), "may not change fields") {
+		klog.Info("The cluster can't resize pods in place, only annotating them")
+		s.resize = resizeNone
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	s.monitor.Resized(key.namespace, key.name, "CPU", float64(milli)/10)
+	return true, nil
+}
+
+// discoverResize tells how the cluster resizes pods, from the subresources of pods.
+func (s *Syncer) discoverResize() string {
+	resources, err := s.client.Discovery().ServerResourcesForGroupVersion("v1")
+	if err != nil {
+		klog.V(4).Info("Couldn't discover the subresources of pods : ", err)
+		return resizeSpec
+	}
+	for _, r := range resources.APIResources {
+		if r.Name == "pods/resize" {
+			return resizeSubresource
+		}
+	}
+	return resizeSpec
+}
+
+func (s *Syncer) annotate(ctx context.Context, key podKey, want podLimits) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				AnnotationCPULimit: formatLimit(want.cpu),
+				AnnotationGPULimit: formatLimit(want.gpu),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.client.CoreV1().Pods(key.namespace).Patch(ctx, key.name, types.MergePatchType, patch,
+		metav1.PatchOptions{FieldManager: "kuscale"})
+	return err
+}
+
+// milliCPU is a limit in percent in millicpu, 100 percent being 1000m.
+func milliCPU(limit float64) int64 {
+	milli := int64(limit * 10)
+	if milli < 1 {
+		milli = 1
+	}
+	return milli
+}
+
+func formatLimit(limit float64) string {
+	if limit < 0 {
+		return "-1"
+	}
+	return strconv.FormatFloat(limit, 'f', 1, 64)
+}
@@ -0,0 +1,286 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kusync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kufs"
+	"github.com/sslab-konkuk/KuScale/pkg/kumonitor"
+	"github.com/sslab-konkuk/KuScale/pkg/kuprofiler"
+)
+
+var roots = kufs.Roots{Cgroup: "/cgroup", GPU: "/gpu", Proc: "/proc"}
+
+// node is a monitor of pods on a MemFS, and the API server they are in.
+type node struct {
+	fs     *kufs.MemFS
+	m      *kumonitor.Monitor
+	client *fake.Clientset
+	pods   []*kumonitor.PodInfo
+}
+
+func newNode(t *testing.T, podNames ...string) *node {
+	t.Helper()
+	kuprofiler.NewLatencyInfo(false)
+	n := &node{fs: kufs.NewMemFS(), client: fake.NewSimpleClientset()}
+	for name, data := range map[string]string{
+		"configs/init": "", "configs/destroy": "", "gemini/resource_conf": "",
+		"configs/totalIDs": fmt.Sprint(len(podNames)),
+	} {
+		n.fs.SetFile(kufs.Join(roots.GPU, name), []byte(data))
+	}
+	n.m = kumonitor.NewMonitorWithHost(time.Second, 5, "node", false, 0, kumonitor.NewFSHost(n.fs, roots))
+
+	for i, name := range podNames {
+		cpuPath := kufs.Join(roots.Cgroup, "cpu/kubepods", name)
+		gpuPath := kufs.Join(roots.GPU, "IDs", fmt.Sprint(i+1))
+		n.fs.SetFile(cpuPath+"/cpu.stat", []byte("usage_usec 0\n"))
+		n.fs.SetFile(cpuPath+"/cpu.cfs_quota_us", []byte("-1"))
+		for _, file := range []string{"total_runtime", "gpu_limit", "gpu_request"} {
+			n.fs.SetFile(gpuPath+"/"+file, []byte("0"))
+		}
+		pi := kumonitor.NewPodInfoWithPaths(name, cpuPath, gpuPath)
+		pi.Namespace, pi.Container = "default", "app"
+		n.m.AddPod(pi)
+		n.pods = append(n.pods, pi)
+
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		}
+		if _, err := n.client.CoreV1().Pods("default").Create(context.Background(), pod, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return n
+}
+
+// canResize makes the API server serve pods/resize.
+func (n *node) canResize() {
+	n.client.Fake.Resources = []*metav1.APIResourceList{{
+		GroupVersion: "v1",
+		APIResources: []metav1.APIResource{{Name: "pods"}, {Name: "pods/resize"}},
+	}}
+}
+
+func (n *node) syncer(t *testing.T, mode string, qps float64, burst int) *Syncer {
+	t.Helper()
+	s, err := NewSyncer(n.client, n.m, mode, time.Second, 10, qps, burst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.m.AddObserver(s)
+	return s
+}
+
+func (n *node) pin(t *testing.T, name string, limit float64) {
+	t.Helper()
+	if err := n.m.PinLimit("default", name, "CPU", limit, n.m.Now()+int64(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (n *node) pod(t *testing.T, name string) *corev1.Pod {
+	t.Helper()
+	pod, err := n.client.CoreV1().Pods("default").Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pod
+}
+
+// resizes returns the strategic merge patches of the pods, and their subresources.
+func (n *node) resizes() []string {
+	var subresources []string
+	for _, a := range n.client.Actions() {
+		if p, ok := a.(k8stesting.PatchAction); ok && p.GetPatchType() == types.StrategicMergePatchType {
+			subresources = append(subresources, p.GetSubresource())
+		}
+	}
+	return subresources
+}
+
+func checkResults(t *testing.T, s *Syncer, want map[string]uint64) {
+	t.Helper()
+	got := s.Results()
+	for r, n := range want {
+		if got[r] != n {
+			t.Errorf("%s results = %d, want %d (%v)", r, got[r], n, got)
+		}
+	}
+}
+
+func checkCPULimit(t *testing.T, pod *corev1.Pod, milli int64) {
+	t.Helper()
+	if got := pod.Spec.Containers[0].Resources.Limits.Cpu().MilliValue(); got != milli {
+		t.Errorf("CPU limit of %s = %dm, want %dm", pod.Name, got, milli)
+	}
+}
+
+func checkAnnotations(t *testing.T, pod *corev1.Pod, cpu string) {
+	t.Helper()
+	if got := pod.Annotations[AnnotationCPULimit]; got != cpu {
+		t.Errorf("%s of %s = %q, want %q", AnnotationCPULimit, pod.Name, got, cpu)
+	}
+	if _, ok := pod.Annotations[AnnotationGPULimit]; !ok {
+		t.Errorf("%s of %s is missing", AnnotationGPULimit, pod.Name)
+	}
+}
+
+func TestSyncResizes(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		subresource bool
+	}{
+		{"subresource", true},
+		{"spec", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			n := newNode(t, "web")
+			if tc.subresource {
+				n.canResize()
+			}
+			s := n.syncer(t, ModeAuto, 100, 100)
+			n.pin(t, "web", 150)
+			n.m.MonitorAndAutoScale()
+			s.Sync(context.Background())
+
+			checkResults(t, s, map[string]uint64{ResultResized: 1, ResultFailed: 0})
+			pod := n.pod(t, "web")
+			checkCPULimit(t, pod, 1500)
+			checkAnnotations(t, pod, "150.0")
+			want := ""
+			if tc.subresource {
+				want = "resize"
+			}
+			if got := n.resizes(); len(got) != 1 || got[0] != want {
+				t.Errorf("resizes with subresources %q, want [%q]", got, want)
+			}
+		})
+	}
+}
+
+func TestSyncAnnotatesWhenResizeFails(t *testing.T) {
+	n := newNode(t, "web")
+	n.client.PrependReactor("patch", "pods", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		if a.(k8stesting.PatchAction).GetPatchType() == types.StrategicMergePatchType {
+			return true, nil, errors.New("resize refused")
+		}
+		return false, nil, nil
+	})
+	s := n.syncer(t, ModeAuto, 100, 100)
+	n.pin(t, "web", 150)
+	n.m.MonitorAndAutoScale()
+	s.Sync(context.Background())
+
+	checkResults(t, s, map[string]uint64{ResultResized: 0, ResultAnnotated: 1, ResultFailed: 0})
+	pod := n.pod(t, "web")
+	checkCPULimit(t, pod, 0)
+	checkAnnotations(t, pod, "150.0")
+}
+
+func TestSyncStopsResizingWhenFieldsMayNotChange(t *testing.T) {
+	n := newNode(t, "web")
+	n.client.PrependReactor("patch", "pods", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		if a.(k8stesting.PatchAction).GetPatchType() == types.StrategicMergePatchType {
+			return true, nil, apierrors.NewInvalid(schema.GroupKind{Kind: "Pod"}, a.(k8stesting.PatchAction).GetName(),
+				field.ErrorList{field.Forbidden(field.NewPath("spec"), "pod updates may not change fields other than `spec.containers[*].image`")})
+		}
+		return false, nil, nil
+	})
+	s := n.syncer(t, ModeAuto, 100, 100)
+	n.pin(t, "web", 150)
+	n.m.MonitorAndAutoScale()
+	s.Sync(context.Background())
+	checkResults(t, s, map[string]uint64{ResultResized: 0, ResultAnnotated: 1, ResultFailed: 0})
+
+	// Changed enough to be synced again, but only annotated
+	n.pin(t, "web", 300)
+	n.m.MonitorAndAutoScale()
+	s.Sync(context.Background())
+	checkResults(t, s, map[string]uint64{ResultResized: 0, ResultAnnotated: 2, ResultFailed: 0})
+	checkAnnotations(t, n.pod(t, "web"), "300.0")
+	if got := n.resizes(); len(got) != 1 {
+		t.Errorf("%d resizes, want 1 before the cluster forbid them", len(got))
+	}
+}
+
+func TestSyncThrottles(t *testing.T) {
+	n := newNode(t, "web", "db")
+	n.canResize()
+	s := n.syncer(t, ModeAuto, 0.001, 1)
+	n.m.MonitorAndAutoScale()
+	s.Sync(context.Background())
+	checkResults(t, s, map[string]uint64{ResultResized: 1, ResultThrottled: 1})
+
+	// The synced pod is unchanged, and the other is still throttled
+	s.Sync(context.Background())
+	checkResults(t, s, map[string]uint64{ResultResized: 1, ResultThrottled: 2})
+}
+
+// The limit the kubelet writes once a pod is resized is the limit of the pod,
+// not a drift the reconciler writes over.
+func TestResizedLimitIsNotDrift(t *testing.T) {
+	for _, tc := range []struct {
+		mode    string
+		drifted uint64
+	}{
+		{ModeAuto, 0},
+		{ModeAnnotations, 1},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			n := newNode(t, "web")
+			n.canResize()
+			r, err := kumonitor.NewReconciler(n.m, 0, kumonitor.ReconcileReapply, 10, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := n.syncer(t, tc.mode, 100, 100)
+			n.pin(t, "web", 150)
+			n.m.MonitorAndAutoScale()
+			s.Sync(context.Background())
+
+			// KuScale moves on, then the kubelet applies the resize
+			n.pin(t, "web", 300)
+			cpu := n.pods[0].CPU().Path()
+			n.fs.SetFile(cpu+"/cpu.cfs_quota_us", []byte("150000"))
+			n.m.MonitorAndAutoScale()
+
+			events := r.Events()
+			if events[kumonitor.EventDrifted] != tc.drifted || events[kumonitor.EventReapplied] != tc.drifted {
+				t.Errorf("events = %v, want %d drifted and reapplied", events, tc.drifted)
+			}
+			// Written again by the tick, as the pin stays
+			if got, _ := n.fs.ReadFile(cpu + "/cpu.cfs_quota_us"); string(got) != "300000" {
+				t.Errorf("cpu.cfs_quota_us = %s, want 300000", got)
+			}
+		})
+	}
+}
@@ -5,39 +5,75 @@ import (
 	"net"
 	"os"
 	"path"
+	"path/filepath"
+	"sync"
 
-	// "path/filepath"
 	// "strings"
 	"time"
 
+	"github.com/sslab-konkuk/KuScale/pkg/kufs"
+	"github.com/sslab-konkuk/KuScale/pkg/kugpu"
+	"github.com/sslab-konkuk/KuScale/pkg/kuhealth"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"k8s.io/klog"
 	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
 )
 
+// GeminiPaths are where the Gemini hook is on the host. Dir is mounted at the
+// same path in the containers.
+type GeminiPaths struct {
+	Dir    string
+	Hook   string
+	IPCDir string
+}
+
+var DefaultGeminiPaths = GeminiPaths{
+	Dir:    "/kubeshare",
+	Hook:   "/kubeshare/library/libgemhook.so.1",
+	IPCDir: "/kubeshare/scheduler/ipc/",
+}
+
 type KuTokenManager struct {
 	socketFile                 string
 	tokenName                  string
 	tokenSize                  int
-	totalIDs                   int
+	totalIDs                   int // next vGPU ID, guarded by mu
+	mu                         sync.Mutex
 	server                     *grpc.Server
 	stop                       chan interface{}
 	newPodCh                   chan string
 	health                     chan string
 	healthCheckIntervalSeconds time.Duration
+	done                       chan struct{} // closed when Run returns
+
+	fs    kufs.FS
+	roots kufs.Roots
+	gpu   *kugpu.Module
+
+	Gemini GeminiPaths
 }
 
-func NewKuTokenManager(tokenName string, tokenSize int, socketFile string) *KuTokenManager {
+func NewKuTokenManager(tokenName string, tokenSize int, socketFile string, fs kufs.FS, roots kufs.Roots) *KuTokenManager {
 	return &KuTokenManager{
 		tokenName:  tokenName,
 		tokenSize:  tokenSize,
 		socketFile: socketFile,
 		server:     nil,
 		stop:       nil,
+		fs:         fs,
+		roots:      roots,
+		gpu:        kugpu.New(fs, roots.GPU),
+		Gemini:     DefaultGeminiPaths,
+		done:       make(chan struct{}),
 	}
 }
 
+// Done is closed when Run returns, once the device plugin is stopped.
+func (ktm *KuTokenManager) Done() <-chan struct{} { return ktm.done }
+
 func (ktm *KuTokenManager) cleanup() error {
 
 	if err := os.Remove(ktm.socketFile); err != nil && !os.IsNotExist(err) {
@@ -47,19 +83,20 @@ func (ktm *KuTokenManager) cleanup() error {
 	return nil
 }
 
-// Stop stops the gRPC server.
+// Stop ends ListAndWatch, so that kubelet deregisters the token resource,
+// then stops the gRPC server once the running calls are done.
 func (ktm *KuTokenManager) Stop() error {
 	if ktm == nil || ktm.server == nil {
 		return nil
 	}
 	klog.V(5).Infof("Stopping KuTokenManager to serve '%s' on %s", ktm.tokenName, ktm.socketFile)
-	ktm.server.Stop()
-	if err := os.Remove(ktm.socketFile); err != nil && !os.IsNotExist(err) {
-		return err
-	}
 	close(ktm.stop)
+	ktm.server.GracefulStop()
 	ktm.server = nil
 	ktm.stop = nil
+	if err := os.Remove(ktm.socketFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	return nil
 }
 
@@ -143,24 +180,33 @@ func (ktm *KuTokenManager) Start() error {
 	return nil
 }
 
+/*
+Func Name : Run()
+Objective : 1) Start the device plugin and register it to kubelet
+			2) Retry with backoff on failure, so the token resource comes back
+*/
 func (ktm *KuTokenManager) Run(stopCh, newPodCh chan string) {
 
-	InsmodGpuMod()
-
-	ktm.stop = make(chan interface{})
 	ktm.newPodCh = newPodCh
-
-	err := ktm.Start()
-	if err != nil {
-		klog.Infof("Could not start device plugin for '%s': %s", ktm.tokenName, err)
-		ktm.cleanup()
-		goto ErrorStop
-	}
-
-	err = ktm.Register()
+	health := kuhealth.Register("tokenmanager")
+	defer close(ktm.done)
+
+	err := kuhealth.Retry(stopCh, kuhealth.DefaultBackoff, health, func() error {
+		ktm.stop = make(chan interface{})
+		if err := ktm.Start(); err != nil {
+			klog.Infof("Could not start device plugin for '%s': %s", ktm.tokenName, err)
+			ktm.Stop()
+			ktm.cleanup()
+			return err
+		}
+		if err := ktm.Register(); err != nil {
+			klog.Infof("Could not register device plugin: %s", err)
+			ktm.Stop()
+			return err
+		}
+		return nil
+	})
 	if err != nil {
-		klog.Infof("Could not register device plugin: %s", err)
-		ktm.Stop()
 		goto ErrorStop
 	}
 
@@ -168,7 +214,7 @@ func (ktm *KuTokenManager) Run(stopCh, newPodCh chan string) {
 	<-stopCh
 	ktm.Stop()
 ErrorStop:
-	// RmmodGpuMod()
+	health.Stopped()
 	klog.V(5).Info("Shutted KuTokenManager Down")
 }
 
@@ -216,8 +262,14 @@ func (ktm *KuTokenManager) ListAndWatch(e *pluginapi.Empty, s pluginapi.DevicePl
 
 	s.Send(&pluginapi.ListAndWatchResponse{Devices: defaultDevices})
 
-	ktm.totalIDs = int(GetFileParamUint("/sys/kernel/gpu/configs", "/totalIDs"))
-	klog.V(5).Info("totalIDs : ", ktm.totalIDs)
+	totalIDs, err := ktm.gpu.TotalIDs()
+	if err != nil {
+		klog.Info("couldn't read totalIDs : ", err)
+	}
+	ktm.mu.Lock()
+	ktm.totalIDs = totalIDs
+	ktm.mu.Unlock()
+	klog.V(5).Info("totalIDs : ", totalIDs)
 
 	for {
 		select {
@@ -238,11 +290,19 @@ func (ktm *KuTokenManager) ListAndWatch(e *pluginapi.Empty, s pluginapi.DevicePl
 func (ktm *KuTokenManager) Allocate(ctx context.Context, reqs *pluginapi.AllocateRequest) (*pluginapi.AllocateResponse, error) {
 
 	/* Enable GPU Module */
-	CreateGPUID(fmt.Sprintf("%d", ktm.totalIDs))
+	// A failed ID is not handed to the container, which would run with no GPU limit
+	ktm.mu.Lock()
+	vgpuId := ktm.totalIDs
+	if err := CreateGPUID(ktm.gpu, kugpu.ID(vgpuId)); err != nil {
+		ktm.mu.Unlock()
+		klog.Error("Error Creating GPU ID ", vgpuId, " : ", err)
+		return nil, status.Errorf(codes.Internal, "couldn't create vGPU ID %d : %v", vgpuId, err)
+	}
+	ktm.totalIDs = vgpuId + 1
+	ktm.mu.Unlock()
 
 	var tokenRes int
 	responses := pluginapi.AllocateResponse{}
-	vgpuId := ktm.totalIDs
 
 	for _, req := range reqs.ContainerRequests {
 		tokenRes = len(req.DevicesIDs)
@@ -250,19 +310,19 @@ func (ktm *KuTokenManager) Allocate(ctx context.Context, reqs *pluginapi.Allocat
 		responses.ContainerResponses = append(responses.ContainerResponses,
 			&pluginapi.ContainerAllocateResponse{
 				Envs: map[string]string{
-					"LD_PRELOAD":        "/kubeshare/library/libgemhook.so.1",
-					"LD_LIBRARY_PATH":   "/kubeshare/library/:$LD_LIBRARY_PATH",
-					"GEMINI_IPC_DIR":    "/kubeshare/scheduler/ipc/",
+					"LD_PRELOAD":        ktm.Gemini.Hook,
+					"LD_LIBRARY_PATH":   filepath.Dir(ktm.Gemini.Hook) + "/:$LD_LIBRARY_PATH",
+					"GEMINI_IPC_DIR":    ktm.Gemini.IPCDir,
 					"GEMINI_GROUP_NAME": fmt.Sprintf("%d", vgpuId),
 				},
 				Mounts: []*pluginapi.Mount{
 					{
-						ContainerPath: "/kubeshare", //TODO: Need to change it the specific path
-						HostPath:      "/kubeshare",
+						ContainerPath: ktm.Gemini.Dir,
+						HostPath:      ktm.Gemini.Dir,
 					},
 					{
 						ContainerPath: "/ku-gpu", //TODO: Need to change it the specific path
-						HostPath:      fmt.Sprintf("/sys/kernel/gpu/IDs/%d", vgpuId),
+						HostPath:      ktm.gpu.IDPath(kugpu.ID(vgpuId)),
 					},
 				},
 				Annotations: map[string]string{
@@ -273,6 +333,5 @@ func (ktm *KuTokenManager) Allocate(ctx context.Context, reqs *pluginapi.Allocat
 		)
 	}
 	ktm.newPodCh <- fmt.Sprintf("%d:%d", vgpuId, tokenRes)
-	ktm.totalIDs = ktm.totalIDs + 1
 	return &responses, nil
 }
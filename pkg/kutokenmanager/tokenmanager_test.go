@@ -0,0 +1,253 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kutokenmanager
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kufs"
+	"github.com/sslab-konkuk/KuScale/pkg/kugpu"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+var testRoots = kufs.Roots{Cgroup: "/cgroup", GPU: "/gpu", Proc: "/proc"}
+
+// newTestFS lays out the ku-gpu-layer module on a MemFS, which makes the
+// files of an ID when it is written to configs/init, and counts the reloads
+// of resource_conf.
+func newTestFS(totalIDs int, reloads *int64) *kufs.MemFS {
+	fs := kufs.NewMemFS()
+	for name, data := range map[string]string{
+		"configs/init": "", "configs/destroy": "", "gemini/resource_conf": "",
+		"configs/totalIDs": fmt.Sprint(totalIDs),
+	} {
+		fs.SetFile(kufs.Join(testRoots.GPU, name), []byte(data))
+	}
+	fs.OnWrite = func(path string, data []byte) {
+		switch path {
+		case kufs.Join(testRoots.GPU, "configs/init"):
+			for _, name := range []string{"total_runtime", "gpu_limit", "gpu_request"} {
+				fs.SetFile(kufs.Join(testRoots.GPU, "IDs", string(data), name), []byte("0"))
+			}
+		case kufs.Join(testRoots.GPU, "gemini/resource_conf"):
+			atomic.AddInt64(reloads, 1)
+		}
+	}
+	return fs
+}
+
+// serve starts the device plugin of ktm, and returns a client of it.
+func serve(t *testing.T, ktm *KuTokenManager) pluginapi.DevicePluginClient {
+	t.Helper()
+	ktm.stop = make(chan interface{})
+	if err := ktm.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ktm.Stop() })
+	conn, err := ktm.dial(ktm.socketFile, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pluginapi.NewDevicePluginClient(conn)
+}
+
+func TestListAndWatchListsTokens(t *testing.T) {
+	var reloads int64
+	fs := newTestFS(3, &reloads)
+	ktm := NewKuTokenManager("kuscale.com/token", 5, filepath.Join(t.TempDir(), "kuscale.sock"), fs, testRoots)
+	client := serve(t, ktm)
+
+	stream, err := client.ListAndWatch(context.Background(), &pluginapi.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Devices) != 5 {
+		t.Fatalf("%d devices, want 5", len(resp.Devices))
+	}
+	for i, dev := range resp.Devices {
+		if want := fmt.Sprintf("kuscale.com/token-%d", i); dev.ID != want || dev.Health != pluginapi.Healthy {
+			t.Errorf("device %d = %s %s, want %s healthy", i, dev.ID, dev.Health, want)
+		}
+	}
+
+	// Stopping ends ListAndWatch, so that kubelet deregisters the tokens
+	if err := ktm.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Errorf("ListAndWatch ended with %v, want EOF", err)
+	}
+	if ktm.totalIDs != 3 {
+		t.Errorf("next vGPU ID = %d, want 3 of configs/totalIDs", ktm.totalIDs)
+	}
+}
+
+func TestAllocateCreatesVGPUIDs(t *testing.T) {
+	var reloads int64
+	fs := newTestFS(0, &reloads)
+	ktm := NewKuTokenManager("kuscale.com/token", 5, filepath.Join(t.TempDir(), "kuscale.sock"), fs, testRoots)
+	newPodCh := make(chan string, 2)
+	ktm.newPodCh = newPodCh
+	client := serve(t, ktm)
+
+	for id, tokens := range []int{2, 1} {
+		req := &pluginapi.AllocateRequest{ContainerRequests: []*pluginapi.ContainerAllocateRequest{{}}}
+		for i := 0; i < tokens; i++ {
+			req.ContainerRequests[0].DevicesIDs = append(req.ContainerRequests[0].DevicesIDs, fmt.Sprintf("kuscale.com/token-%d", i))
+		}
+		resp, err := client.Allocate(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		idPath := kufs.Join(testRoots.GPU, "IDs", fmt.Sprint(id))
+		if !fs.Exists(idPath + "/gpu_limit") {
+			t.Errorf("vGPU ID %d wasn't created", id)
+		}
+		if got := atomic.LoadInt64(&reloads); got != int64(id+1) {
+			t.Errorf("resource_conf reloaded %d times, want %d", got, id+1)
+		}
+		if got, want := <-newPodCh, fmt.Sprintf("%d:%d", id, tokens); got != want {
+			t.Errorf("new pod %q, want %q", got, want)
+		}
+
+		if len(resp.ContainerResponses) != 1 {
+			t.Fatalf("%d container responses, want 1", len(resp.ContainerResponses))
+		}
+		cr := resp.ContainerResponses[0]
+		if got := cr.Envs["GEMINI_GROUP_NAME"]; got != fmt.Sprint(id) {
+			t.Errorf("GEMINI_GROUP_NAME = %s, want %d", got, id)
+		}
+		if got := cr.Envs["LD_PRELOAD"]; got != DefaultGeminiPaths.Hook {
+			t.Errorf("LD_PRELOAD = %s, want %s", got, DefaultGeminiPaths.Hook)
+		}
+		if got := cr.Annotations["kuauto.token"]; got != fmt.Sprint(tokens) {
+			t.Errorf("kuauto.token = %s, want %d", got, tokens)
+		}
+		mounted := false
+		for _, mount := range cr.Mounts {
+			mounted = mounted || mount.HostPath == idPath
+		}
+		if !mounted {
+			t.Errorf("vGPU ID %d isn't mounted in %v", id, cr.Mounts)
+		}
+	}
+}
+
+func TestAllocateFailsWithoutVGPUID(t *testing.T) {
+	var reloads int64
+	fs := newTestFS(0, &reloads)
+	// The module refuses to create an ID which exists
+	fs.SetFile(kufs.Join(testRoots.GPU, "IDs/0/gpu_limit"), []byte("0"))
+	ktm := NewKuTokenManager("kuscale.com/token", 5, filepath.Join(t.TempDir(), "kuscale.sock"), fs, testRoots)
+	ktm.newPodCh = make(chan string, 1)
+	client := serve(t, ktm)
+
+	req := &pluginapi.AllocateRequest{ContainerRequests: []*pluginapi.ContainerAllocateRequest{{DevicesIDs: []string{"kuscale.com/token-0"}}}}
+	_, err := client.Allocate(context.Background(), req)
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("Allocate = %v, want an Internal error", err)
+	}
+	select {
+	case msg := <-ktm.newPodCh:
+		t.Errorf("new pod %q sent without a vGPU ID", msg)
+	default:
+	}
+	ktm.mu.Lock()
+	defer ktm.mu.Unlock()
+	if ktm.totalIDs != 0 {
+		t.Errorf("next vGPU ID = %d, want 0", ktm.totalIDs)
+	}
+}
+
+func TestConcurrentAllocatesGetDistinctIDs(t *testing.T) {
+	var reloads int64
+	fs := newTestFS(0, &reloads)
+	ktm := NewKuTokenManager("kuscale.com/token", 5, filepath.Join(t.TempDir(), "kuscale.sock"), fs, testRoots)
+	const n = 8
+	ktm.newPodCh = make(chan string, n)
+	client := serve(t, ktm)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := &pluginapi.AllocateRequest{ContainerRequests: []*pluginapi.ContainerAllocateRequest{{DevicesIDs: []string{"kuscale.com/token-0"}}}}
+			if _, err := client.Allocate(context.Background(), req); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for i := 0; i < n; i++ {
+		msg := <-ktm.newPodCh
+		if seen[msg] {
+			t.Errorf("%q allocated twice", msg)
+		}
+		seen[msg] = true
+	}
+	for id := 0; id < n; id++ {
+		if !seen[fmt.Sprintf("%d:1", id)] {
+			t.Errorf("vGPU ID %d not allocated, got %v", id, seen)
+		}
+	}
+}
+
+func TestAllocateMountsIDOfGPURoot(t *testing.T) {
+	roots := kufs.Roots{Cgroup: "/cgroup", GPU: "/host/gpu", Proc: "/proc"}
+	fake, err := kugpu.NewFake(t.TempDir(), roots.GPU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ktm := NewKuTokenManager("kuscale.com/token", 5, filepath.Join(t.TempDir(), "kuscale.sock"), fake, roots)
+	ktm.newPodCh = make(chan string, 1)
+	client := serve(t, ktm)
+
+	req := &pluginapi.AllocateRequest{ContainerRequests: []*pluginapi.ContainerAllocateRequest{{DevicesIDs: []string{"kuscale.com/token-0"}}}}
+	resp, err := client.Allocate(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-ktm.newPodCh
+
+	if fake.Reloads() != 1 {
+		t.Errorf("%d reloads of resource_conf, want 1", fake.Reloads())
+	}
+	if total, err := kugpu.New(fake, roots.GPU).TotalIDs(); err != nil || total != 1 {
+		t.Errorf("module has %d IDs, %v, want 1", total, err)
+	}
+	for _, mount := range resp.ContainerResponses[0].Mounts {
+		if mount.ContainerPath == "/ku-gpu" && mount.HostPath != "/host/gpu/IDs/0" {
+			t.Errorf("/ku-gpu is mounted from %s, want /host/gpu/IDs/0", mount.HostPath)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kutrace
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kumonitor"
+	"k8s.io/klog"
+)
+
+const (
+	filePrefix = "trace-"
+	fileSuffix = ".jsonl"
+)
+
+// Recorder writes what a monitor does into rotating trace files.
+// It is a kumonitor.DecisionObserver and a kumonitor.ControlObserver.
+type Recorder struct {
+	mu sync.Mutex
+	w  *RotatingWriter
+	m  *kumonitor.Monitor
+}
+
+func NewRecorder(m *kumonitor.Monitor, dir string, maxSize int64, maxFiles int) (*Recorder, error) {
+	r := &Recorder{m: m}
+	w, err := NewRotatingWriter(dir, filePrefix, fileSuffix, maxSize, maxFiles, r.header)
+	if err != nil {
+		return nil, err
+	}
+	r.w = w
+	return r, nil
+}
+
+func (r *Recorder) header() []byte {
+	data, _ := json.Marshal(Record{
+		Type:           RecordHeader,
+		Time:           r.m.Now(),
+		Version:        Version,
+		Node:           r.m.NodeName(),
+		Period:         r.m.Period().Seconds(),
+		Policy:         r.m.Policy().Name(),
+		PolicyParams:   r.m.Policy().Params(),
+		MonitoringMode: r.m.MonitoringMode(),
+	})
+	return append(data, '\n')
+}
+
+func (r *Recorder) write(rec Record) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		klog.Info("Couldn't encode trace record : ", err)
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.w.Write(append(data, '\n')); err != nil {
+		klog.Info("Couldn't write trace record : ", err)
+	}
+}
+
+func (r *Recorder) PodAdded(now int64, pi *kumonitor.PodInfo) {
+	pr := newPodRecord(pi)
+	r.write(Record{Type: RecordEvent, Time: now, Event: EventAdded, Pod: &pr})
+}
+
+func (r *Recorder) PodCompleted(now int64, pi *kumonitor.PodInfo) {
+	pr := newPodRecord(pi)
+	r.write(Record{Type: RecordEvent, Time: now, Event: EventCompleted, Pod: &pr})
+}
+
+// Decided records the limits written out of the policy. Those of the policy
+// are in the next tick record.
+func (r *Recorder) Decided(d *kumonitor.Decision) {
+	if d.Outcome == kumonitor.OutcomeFailed {
+		return
+	}
+	rec := Record{Type: RecordEvent, Time: d.Time, PodName: d.Pod, Resource: string(d.Resource), Limit: d.NewLimit, Branch: d.Branch}
+	switch d.Branch {
+	case kumonitor.BranchManual, kumonitor.BranchAnnotation:
+		rec.Event, rec.Until = EventPinned, d.PinnedUntil
+	case kumonitor.BranchFailSafe:
+		rec.Event = EventFailSafe
+		// Called with the monitor locked
+		if pi, ok := r.m.RunningPodMap[kumonitor.PodKey(d.Namespace, d.Pod)]; ok {
+			rec.Stale = pi.FailSafe()
+		}
+	case kumonitor.BranchReapply:
+		rec.Event = EventReapplied
+	default:
+		return
+	}
+	r.write(rec)
+}
+
+// Controlled records the controls of the pods which change what the policy does.
+func (r *Recorder) Controlled(c *kumonitor.Control) {
+	rec := Record{Type: RecordEvent, Time: c.Time, PodName: c.Pod}
+	switch c.Action {
+	case kumonitor.ControlUnpin:
+		rec.Event, rec.Resource = EventUnpinned, string(c.Resource)
+	case kumonitor.ControlPause:
+		rec.Event = EventPaused
+	case kumonitor.ControlResume:
+		rec.Event = EventResumed
+	case kumonitor.ControlReservation:
+		rec.Event, rec.TokenReservation = EventReservation, c.TokenReservation
+	case kumonitor.ControlRecovered:
+		rec.Event = EventRecovered
+	default:
+		return
+	}
+	r.write(rec)
+}
+
+func (r *Recorder) Ticked(now int64, m *kumonitor.Monitor) {
+	rec := Record{Type: RecordTick, Time: now}
+	for _, pi := range m.RunningPodMap {
+		rec.Pods = append(rec.Pods, newPodRecord(pi))
+	}
+	sort.Slice(rec.Pods, func(i, j int) bool { return rec.Pods[i].Name < rec.Pods[j].Name })
+	r.write(rec)
+
+	r.mu.Lock()
+	r.w.Flush()
+	r.mu.Unlock()
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.w.Close()
+}
@@ -0,0 +1,261 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kutrace
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kufs"
+	"github.com/sslab-konkuk/KuScale/pkg/kumonitor"
+	"k8s.io/klog"
+)
+
+// replayHost feeds the recorded accumulated usages to the monitor. Limits
+// are not written anywhere, so the replay is open loop : the usages are
+// the ones measured under the limits of the recorded policy.
+type replayHost struct {
+	now   int64
+	acct  map[*kumonitor.ResourceInfo]uint64
+	ended map[*kumonitor.ResourceInfo]bool
+}
+
+func (h *replayHost) Now() int64 { return h.now }
+
+func (h *replayHost) ReadUsage(ri *kumonitor.ResourceInfo) (uint64, error) {
+	acct, ok := h.acct[ri]
+	if !ok || h.ended[ri] {
+		return 0, kufs.ErrNotExist
+	}
+	return acct, nil
+}
+
+func (h *replayHost) WriteLimit(ri *kumonitor.ResourceInfo, limit float64) error { return nil }
+
+// Divergence compares the replayed limits with the recorded ones.
+type Divergence struct {
+	Compared    int     `json:"compared"`
+	MeanAbsDiff float64 `json:"meanAbsDiff"`
+	MaxAbsDiff  float64 `json:"maxAbsDiff"`
+	sumAbsDiff  float64
+}
+
+type ReplayResult struct {
+	Ticks          int                    `json:"ticks"`
+	Pods           int                    `json:"pods"`
+	RecordedPolicy string                 `json:"recordedPolicy"`
+	ReplayedPolicy string                 `json:"replayedPolicy"`
+	Resources      map[string]*Divergence `json:"resources"`
+}
+
+type Replayer struct {
+	records []Record
+	header  Record
+	host    *replayHost
+	monitor *kumonitor.Monitor
+	pods    map[string]*kumonitor.PodInfo
+
+	// The pods held out of the policy, by the control API or stale readings
+	paused map[string]bool
+	stale  map[string]bool
+}
+
+// NewReplayer prepares a monitor with the settings of the trace header.
+// A nil policy replays the recorded policy with its recorded parameters.
+// Observers such as a Recorder can be added to Monitor() before Run().
+func NewReplayer(records []Record, policy kumonitor.Policy) (*Replayer, error) {
+	if len(records) == 0 || records[0].Type != RecordHeader {
+		return nil, fmt.Errorf("trace doesn't start with a header")
+	}
+	header := records[0]
+	if header.Period <= 0 {
+		return nil, fmt.Errorf("trace header has no monitoring period")
+	}
+	if policy == nil {
+		var err error
+		policy, err = kumonitor.NewPolicy(header.Policy, header.PolicyParams)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	host := &replayHost{
+		now:   header.Time,
+		acct:  make(map[*kumonitor.ResourceInfo]uint64),
+		ended: make(map[*kumonitor.ResourceInfo]bool),
+	}
+	period := time.Duration(header.Period * float64(time.Second))
+	monitor := kumonitor.NewMonitorWithHost(period, 15, header.Node, false, 0, host)
+	monitor.SetPolicy(policy)
+
+	return &Replayer{
+		records: records,
+		header:  header,
+		host:    host,
+		monitor: monitor,
+		pods:    make(map[string]*kumonitor.PodInfo),
+		paused:  make(map[string]bool),
+		stale:   make(map[string]bool),
+	}, nil
+}
+
+func (r *Replayer) Monitor() *kumonitor.Monitor { return r.monitor }
+
+func (r *Replayer) setAcct(pi *kumonitor.PodInfo, pr *PodRecord) {
+	for rn, ri := range pi.RIs {
+		if rr, ok := pr.Resources[string(rn)]; ok {
+			r.host.acct[ri] = rr.AcctUsage
+		}
+	}
+}
+
+func (r *Replayer) addPod(pr *PodRecord) {
+	pi := kumonitor.NewPodInfo(pr.Name, []kumonitor.ResourceName{"CPU", "GPU"})
+	pi.TokenReservation = pr.TokenReservation
+	r.setAcct(pi, pr)
+	r.pods[pr.Name] = pi
+	r.monitor.AddPod(pi)
+}
+
+/*
+Func Name : (r *Replayer) apply()
+Objective : 1) Apply a recorded limit or control out of the policy to the replayed monitor
+			2) Hold a pod out of the policy while it is paused or its readings are stale
+*/
+func (r *Replayer) apply(rec *Record) error {
+	m, name, rn := r.monitor, rec.PodName, kumonitor.ResourceName(rec.Resource)
+	hold := func() error { return m.SetPaused("", name, r.paused[name] || r.stale[name]) }
+	switch rec.Event {
+	case EventPinned:
+		return m.PinLimit("", name, rn, rec.Limit, rec.Until)
+	case EventUnpinned:
+		return m.Unpin("", name, rn)
+	case EventPaused, EventResumed:
+		if name == "" {
+			return m.SetPaused("", "", rec.Event == EventPaused)
+		}
+		r.paused[name] = rec.Event == EventPaused
+		return hold()
+	case EventReservation:
+		return m.SetTokenReservation("", name, rec.TokenReservation)
+	case EventFailSafe:
+		if err := m.SetLimit("", name, rn, rec.Limit, kumonitor.BranchFailSafe); err != nil {
+			return err
+		}
+		if rec.Stale {
+			r.stale[name] = true
+			return hold()
+		}
+	case EventRecovered:
+		r.stale[name] = false
+		return hold()
+	case EventReapplied:
+		return m.SetLimit("", name, rn, rec.Limit, kumonitor.BranchReapply)
+	}
+	return nil
+}
+
+/*
+Func Name : Run()
+Objective : 1) Replay the pod lifecycle events, and the limits and controls out of the policy, at their recorded time
+			2) Run the monitor on every recorded tick with the recorded usages
+			3) Compare the decisions with the recorded limits
+*/
+func (r *Replayer) Run() *ReplayResult {
+	result := &ReplayResult{
+		RecordedPolicy: r.header.Policy,
+		ReplayedPolicy: r.monitor.Policy().Name(),
+		Resources:      make(map[string]*Divergence),
+	}
+
+	for i := range r.records {
+		rec := &r.records[i]
+		switch rec.Type {
+		case RecordEvent:
+			if rec.Pod == nil {
+				r.host.now = rec.Time
+				if err := r.apply(rec); err != nil {
+					klog.V(4).Info("Couldn't replay the ", rec.Event, " event of ", rec.PodName, " : ", err)
+				}
+				continue
+			}
+			r.host.now = rec.Time
+			pi, ok := r.pods[rec.Pod.Name]
+			switch rec.Event {
+			case EventAdded:
+				if !ok {
+					r.addPod(rec.Pod)
+				}
+			case EventCompleted:
+				if ok {
+					for _, ri := range pi.RIs {
+						r.host.ended[ri] = true
+					}
+				}
+				delete(r.paused, rec.Pod.Name)
+				delete(r.stale, rec.Pod.Name)
+			}
+
+		case RecordTick:
+			r.host.now = rec.Time
+			for j := range rec.Pods {
+				pr := &rec.Pods[j]
+				if pi, ok := r.pods[pr.Name]; ok {
+					r.setAcct(pi, pr)
+				} else {
+					// The trace was rotated or started after the pod was added
+					r.addPod(pr)
+				}
+			}
+			r.monitor.MonitorAndAutoScale()
+			result.Ticks++
+			if r.header.MonitoringMode {
+				continue
+			}
+			for j := range rec.Pods {
+				pr := &rec.Pods[j]
+				pi, ok := r.monitor.RunningPodMap[pr.Name]
+				if !ok {
+					continue
+				}
+				for rn, rr := range pr.Resources {
+					ri, ok := pi.RIs[kumonitor.ResourceName(rn)]
+					if !ok {
+						continue
+					}
+					d, ok := result.Resources[rn]
+					if !ok {
+						d = &Divergence{}
+						result.Resources[rn] = d
+					}
+					diff := math.Abs(ri.Limit() - rr.Limit)
+					d.Compared++
+					d.sumAbsDiff += diff
+					d.MaxAbsDiff = math.Max(d.MaxAbsDiff, diff)
+				}
+			}
+		}
+	}
+
+	for _, d := range result.Resources {
+		if d.Compared > 0 {
+			d.MeanAbsDiff = d.sumAbsDiff / float64(d.Compared)
+		}
+	}
+	result.Pods = len(r.pods)
+	klog.V(4).Info("Replayed ", result.Ticks, " ticks of ", result.Pods, " pods")
+	return result
+}
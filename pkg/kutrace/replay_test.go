@@ -0,0 +1,189 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kutrace
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kuclock"
+	"github.com/sslab-konkuk/KuScale/pkg/kufs"
+	"github.com/sslab-konkuk/KuScale/pkg/kumonitor"
+	"github.com/sslab-konkuk/KuScale/pkg/kuprofiler"
+)
+
+var testRoots = kufs.Roots{Cgroup: "/cgroup", GPU: "/gpu", Proc: "/proc"}
+
+// testPod is the cgroup and the vGPU ID of a pod on a MemFS.
+type testPod struct {
+	pi       *kumonitor.PodInfo
+	cpuUsage string
+	gpuUsage string
+	cpu, gpu uint64 // accumulated usages, in ns
+}
+
+func newTestPod(fs *kufs.MemFS, id int, reservation float64) *testPod {
+	cpuPath := kufs.Join(testRoots.Cgroup, "cpu/kubepods", fmt.Sprint("pod", id))
+	gpuPath := kufs.Join(testRoots.GPU, "IDs", fmt.Sprint(id))
+	fs.SetFile(cpuPath+"/cpu.stat", []byte("usage_usec 0\n"))
+	fs.SetFile(cpuPath+"/cpu.cfs_quota_us", []byte("-1"))
+	for _, name := range []string{"total_runtime", "gpu_limit", "gpu_request"} {
+		fs.SetFile(kufs.Join(gpuPath, name), []byte("0"))
+	}
+	pi := kumonitor.NewPodInfoWithPaths(fmt.Sprint("pod", id), cpuPath, gpuPath)
+	pi.TokenReservation = reservation
+	return &testPod{pi: pi, cpuUsage: cpuPath + "/cpu.stat", gpuUsage: gpuPath + "/total_runtime"}
+}
+
+// use adds usage to the accounted usages of p, in ns of CPU and GPU time.
+func (p *testPod) use(fs *kufs.MemFS, cpu, gpu uint64) {
+	p.cpu += cpu
+	p.gpu += gpu
+	fs.SetFile(p.cpuUsage, []byte(fmt.Sprintf("usage_usec %d\n", p.cpu/1000)))
+	fs.SetFile(p.gpuUsage, []byte(fmt.Sprint(p.gpu)))
+}
+
+// record runs a monitor of a MemFS for a while with pins, pauses and a
+// token reservation change, and returns the directory of its trace.
+func record(t *testing.T) string {
+	t.Helper()
+	kuprofiler.NewLatencyInfo(false)
+	fs := kufs.NewMemFS()
+	for name, data := range map[string]string{
+		"configs/init": "", "configs/destroy": "", "configs/totalIDs": "0", "gemini/resource_conf": "",
+	} {
+		fs.SetFile(kufs.Join(testRoots.GPU, name), []byte(data))
+	}
+	clock := kuclock.NewFake(int64(1e18))
+	m := kumonitor.NewMonitorWithHost(time.Second, 15, "node", false, 0, kumonitor.NewFSHostWithClock(fs, testRoots, clock))
+
+	dir := t.TempDir()
+	rec, err := NewRecorder(m, dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.AddObserver(rec)
+
+	pods := []*testPod{newTestPod(fs, 0, 100), newTestPod(fs, 1, 200), newTestPod(fs, 2, 300)}
+	for _, p := range pods {
+		m.AddPod(p.pi)
+	}
+	for i := 0; i < 30; i++ {
+		switch i {
+		case 5:
+			if err := m.PinLimit("", "pod1", "CPU", 150, m.Now()+int64(5*time.Second)); err != nil {
+				t.Fatal(err)
+			}
+		case 8:
+			if err := m.SetPaused("", "pod2", true); err != nil {
+				t.Fatal(err)
+			}
+		case 14:
+			if err := m.SetPaused("", "pod2", false); err != nil {
+				t.Fatal(err)
+			}
+		case 18:
+			if err := m.SetTokenReservation("", "pod0", 500); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for j, p := range pods {
+			// Every pod uses a different share of its limits, so they change
+			p.use(fs, uint64(j+1)*uint64(i%7+1)*1e8, uint64(j+1)*uint64(i%5+1)*5e7)
+		}
+		clock.Advance(time.Second)
+		m.MonitorAndAutoScale()
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// limits returns the limits of every tick of a trace, by pod and resource.
+func limits(records []Record) []map[string]float64 {
+	var ticks []map[string]float64
+	for _, rec := range records {
+		if rec.Type != RecordTick {
+			continue
+		}
+		tick := make(map[string]float64)
+		for _, pr := range rec.Pods {
+			for rn, rr := range pr.Resources {
+				tick[pr.Name+"/"+rn] = rr.Limit
+			}
+		}
+		ticks = append(ticks, tick)
+	}
+	return ticks
+}
+
+func TestReplayReproducesRecordedLimits(t *testing.T) {
+	records, err := ReadTrace(record(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReplayer(records, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	rec, err := NewRecorder(r.Monitor(), dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Monitor().AddObserver(rec)
+	res := r.Run()
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Ticks != 30 || res.Pods != 3 {
+		t.Errorf("replayed %d ticks of %d pods, want 30 ticks of 3 pods", res.Ticks, res.Pods)
+	}
+	for _, rn := range []string{"CPU", "GPU"} {
+		d, ok := res.Resources[rn]
+		if !ok || d.Compared != 90 {
+			t.Fatalf("%s limits compared = %+v, want 90", rn, d)
+		}
+		if d.MaxAbsDiff != 0 {
+			t.Errorf("%s limits diverged by up to %v", rn, d.MaxAbsDiff)
+		}
+	}
+
+	replayed, err := ReadTrace(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, got := limits(records), limits(replayed)
+	if len(got) != len(want) {
+		t.Fatalf("replayed %d ticks, want %d", len(got), len(want))
+	}
+	changed := false
+	for i := range want {
+		for k, limit := range want[i] {
+			if got[i][k] != limit {
+				t.Errorf("tick %d : %s limit = %v, want %v", i, k, got[i][k], limit)
+			}
+			if i > 0 && want[i-1][k] != limit {
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		t.Error("the recorded limits never changed")
+	}
+}
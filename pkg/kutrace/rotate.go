@@ -0,0 +1,123 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kutrace
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// RotatingWriter writes into files of dir named prefix<time>suffix, and
+// moves on to a new file once the current one grows over maxSize bytes.
+// Only the last maxFiles files are kept, 0 keeps them all.
+type RotatingWriter struct {
+	dir, prefix, suffix string
+	maxSize             int64
+	maxFiles            int
+	// header is written at the beginning of every file.
+	header func() []byte
+
+	f    *os.File
+	w    *bufio.Writer
+	size int64
+}
+
+func NewRotatingWriter(dir, prefix, suffix string, maxSize int64, maxFiles int, header func() []byte) (*RotatingWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	rw := &RotatingWriter{dir: dir, prefix: prefix, suffix: suffix,
+		maxSize: maxSize, maxFiles: maxFiles, header: header}
+	if err := rw.rotate(); err != nil {
+		return nil, err
+	}
+	return rw, nil
+}
+
+func (rw *RotatingWriter) rotate() error {
+	if rw.f != nil {
+		rw.w.Flush()
+		rw.f.Close()
+	}
+
+	name := filepath.Join(rw.dir, fmt.Sprintf("%s%s%s", rw.prefix, time.Now().UTC().Format("20060102T150405.000000000"), rw.suffix))
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		rw.f, rw.w = nil, nil
+		return err
+	}
+	rw.f, rw.w, rw.size = f, bufio.NewWriter(f), 0
+	klog.V(5).Info("Writing ", name)
+
+	if rw.header != nil {
+		n, err := rw.w.Write(rw.header())
+		rw.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	rw.removeOldFiles()
+	return nil
+}
+
+func (rw *RotatingWriter) removeOldFiles() {
+	if rw.maxFiles <= 0 {
+		return
+	}
+	files, err := filepath.Glob(filepath.Join(rw.dir, rw.prefix+"*"+rw.suffix))
+	if err != nil || len(files) <= rw.maxFiles {
+		return
+	}
+	sort.Strings(files)
+	for _, file := range files[:len(files)-rw.maxFiles] {
+		if err := os.Remove(file); err != nil {
+			klog.Info("Couldn't remove ", file, " : ", err)
+		}
+	}
+}
+
+func (rw *RotatingWriter) Write(p []byte) (int, error) {
+	if rw.f == nil || (rw.maxSize > 0 && rw.size > 0 && rw.size+int64(len(p)) > rw.maxSize) {
+		if err := rw.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rw.w.Write(p)
+	rw.size += int64(n)
+	return n, err
+}
+
+func (rw *RotatingWriter) Flush() error {
+	if rw.w == nil {
+		return nil
+	}
+	return rw.w.Flush()
+}
+
+func (rw *RotatingWriter) Close() error {
+	if rw.f == nil {
+		return nil
+	}
+	rw.w.Flush()
+	err := rw.f.Close()
+	rw.f, rw.w = nil, nil
+	return err
+}
@@ -0,0 +1,177 @@
+// Copyright 2022 Hyeon-Jun Jang, SSLab, Konkuk University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kutrace
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/sslab-konkuk/KuScale/pkg/kumonitor"
+)
+
+// Version of the trace format. Readers refuse traces of a newer version.
+//
+// A trace is JSON lines. Every file starts with a header record, followed by
+// event records for the pod lifecycle and the limits and controls out of the
+// policy, and tick records for every monitoring period.
+//
+// Version 2 added the events of pins, unpins, pauses, token reservations,
+// fail-safe limits and reapplied limits.
+const Version = 2
+
+const (
+	RecordHeader = "header"
+	RecordEvent  = "event"
+	RecordTick   = "tick"
+
+	EventAdded     = "added"
+	EventCompleted = "completed"
+
+	EventPinned      = "pinned"      // by the control API or an annotation, until Until
+	EventUnpinned    = "unpinned"    // given back to the policy
+	EventPaused      = "paused"      // of PodName, or every pod if empty
+	EventResumed     = "resumed"     // of PodName, or every pod if empty
+	EventReservation = "reservation" // TokenReservation of PodName changed
+	EventFailSafe    = "failSafe"    // written by the watchdog, held until EventRecovered if Stale
+	EventRecovered   = "recovered"   // the usage readings of PodName came back
+	EventReapplied   = "reapplied"   // written again by the reconciler over a drift
+)
+
+type Record struct {
+	Type string `json:"type"`
+	Time int64  `json:"time"` // ns
+
+	/* header */
+	Version        int                `json:"version,omitempty"`
+	Node           string             `json:"node,omitempty"`
+	Period         float64            `json:"period,omitempty"` // seconds
+	Policy         string             `json:"policy,omitempty"`
+	PolicyParams   map[string]float64 `json:"policyParams,omitempty"`
+	MonitoringMode bool               `json:"monitoringMode,omitempty"`
+
+	/* event */
+	Event string     `json:"event,omitempty"`
+	Pod   *PodRecord `json:"pod,omitempty"` // of added and completed
+
+	/* event of a limit or a control */
+	PodName          string  `json:"podName,omitempty"`
+	Resource         string  `json:"resource,omitempty"`
+	Limit            float64 `json:"limit,omitempty"`
+	Until            int64   `json:"until,omitempty"` // ns
+	Branch           string  `json:"branch,omitempty"`
+	Stale            bool    `json:"stale,omitempty"` // the usage readings of the pod are stale
+	TokenReservation float64 `json:"tokenReservation,omitempty"`
+
+	/* tick */
+	Pods []PodRecord `json:"pods,omitempty"`
+}
+
+type PodRecord struct {
+	Name             string                    `json:"name"`
+	TokenReservation float64                   `json:"tokenReservation"`
+	TokenQueue       float64                   `json:"tokenQueue"`
+	AvailableToken   float64                   `json:"availableToken"`
+	UpdatedCount     int64                     `json:"updatedCount"`
+	Resources        map[string]ResourceRecord `json:"resources"`
+}
+
+type ResourceRecord struct {
+	AcctUsage     uint64  `json:"acctUsage"`
+	Usage         float64 `json:"usage"`
+	AvgUsage      float64 `json:"avgUsage"`
+	DynamicWeight float64 `json:"dynamicWeight"`
+	Limit         float64 `json:"limit"`
+}
+
+func newPodRecord(pi *kumonitor.PodInfo) PodRecord {
+	pr := PodRecord{
+		Name:             pi.PodName,
+		TokenReservation: pi.TokenReservation,
+		TokenQueue:       pi.TokenQueue,
+		AvailableToken:   pi.AvailableToken(),
+		UpdatedCount:     pi.UpdatedCount,
+		Resources:        make(map[string]ResourceRecord),
+	}
+	for rn, ri := range pi.RIs {
+		pr.Resources[string(rn)] = ResourceRecord{
+			AcctUsage:     ri.AcctUsage(),
+			Usage:         ri.Usage(),
+			AvgUsage:      ri.AvgUsage(),
+			DynamicWeight: ri.DynamicWeight(),
+			Limit:         ri.Limit(),
+		}
+	}
+	return pr
+}
+
+// TraceFiles returns path itself if it is a file, or the trace files in it
+// in the order they were written.
+func TraceFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	files, err := filepath.Glob(filepath.Join(path, filePrefix+"*"+fileSuffix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// ReadTrace reads all records of a trace file or a directory of trace files.
+func ReadTrace(path string) ([]Record, error) {
+	files, err := TraceFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []Record
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		line := 0
+		for scanner.Scan() {
+			line++
+			var r Record
+			if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("%s:%d: %s", file, line, err)
+			}
+			if r.Type == RecordHeader && r.Version > Version {
+				f.Close()
+				return nil, fmt.Errorf("%s: trace version %d is newer than %d", file, r.Version, Version)
+			}
+			records = append(records, r)
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+	}
+	return records, nil
+}
@@ -4,11 +4,11 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
-	"io/ioutil"
-	"os"
 	"strings"
 
 	bpf "github.com/iovisor/gobpf/bcc"
+	"github.com/sslab-konkuk/KuScale/pkg/kufs"
+	"github.com/sslab-konkuk/KuScale/pkg/kuhealth"
 	"github.com/sslab-konkuk/KuScale/pkg/kuprofiler"
 	"k8s.io/klog"
 )
@@ -49,14 +49,12 @@ type cuEvent struct {
 	Ts   uint64
 }
 
-func BpfWatcher(ebpfCh chan string, stopCh chan string) {
-
-	klog.V(4).Infof("Run BpfWatcher")
-
-	pidToIdMap := make(map[uint32]string)
-
-	funcNames := []string{"cuLaunchKernel"}
-
+/*
+Func Name : loadBpf()
+Objective : 1) Compile the ebpf program and attach the uprobes to libgemhook
+			2) Open the perf map of the events
+*/
+func loadBpf(funcNames []string, hook string, channel chan []byte) (*bpf.Module, *bpf.PerfMap, error) {
 	ebpfSource := source
 	for i, name := range funcNames {
 		traceName := "trace_" + name
@@ -66,32 +64,60 @@ func BpfWatcher(ebpfCh chan string, stopCh chan string) {
 	}
 
 	bpfModule := bpf.NewModule(ebpfSource, []string{})
-	defer bpfModule.Close()
+	if bpfModule == nil {
+		return nil, nil, fmt.Errorf("couldn't compile the ebpf program")
+	}
 
 	for _, name := range funcNames {
 		traceName := "trace_" + name
 		Uprobe, err := bpfModule.LoadUprobe(traceName)
 		if err != nil {
-			klog.Errorf("Failed to load cuLaunchKernel: %s\n", err)
-			os.Exit(1)
+			bpfModule.Close()
+			return nil, nil, fmt.Errorf("failed to load %s: %w", name, err)
 		}
 
-		err = bpfModule.AttachUprobe("/kubeshare/library/libgemhook.so.1", name, Uprobe, -1)
+		err = bpfModule.AttachUprobe(hook, name, Uprobe, -1)
 		if err != nil {
-			klog.Errorf("Failed to attach return_value: %s\n", err)
-			os.Exit(1)
+			bpfModule.Close()
+			return nil, nil, fmt.Errorf("failed to attach %s: %w", name, err)
 		}
 	}
 
 	table := bpf.NewTable(bpfModule.TableId("ebpf_events"), bpfModule)
 
+	perfMap, err := bpf.InitPerfMap(table, channel, nil)
+	if err != nil {
+		bpfModule.Close()
+		return nil, nil, fmt.Errorf("failed to init perf map: %w", err)
+	}
+	return bpfModule, perfMap, nil
+}
+
+func BpfWatcher(ebpfCh chan string, stopCh chan string, fs kufs.FS, procRoot, hook string) {
+
+	klog.V(4).Infof("Run BpfWatcher")
+	health := kuhealth.Register("bpfwatcher")
+
+	pidToIdMap := make(map[uint32]string)
+
+	funcNames := []string{"cuLaunchKernel"}
+
 	channel := make(chan []byte)
 
-	perfMap, err := bpf.InitPerfMap(table, channel, nil)
+	// The monitor keeps running on its timer until the ebpf program is attached
+	var bpfModule *bpf.Module
+	var perfMap *bpf.PerfMap
+	err := kuhealth.Retry(stopCh, kuhealth.DefaultBackoff, health, func() error {
+		var err error
+		bpfModule, perfMap, err = loadBpf(funcNames, hook, channel)
+		return err
+	})
 	if err != nil {
-		klog.Errorf("Failed to init perf map: %s\n", err)
-		os.Exit(1)
+		klog.Error("BpfWatcher gave up : ", err)
+		health.Stopped()
+		return
 	}
+	defer bpfModule.Close()
 
 	klog.V(4).Infof("Insert Ebpef")
 	go func(ebpfCh chan string) {
@@ -109,7 +135,7 @@ func BpfWatcher(ebpfCh chan string, stopCh chan string) {
 			id, ok := pidToIdMap[event.Pid]
 			if ok {
 				klog.V(4).Info("Found New PID")
-				id = getIDfromPID(event.Pid)
+				id = getIDfromPID(fs, procRoot, event.Pid)
 				pidToIdMap[event.Pid] = id
 			}
 			ebpfCh <- id
@@ -121,11 +147,16 @@ func BpfWatcher(ebpfCh chan string, stopCh chan string) {
 	klog.V(4).Info("Starting bpfWatcher")
 	<-stopCh
 	perfMap.Stop()
+	health.Stopped()
 	klog.V(4).Info("Shutting bpfWatcher Down")
 }
 
-func getIDfromPID(pid uint32) string {
-	data, _ := ioutil.ReadFile(fmt.Sprintf("/home/proc/%d/cgroup", pid))
+func getIDfromPID(fs kufs.FS, procRoot string, pid uint32) string {
+	data, err := fs.ReadFile(fmt.Sprintf("%s/%d/cgroup", procRoot, pid))
+	if err != nil {
+		klog.V(4).Info("Couldn't find the container of ", pid, " : ", err)
+		return ""
+	}
 	lines := strings.Split(string(data), "\n")
 	for i := range lines {
 		if strings.Contains(lines[i], "cpu") {
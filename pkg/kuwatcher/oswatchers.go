@@ -3,11 +3,18 @@ package kuwatcher
 import (
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"k8s.io/klog"
 )
 
+// settleTime is how long a file should stay unchanged before it is read, as
+// editors write it in several steps.
+const settleTime = 200 * time.Millisecond
+
 func newFSWatcher(files ...string) (*fsnotify.Watcher, error) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
@@ -25,6 +32,68 @@ func newFSWatcher(files ...string) (*fsnotify.Watcher, error) {
 	return watcher, nil
 }
 
+/*
+Func Name : FileWatcher()
+Objective : 1) Watch the directory of file, as editors and ConfigMaps replace the file
+			2) Send on the returned channel once the file settled after a change
+			3) Close the channel when stopCh is closed
+*/
+func FileWatcher(file string, stopCh chan string) (chan struct{}, error) {
+	watcher, err := newFSWatcher(filepath.Dir(file))
+	if err != nil {
+		return nil, err
+	}
+
+	changeCh := make(chan struct{}, 1)
+	go func() {
+		defer watcher.Close()
+		defer close(changeCh)
+		var settled <-chan time.Time
+		for {
+			select {
+			case event := <-watcher.Events:
+				// A ConfigMap volume swaps its ..data symlink
+				name := filepath.Base(event.Name)
+				if name != filepath.Base(file) && name != "..data" {
+					continue
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+					settled = time.After(settleTime)
+				}
+			case <-settled:
+				settled = nil
+				select {
+				case changeCh <- struct{}{}:
+				default:
+				}
+			case err := <-watcher.Errors:
+				klog.Error("FileWatcher of ", file, " : ", err)
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return changeCh, nil
+}
+
+// OpSignals ask KuScale for an operation instead of a shutdown : SIGHUP
+// reloads the config, SIGUSR1 dumps the state and SIGUSR2 toggles the
+// decision tracing.
+var OpSignals = []os.Signal{syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2}
+
+// OpSignalWatcher sends the OpSignals on the returned channel until stopCh is
+// closed. The receiver does the operation, so it is never done by two
+// goroutines at once.
+func OpSignalWatcher(stopCh chan string) chan os.Signal {
+	sigCh := make(chan os.Signal, len(OpSignals))
+	signal.Notify(sigCh, OpSignals...)
+	go func() {
+		<-stopCh
+		signal.Stop(sigCh)
+	}()
+	return sigCh
+}
+
 func SignalWatcher() chan string {
 	stopCh := make(chan string)
 	shutdownSignals := []os.Signal{os.Interrupt, syscall.SIGTERM}